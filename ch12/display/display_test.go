@@ -26,11 +26,35 @@ func Example_expr() {
 	// Display e (eval.call):
 	// e.fn = "sqrt"
 	// e.args[0].type = eval.binary
-	// e.args[0].value.op = 47
-	// e.args[0].value.x.type = eval.Var
-	// e.args[0].value.x.value = "A"
-	// e.args[0].value.y.type = eval.Var
-	// e.args[0].value.y.value = "pi"
+	// e.args[0].value.op = "/"
+	// e.args[0].value.x.type = eval.varRef
+	// e.args[0].value.x.value.Var = "A"
+	// e.args[0].value.x.value.span.Start.Offset = 5
+	// e.args[0].value.x.value.span.Start.Line = 1
+	// e.args[0].value.x.value.span.Start.Column = 6
+	// e.args[0].value.x.value.span.End.Offset = 6
+	// e.args[0].value.x.value.span.End.Line = 1
+	// e.args[0].value.x.value.span.End.Column = 7
+	// e.args[0].value.y.type = eval.varRef
+	// e.args[0].value.y.value.Var = "pi"
+	// e.args[0].value.y.value.span.Start.Offset = 9
+	// e.args[0].value.y.value.span.Start.Line = 1
+	// e.args[0].value.y.value.span.Start.Column = 10
+	// e.args[0].value.y.value.span.End.Offset = 11
+	// e.args[0].value.y.value.span.End.Line = 1
+	// e.args[0].value.y.value.span.End.Column = 12
+	// e.args[0].value.span.Start.Offset = 5
+	// e.args[0].value.span.Start.Line = 1
+	// e.args[0].value.span.Start.Column = 6
+	// e.args[0].value.span.End.Offset = 11
+	// e.args[0].value.span.End.Line = 1
+	// e.args[0].value.span.End.Column = 12
+	// e.span.Start.Offset = 0
+	// e.span.Start.Line = 1
+	// e.span.Start.Column = 1
+	// e.span.End.Offset = 12
+	// e.span.End.Line = 1
+	// e.span.End.Column = 13
 }
 
 func Example_slice() {
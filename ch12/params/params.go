@@ -7,73 +7,283 @@
 package params
 
 import (
+	"encoding"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //!+Unpack
 
 // Unpack populates the fields of the struct pointed to by ptr
 // from the HTTP request parameters in req.
+//
+// A field's parameter name is given by its "http" tag, or is else its
+// name in lower case; fields tagged "-" and unexported fields are
+// ignored, as are parameters that match no field.  A name a.b denotes
+// the field b of the struct field a, and m[k] the element with key k
+// of the map field m; these may be combined, as in addr.phone[home].
+// Each value of a repeated parameter is appended to a slice field.
+// Pointers, including pointers to structs, are allocated only when
+// a parameter refers to them, so a nil pointer field denotes an
+// absent optional parameter.
+//
+// A field may have any boolean, numeric or string kind, or a type that
+// implements encoding.TextUnmarshaler; or be a time.Time, parsed using
+// the layout in its "layout" tag (RFC 3339 by default), or a
+// time.Duration, parsed by time.ParseDuration or, if its "layout"
+// tag names a unit such as "s" or "ms", as a number of that unit;
+// or be a pointer, slice or map of such types.
+//
+// After storing the parameters, Unpack checks each field against the
+// rules in its "validate" tag; see Validate.  If any parameter cannot
+// be parsed or any field fails its rules, Unpack returns an Errors
+// listing all of them.
 func Unpack(req *http.Request, ptr interface{}) error {
 	if err := req.ParseForm(); err != nil {
 		return err
 	}
-
-	// Build map of fields keyed by effective name.
-	fields := make(map[string]reflect.Value)
 	v := reflect.ValueOf(ptr).Elem() // the struct variable
-	for i := 0; i < v.NumField(); i++ {
-		fieldInfo := v.Type().Field(i) // a reflect.StructField
-		tag := fieldInfo.Tag           // a reflect.StructTag
-		name := tag.Get("http")
-		if name == "" {
-			name = strings.ToLower(fieldInfo.Name)
-		}
-		fields[name] = v.Field(i)
-	}
 
 	// Update struct field for each parameter in the request.
-	for name, values := range req.Form {
-		f := fields[name]
-		if !f.IsValid() {
-			continue // ignore unrecognized HTTP parameters
-		}
-		for _, value := range values {
-			if f.Kind() == reflect.Slice {
-				elem := reflect.New(f.Type().Elem()).Elem()
-				if err := populate(elem, value); err != nil {
-					return fmt.Errorf("%s: %v", name, err)
-				}
-				f.Set(reflect.Append(f, elem))
-			} else {
-				if err := populate(f, value); err != nil {
-					return fmt.Errorf("%s: %v", name, err)
-				}
+	var names []string
+	for name := range req.Form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs Errors
+	failed := make(map[string]bool) // parameters that could not be parsed
+	for _, name := range names {
+		for _, value := range req.Form[name] {
+			if err := bind(v, name, value); err != nil {
+				errs = append(errs, &FieldError{Param: name, Err: err})
+				failed[name] = true
+				break
 			}
 		}
 	}
-	return nil
+
+	if err := validate(v, "", failed, &errs); err != nil {
+		return err
+	}
+	return errs.sorted()
 }
 
 //!-Unpack
 
+// bind stores value in the variable denoted by the parameter name,
+// relative to the struct v.  Parameters that refer to no field are
+// ignored, without allocating any pointers along their path.
+func bind(v reflect.Value, name, value string) error {
+	i, path := field(v.Type(), name)
+	if i < 0 || !refers(v.Type().Field(i).Type, path) {
+		return nil // ignore unrecognized HTTP parameters
+	}
+	return bindPath(v.Field(i), path, value, v.Type().Field(i).Tag.Get("layout"))
+}
+
+// field returns the index of the field of struct type t denoted by
+// the first component of the parameter name, and the rest of the name;
+// or -1 if there is no such field.
+func field(t reflect.Type, name string) (int, string) {
+	head, path := name, ""
+	if i := strings.IndexAny(name, ".["); i >= 0 {
+		head, path = name[:i], name[i:]
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if n := paramName(sf); !sf.IsExported() || n == "" || n != head {
+			continue
+		}
+		return i, path
+	}
+	return -1, ""
+}
+
+// refers reports whether each struct field named by path, relative to
+// a variable of type t, exists.  Malformed paths are left to bindPath.
+func refers(t reflect.Type, path string) bool {
+	for path != "" {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch {
+		case path[0] == '.' && t.Kind() == reflect.Struct:
+			i, rest := field(t, path[1:])
+			if i < 0 {
+				return false
+			}
+			t, path = t.Field(i).Type, rest
+		case path[0] == '[' && t.Kind() == reflect.Map:
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return true
+			}
+			t, path = t.Elem(), path[end+1:]
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// paramName returns the parameter name of a struct field,
+// or "" if the field has none.
+func paramName(sf reflect.StructField) string {
+	name := sf.Tag.Get("http")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(sf.Name)
+	}
+	return name
+}
+
+// bindPath stores value in the variable denoted by path, such as
+// ".city" or "[home]", relative to v.  Layout is the "layout"
+// tag of the struct field that contains it.
+func bindPath(v reflect.Value, path, value, layout string) error {
+	if path == "" {
+		return set(v, value, layout)
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch {
+	case path[0] == '.' && v.Kind() == reflect.Struct:
+		return bind(v, path[1:], value)
+
+	case path[0] == '[' && v.Kind() == reflect.Map:
+		end := strings.IndexByte(path, ']')
+		if end < 0 {
+			return fmt.Errorf("missing ] in %s", path)
+		}
+		key := reflect.New(v.Type().Key()).Elem()
+		if err := set(key, path[1:end], ""); err != nil {
+			return fmt.Errorf("key %s: %v", path[1:end], err)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		// Map elements are not variables, so update a copy.
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if old := v.MapIndex(key); old.IsValid() {
+			elem.Set(old)
+		}
+		if err := bindPath(elem, path[end+1:], value, layout); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	}
+	return fmt.Errorf("cannot apply %s to %s", path, v.Type())
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// units maps the names of units of time to their durations.
+var units = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// set stores value in v, allocating pointers and appending
+// to slices as needed.
+func set(v reflect.Value, value, layout string) error {
+	switch {
+	case v.Type() == timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+
+	case v.Type() == durationType:
+		unit, ok := units[layout]
+		if f, err := strconv.ParseFloat(value, 64); ok && err == nil {
+			// Reject NaN, infinities and durations beyond ±292 years.
+			d := f * float64(unit)
+			if !(d >= -(1<<63) && d < 1<<63) {
+				return fmt.Errorf("invalid duration %q", value)
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+
+	case reflect.PointerTo(v.Type()).Implements(textUnmarshalerType):
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return set(v.Elem(), value, layout)
+
+	case v.Kind() == reflect.Slice:
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := set(elem, value, layout); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, elem))
+
+	default:
+		return populate(v, value)
+	}
+	return nil
+}
+
 //!+populate
 func populate(v reflect.Value, value string) error {
 	switch v.Kind() {
 	case reflect.String:
 		v.SetString(value)
 
-	case reflect.Int:
-		i, err := strconv.ParseInt(value, 10, 64)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
 		if err != nil {
 			return err
 		}
 		v.SetInt(i)
 
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+
 	case reflect.Bool:
 		b, err := strconv.ParseBool(value)
 		if err != nil {
@@ -87,4 +297,4 @@ func populate(v reflect.Value, value string) error {
 	return nil
 }
 
-//!-populate
\ No newline at end of file
+//!-populate
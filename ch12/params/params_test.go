@@ -0,0 +1,227 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package params
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type address struct {
+	City  string
+	Zip   *uint32
+	Phone map[string]string
+}
+
+type form struct {
+	Labels   []string `http:"l"`
+	Max      int      `http:"max"`
+	Exact    bool     `http:"x"`
+	Small    int8
+	Count    uint64
+	Ratio    float32
+	Limit    *int
+	Since    time.Time     `layout:"2006-01-02"`
+	At       time.Time     // RFC 3339
+	Timeout  time.Duration // time.ParseDuration
+	Interval time.Duration `layout:"s"`
+	Addr     address
+	Work     *address
+	Scores   map[string]int
+	Days     map[int][]time.Time `layout:"2006-01-02"`
+	IP       net.IP
+	Skip     string            `http:"-"`
+	Admin    bool              `http:"-"`
+	Secrets  map[string]string `http:"-"`
+	Private  *address          `http:"-"`
+	hidden   string
+}
+
+func TestUnpack(t *testing.T) {
+	limit := 5
+	zip := uint32(10001)
+	for _, test := range []struct {
+		query string
+		want  form
+	}{
+		{"", form{Max: 10}},
+		{"l=golang&l=programming&max=100&x=true", form{Labels: []string{"golang", "programming"}, Max: 100, Exact: true}},
+		{"small=-128&count=18446744073709551615&ratio=0.5&limit=5",
+			form{Max: 10, Small: -128, Count: 18446744073709551615, Ratio: 0.5, Limit: &limit}},
+		{"since=2016-01-02&at=2016-01-02T15:04:05Z&timeout=1m30s&interval=2.5",
+			form{
+				Max:      10,
+				Since:    time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC),
+				At:       time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC),
+				Timeout:  90 * time.Second,
+				Interval: 2500 * time.Millisecond,
+			}},
+		{"interval=1h", form{Max: 10, Interval: time.Hour}},
+		{"addr.city=Boston&work.city=NYC&work.zip=10001&work.phone[desk]=555&addr.phone[home]=123",
+			form{
+				Max:  10,
+				Addr: address{City: "Boston", Phone: map[string]string{"home": "123"}},
+				Work: &address{City: "NYC", Zip: &zip, Phone: map[string]string{"desk": "555"}},
+			}},
+		{"scores[go]=1&scores[c]=2&days[1]=2016-01-01&days[1]=2016-01-08",
+			form{
+				Max:    10,
+				Scores: map[string]int{"go": 1, "c": 2},
+				Days: map[int][]time.Time{1: {
+					time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+					time.Date(2016, 1, 8, 0, 0, 0, 0, time.UTC),
+				}},
+			}},
+		{"ip=127.0.0.1&skip=a&-=b&hidden=c&unknown=d&addr.unknown=e",
+			form{Max: 10, IP: net.IPv4(127, 0, 0, 1)}},
+		// Fields tagged "-" cannot be set, even by an empty name.
+		{"=true&[k]=v&.city=x&admin=true&secrets[k]=v&private.city=x", form{Max: 10}},
+		// Pointers are not allocated for parameters that match no field.
+		{"work.bogus=1&work.unknown.x=2", form{Max: 10}},
+	} {
+		req := httptest.NewRequest("GET", "/search?"+test.query, nil)
+		got := form{Max: 10}
+		if err := Unpack(req, &got); err != nil {
+			t.Errorf("Unpack(%q): %v", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Unpack(%q) = %+v, want %+v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"x=123", `x: strconv.ParseBool: parsing "123": invalid syntax`},
+		{"max=lots", `max: strconv.ParseInt: parsing "lots": invalid syntax`},
+		{"small=128", `small: strconv.ParseInt: parsing "128": value out of range`},
+		{"count=-1", `count: strconv.ParseUint: parsing "-1": invalid syntax`},
+		{"since=2016-01-02T15:04:05Z", `since: parsing time "2016-01-02T15:04:05Z": extra text: "T15:04:05Z"`},
+		{"timeout=30", `timeout: time: missing unit in duration "30"`},
+		{"interval=NaN", `interval: invalid duration "NaN"`},
+		{"interval=-Inf", `interval: invalid duration "-Inf"`},
+		{"interval=1e300", `interval: invalid duration "1e300"`},
+		{"interval=9223372036.854775808", `interval: invalid duration "9223372036.854775808"`},
+		{"days[x]=2016-01-01", `days[x]: key x: strconv.ParseInt: parsing "x": invalid syntax`},
+		{"scores[go=1", `scores[go: missing ] in [go`},
+		{"max.x=1", `max.x: cannot apply .x to int`},
+		{"ip=localhost", `ip: invalid IP address: localhost`},
+	} {
+		req := httptest.NewRequest("GET", "/search?"+test.query, nil)
+		var f form
+		err := Unpack(req, &f)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Unpack(%q) = %v, want %s", test.query, err, test.want)
+		}
+	}
+}
+
+type account struct {
+	User    string        `validate:"required,len=8"`
+	Plan    *string       `validate:"oneof=free|pro"`
+	Tags    []string      `http:"tag" validate:"max=3,regexp=^[a-z]+$"`
+	Max     int           `http:"max" validate:"min=1,max=100"`
+	Ratio   *float64      `validate:"min=0,max=1"`
+	Timeout time.Duration `validate:"max=1m"`
+	Name    *string       `validate:"min=2"`
+	Home    *address      `validate:"required"`
+	Addr    struct {
+		Zip *string `validate:"regexp=^[0-9]{5}(,[0-9]{4})?$"`
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"user=gopher42&plan=pro&tag=go&max=100&ratio=0.5&timeout=1m&name=Bo&home.city=x&addr.zip=10001,1234", ""},
+		{"user=gopher42&max=1&home.city=x", ""},
+		{"", "home: is required; max: must be at least 1; user: is required"},
+		{"user=gopher&plan=gold&tag=a&tag=B&max=101&ratio=2&timeout=2m&name=é&home.city=x&addr.zip=1",
+			`addr.zip: "1" does not match ^[0-9]{5}(,[0-9]{4})?$; ` +
+				"max: must be at most 100; " +
+				`name: must be at least 2 characters long; ` +
+				`plan: "gold" is not one of free, pro; ` +
+				"ratio: must be at most 1; " +
+				`tag: "B" does not match ^[a-z]+$; ` +
+				"timeout: must be at most 1m; " +
+				"user: must be exactly 8 characters long"},
+		{"tag=a&tag=b&tag=c&tag=d&max=lots&ratio=x&home.city=x&user=gopher42",
+			`max: strconv.ParseInt: parsing "lots": invalid syntax; ` +
+				`ratio: strconv.ParseFloat: parsing "x": invalid syntax; ` +
+				"tag: must have at most 3 elements"},
+	} {
+		req := httptest.NewRequest("GET", "/?"+test.query, nil)
+		var a account
+		err := Unpack(req, &a)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("Unpack(%q): %v", test.query, err)
+			}
+			continue
+		}
+		var errs Errors
+		if !errors.As(err, &errs) {
+			t.Errorf("Unpack(%q) = %v, want Errors", test.query, err)
+			continue
+		}
+		if got := errs.Error(); got != test.want {
+			t.Errorf("Unpack(%q) = %s, want %s", test.query, got, test.want)
+		}
+	}
+
+	// Parsing errors have no rule; validation errors record theirs.
+	req := httptest.NewRequest("GET", "/?max=0&ratio=x&user=gopher42&home.city=x", nil)
+	var a account
+	errs, _ := Unpack(req, &a).(Errors)
+	if len(errs) != 2 || errs[0].Param != "max" || errs[0].Rule != "min=1" || errs[1].Rule != "" {
+		t.Fatalf("Unpack: got %v, want errors for max (min=1) and ratio", errs)
+	}
+	var numErr *strconv.NumError
+	if !errors.As(errs[1], &numErr) {
+		t.Errorf("Unpack: ratio error %v does not wrap *strconv.NumError", errs[1])
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	for _, test := range []struct {
+		v    interface{}
+		want string
+	}{
+		{&struct {
+			X int `validate:"between=1|2"`
+		}{}, `params: field X: unknown validation rule "between=1|2"`},
+		{&struct {
+			X int `validate:"min"`
+		}{}, "params: field X: rule min needs an argument"},
+		{&struct {
+			X int `validate:"len=2"`
+		}{}, "params: field X: rule len=2: int has no length"},
+		{&struct {
+			X int `validate:"regexp=x"`
+		}{}, "params: field X: rule regexp=x: cannot match int"},
+		{&struct {
+			X string `validate:"regexp=("`
+		}{}, "params: field X: error parsing regexp"},
+	} {
+		err := Validate(test.v)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Validate(%T) = %v, want %s", test.v, err, test.want)
+		}
+		if _, ok := err.(Errors); ok {
+			t.Errorf("Validate(%T) returned Errors for an invalid tag", test.v)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package params
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A FieldError describes a parameter that could not be parsed,
+// or a field whose value failed a validation rule.
+type FieldError struct {
+	Param string // parameter name, such as "max" or "addr.city"
+	Rule  string // the failed rule, such as "max=100", or "" if Param could not be parsed
+	Err   error
+}
+
+func (e *FieldError) Error() string { return e.Param + ": " + e.Err.Error() }
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// Errors is the error returned by Unpack and Validate when parameters
+// are invalid.  It lists every invalid parameter, ordered by name.
+type Errors []*FieldError
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// sorted returns errs ordered by parameter name, or nil if it is empty.
+func (errs Errors) sorted() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Param < errs[j].Param })
+	return errs
+}
+
+// Validate checks the fields of the struct pointed to by ptr against
+// the rules in their "validate" tags, and returns an Errors listing
+// the fields that fail, if any.  A tag holds a comma-separated list
+// of rules:
+//
+//	required     the field must not have its zero value
+//	min=n        the number must be at least n, or the string,
+//	             slice or map must have at least n elements
+//	max=n        likewise, at most n
+//	len=n        the string, slice or map must have exactly n elements
+//	oneof=a|b    the value, or each element of a slice, must be a or b
+//	regexp=re    the string, or each string of a slice, must match re;
+//	             this rule must be last, as re may contain commas
+//
+// For a time.Duration, n is a duration such as 1s; the length of a
+// string is its number of characters.  Rules other than required
+// accept a nil pointer, and otherwise apply to the pointed-to value,
+// so optional parameters should be pointers.  Fields of nested
+// structs are checked too; their parameter names are qualified as
+// for Unpack.
+//
+// An invalid tag is reported by an error other than Errors.
+func Validate(ptr interface{}) error {
+	var errs Errors
+	if err := validate(reflect.ValueOf(ptr).Elem(), "", nil, &errs); err != nil {
+		return err
+	}
+	return errs.sorted()
+}
+
+// validate appends to errs an error for each field of struct v, or of
+// the structs nested within it, that fails its rules, except for the
+// parameters in skip, which could not be parsed.  Prefix is prepended
+// to parameter names.
+func validate(v reflect.Value, prefix string, skip map[string]bool, errs *Errors) error {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		name := paramName(sf)
+		if !sf.IsExported() || name == "" || skip[prefix+name] {
+			continue
+		}
+		param := prefix + name
+		rules, err := parseRules(sf.Tag.Get("validate"))
+		if err != nil {
+			return fmt.Errorf("params: field %s: %v", sf.Name, err)
+		}
+		f := v.Field(i)
+		for _, r := range rules {
+			msg, err := r.check(f)
+			if err != nil {
+				return fmt.Errorf("params: field %s: rule %s: %v", sf.Name, r, err)
+			}
+			if msg != "" {
+				*errs = append(*errs, &FieldError{param, r.String(), errors.New(msg)})
+				break // report only the first failure of a field
+			}
+		}
+
+		for f.Kind() == reflect.Ptr && !f.IsNil() {
+			f = f.Elem()
+		}
+		if f.Kind() == reflect.Struct && f.Type() != timeType &&
+			!reflect.PointerTo(f.Type()).Implements(textUnmarshalerType) {
+			if err := validate(f, param+".", skip, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A rule is one rule of a "validate" tag.
+type rule struct {
+	name, arg string
+	re        *regexp.Regexp // for regexp
+}
+
+func (r rule) String() string {
+	if r.name == "required" {
+		return r.name
+	}
+	return r.name + "=" + r.arg
+}
+
+var ruleCache sync.Map // map[string][]rule, keyed by tag
+
+// parseRules returns the rules of a "validate" tag.
+func parseRules(tag string) ([]rule, error) {
+	if rules, ok := ruleCache.Load(tag); ok {
+		return rules.([]rule), nil
+	}
+	var rules []rule
+	for rest := tag; rest != ""; {
+		var item string
+		if strings.HasPrefix(rest, "regexp=") {
+			item, rest = rest, ""
+		} else {
+			item, rest, _ = strings.Cut(rest, ",")
+		}
+		name, arg, hasArg := strings.Cut(item, "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "required":
+			if hasArg {
+				return nil, fmt.Errorf("rule required takes no argument")
+			}
+		case "min", "max", "len", "oneof":
+			if arg == "" {
+				return nil, fmt.Errorf("rule %s needs an argument", name)
+			}
+		case "regexp":
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, err
+			}
+			r.re = re
+		default:
+			return nil, fmt.Errorf("unknown validation rule %q", item)
+		}
+		rules = append(rules, r)
+	}
+	ruleCache.Store(tag, rules)
+	return rules, nil
+}
+
+// check returns a description of how v fails rule r, or "" if it
+// does not.  It reports an error if the rule does not apply to v.
+func (r rule) check(v reflect.Value) (string, error) {
+	if r.name == "required" {
+		if v.IsZero() {
+			return "is required", nil
+		}
+		return "", nil
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil // optional
+		}
+		v = v.Elem()
+	}
+
+	switch r.name {
+	case "oneof", "regexp":
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			for i := 0; i < v.Len(); i++ {
+				if msg, err := r.check(v.Index(i)); msg != "" || err != nil {
+					return msg, err
+				}
+			}
+			return "", nil
+		}
+		if r.name == "regexp" {
+			if v.Kind() != reflect.String {
+				return "", fmt.Errorf("cannot match %s", v.Type())
+			}
+			if !r.re.MatchString(v.String()) {
+				return fmt.Sprintf("%q does not match %s", v.String(), r.arg), nil
+			}
+			return "", nil
+		}
+		s := fmt.Sprint(v.Interface())
+		for _, choice := range strings.Split(r.arg, "|") {
+			if s == choice {
+				return "", nil
+			}
+		}
+		return fmt.Sprintf("%q is not one of %s", s, strings.ReplaceAll(r.arg, "|", ", ")), nil
+	}
+
+	// min, max, len
+	var n, limit float64
+	unit := ""
+	switch v.Kind() {
+	case reflect.String:
+		n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n, unit = float64(v.Len()), " elements"
+	default:
+		if r.name == "len" {
+			return "", fmt.Errorf("%s has no length", v.Type())
+		}
+	}
+	if unit == "" && v.Type() == durationType {
+		d, err := time.ParseDuration(r.arg)
+		if err != nil {
+			return "", err
+		}
+		n, limit = float64(v.Int()), float64(d)
+	} else {
+		x, err := strconv.ParseFloat(r.arg, 64)
+		if err != nil {
+			return "", err
+		}
+		limit = x
+		if unit == "" {
+			switch v.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				n = float64(v.Int())
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+				n = float64(v.Uint())
+			case reflect.Float32, reflect.Float64:
+				n = v.Float()
+			default:
+				return "", fmt.Errorf("cannot compare %s", v.Type())
+			}
+		}
+	}
+
+	switch {
+	case r.name == "min" && n < limit:
+		return describe("at least", r.arg, unit), nil
+	case r.name == "max" && n > limit:
+		return describe("at most", r.arg, unit), nil
+	case r.name == "len" && n != limit:
+		return describe("exactly", r.arg, unit), nil
+	}
+	return "", nil
+}
+
+// describe returns a description of a failed limit,
+// such as "must be at least 1" or "must have at most 5 elements".
+func describe(how, limit, unit string) string {
+	switch unit {
+	case "":
+		return "must be " + how + " " + limit
+	case " characters":
+		return "must be " + how + " " + limit + unit + " long"
+	}
+	return "must have " + how + " " + limit + unit
+}
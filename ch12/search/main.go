@@ -19,12 +19,21 @@ import (
 // search implements the /search URL endpoint.
 func search(resp http.ResponseWriter, req *http.Request) {
 	var data struct {
-		Labels     []string `http:"l"`
-		MaxResults int      `http:"max"`
+		Labels     []string `http:"l" validate:"max=5"`
+		MaxResults int      `http:"max" validate:"min=1,max=100"`
 		Exact      bool     `http:"x"`
 	}
 	data.MaxResults = 10 // set default
 	if err := params.Unpack(req, &data); err != nil {
+		if errs, ok := err.(params.Errors); ok {
+			// Report every invalid parameter, one per line.
+			resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			resp.WriteHeader(http.StatusBadRequest) // 400
+			for _, e := range errs {
+				fmt.Fprintln(resp, e)
+			}
+			return
+		}
 		http.Error(resp, err.Error(), http.StatusBadRequest) // 400
 		return
 	}
@@ -58,5 +67,8 @@ $ ./fetch 'http://localhost:12345/search?q=hello&x=123'
 x: strconv.ParseBool: parsing "123": invalid syntax
 $ ./fetch 'http://localhost:12345/search?q=hello&max=lots'
 max: strconv.ParseInt: parsing "lots": invalid syntax
+$ ./fetch 'http://localhost:12345/search?x=123&max=1000'
+max: must be at most 100
+x: strconv.ParseBool: parsing "123": invalid syntax
 //!-output
 */
\ No newline at end of file
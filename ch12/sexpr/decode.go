@@ -9,6 +9,7 @@ package sexpr
 
 import (
 	"bytes"
+	"encoding"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -18,17 +19,21 @@ import (
 // !+Unmarshal
 // Unmarshal parses S-expression data and populates the variable
 // whose address is in the non-nil pointer out.
+//
+// Malformed input is reported as a *SyntaxError, and input that does
+// not correspond to the type of the variable as an *UnmarshalTypeError.
 func Unmarshal(data []byte, out interface{}) (err error) {
-	lex := &lexer{scan: scanner.Scanner{Mode: scanner.GoTokens}}
-	lex.scan.Init(bytes.NewReader(data))
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(out)}
+	}
+	lex := newLexer(bytes.NewReader(data))
+	defer catchError(&err)
 	lex.next() // get the first token
-	defer func() {
-		// NOTE: this is not an example of ideal error handling.
-		if x := recover(); x != nil {
-			err = fmt.Errorf("error at %s: %v", lex.scan.Position, x)
-		}
-	}()
-	read(lex, reflect.ValueOf(out).Elem())
+	read(lex, v.Elem())
+	if lex.token != scanner.EOF {
+		lex.syntaxError("got %s after top-level value, want end of file", lex.describe())
+	}
 	return nil
 }
 
@@ -37,82 +42,218 @@ func Unmarshal(data []byte, out interface{}) (err error) {
 // !+lexer
 type lexer struct {
 	scan  scanner.Scanner
-	token rune // the current token
+	token rune             // the current token
+	lit   string           // the text of the current token
+	pos   scanner.Position // the position of the current token
+
+	// decoding state
+	field           string                // path of the current struct field, for errors
+	disallowUnknown bool                  // whether unknown struct fields are an error
+	labels          map[int]reflect.Value // pointers labelled #n= in the current value
+	reported        error                 // the last error returned by a Decoder method
 }
 
-func (lex *lexer) next()        { lex.token = lex.scan.Scan() }
-func (lex *lexer) text() string { return lex.scan.TokenText() }
+func (lex *lexer) text() string { return lex.lit }
 
 func (lex *lexer) consume(want rune) {
-	if lex.token != want { // NOTE: Not an example of good error handling.
-		panic(fmt.Sprintf("got %q, want %q", lex.text(), want))
+	if lex.token != want {
+		lex.syntaxError("got %s, want %q", lex.describe(), want)
 	}
 	lex.next()
 }
 
 //!-lexer
 
-// The read function is a decoder for a small subset of well-formed
-// S-expressions.  For brevity of our example, it takes many dubious
-// shortcuts.
+// The read function is a decoder for a subset of S-expressions:
+// symbols, strings, numbers, #C(re im) complex numbers, and lists.
+//
+// The parser reports an error
+// - if the input is not well-formed, or does not correspond to the
+//   type of the variable (an array, for example, must have exactly
+//   the right number of elements);
+// - if a number does not fit in the numeric variable v;
+// - if a key in ((key value) ...) struct syntax is not an unquoted
+//   symbol.  Keys that match no field, even ignoring case, are skipped
+//   unless the Decoder's DisallowUnknownFields method has been called.
 //
-// The parser assumes
-// - that the S-expression input is well-formed; it does no error checking.
-// - that the S-expression input corresponds to the type of the variable.
-// - that all numbers in the input are non-negative decimal integers.
-// - that all keys in ((key value) ...) struct syntax are unquoted symbols.
-// - that the input does not contain dotted lists such as (1 2 . 3).
-// - that the input does not contain Lisp reader macros such 'x and #'x.
+// Struct fields and map entries may also be written as dotted pairs
+// (key . value).  Dotted lists such as (1 2 . 3) and quoted values 'x
+// may appear only in a Value, which is what an interface{} variable
+// receives; other Lisp reader macros such as #'x are not supported.
 //
-// The reflection logic assumes
-// - that v is always a variable of the appropriate type for the
-//   S-expression value.  For example, v must not be a boolean,
-//   interface, channel, or function, and if v is an array, the input
-//   must have the correct number of elements.
-// - that v in the top-level call to read has the zero value of its
-//   type and doesn't need clearing.
-// - that if v is a numeric variable, it is a signed integer.
+// The reflection logic assumes that v in the top-level call to read
+// has the zero value of its type and doesn't need clearing.
 
 // !+read
 // 展示类似 encoding/json 等包底层代码的实现思路，以及如何使用反射机制来填充数据结构
 func read(lex *lexer, v reflect.Value) {
+	if lex.token == scanner.Ident && lex.text() == "nil" {
+		v.Set(reflect.Zero(v.Type()))
+		lex.next()
+		return
+	}
+	if v.Kind() == reflect.Ptr { // decode into the variable it points to
+		if lex.token == labelDef || lex.token == labelRef {
+			readLabel(lex, v)
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		read(lex, v.Elem())
+		return
+	}
+	if unmarshal(lex, v) {
+		return
+	}
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		v.Set(reflect.ValueOf(readValue(lex))) // not nil, which was handled above
+		return
+	}
 	switch lex.token {
 	case scanner.Ident:
 		// The only valid identifiers are
-		// "nil" and struct field names.
-		if lex.text() == "nil" {
-			v.Set(reflect.Zero(v.Type()))
+		// "nil", "t" and struct field names.
+		if lex.text() == "t" {
+			if v.Kind() != reflect.Bool {
+				lex.typeError("t", v.Type())
+			}
+			v.SetBool(true)
 			lex.next()
 			return
 		}
+		lex.typeError("symbol "+lex.text(), v.Type())
 	case scanner.String:
-		s, _ := strconv.Unquote(lex.text()) // NOTE: ignoring errors
+		s, err := strconv.Unquote(lex.text())
+		if err != nil {
+			lex.syntaxError("invalid string literal %s", lex.text())
+		}
+		if v.Kind() != reflect.String {
+			lex.typeError("string", v.Type())
+		}
 		v.SetString(s)
 		lex.next()
 		return
-	case scanner.Int:
-		i, _ := strconv.Atoi(lex.text()) // NOTE: ignoring errors
-		v.SetInt(int64(i))
+	case scanner.Int, scanner.Float:
+		readNumber(lex, v)
+		return
+	case '#': // #C(re im)
+		lex.next()
+		if lex.token != scanner.Ident || lex.text() != "C" {
+			lex.syntaxError("got %s after #, want C", lex.describe())
+		}
+		if v.Kind() != reflect.Complex64 && v.Kind() != reflect.Complex128 {
+			lex.typeError("complex number", v.Type())
+		}
 		lex.next()
+		lex.consume('(')
+		re := reflect.New(reflect.TypeOf(float64(0))).Elem()
+		im := reflect.New(re.Type()).Elem()
+		readNumber(lex, re)
+		readNumber(lex, im)
+		lex.consume(')')
+		c := complex(re.Float(), im.Float())
+		if v.OverflowComplex(c) {
+			lex.typeError(fmt.Sprintf("complex number %g", c), v.Type())
+		}
+		v.SetComplex(c)
 		return
 	case '(':
 		lex.next()
 		readList(lex, v)
 		lex.next() // consume ')'
 		return
+	case '\'', labelDef, labelRef:
+		lex.typeError(fmt.Sprintf("value beginning %s", lex.describe()), v.Type())
 	}
-	panic(fmt.Sprintf("unexpected token %q", lex.text()))
+	lex.syntaxError("unexpected token %s", lex.describe())
 }
 
 //!-read
 
+// readLabel reads into the pointer variable v a label #n= followed by
+// the value to which it points, or a reference #n# to a labelled value.
+func readLabel(lex *lexer, v reflect.Value) {
+	text := lex.text()
+	n, err := strconv.Atoi(text[1 : len(text)-1])
+	if err != nil {
+		lex.syntaxError("invalid label %s", text)
+	}
+	switch lex.token {
+	case labelDef:
+		if _, ok := lex.labels[n]; ok {
+			lex.syntaxError("label #%d= defined twice", n)
+		}
+		lex.next()
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if lex.labels == nil {
+			lex.labels = make(map[int]reflect.Value)
+		}
+		lex.labels[n] = v.Elem().Addr()
+		read(lex, v.Elem())
+	case labelRef:
+		p, ok := lex.labels[n]
+		if !ok {
+			lex.syntaxError("undefined label #%d#", n)
+		}
+		if p.Type() != v.Type() {
+			lex.typeError(fmt.Sprintf("reference to label #%d of type %s", n, p.Type()), v.Type())
+		}
+		v.Set(p)
+		lex.next()
+	}
+}
+
+// readNumber reads an integer or floating-point number
+// into the numeric variable v.
+func readNumber(lex *lexer, v reflect.Value) {
+	if lex.token != scanner.Int && lex.token != scanner.Float {
+		lex.syntaxError("got %s, want number", lex.describe())
+	}
+	text := lex.text()
+	var err error
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(text, 10, v.Type().Bits()); err == nil {
+			v.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		if u, err = strconv.ParseUint(text, 10, v.Type().Bits()); err == nil {
+			v.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(text, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+	default:
+		err = strconv.ErrSyntax
+	}
+	if err != nil {
+		lex.typeError("number "+text, v.Type())
+	}
+	lex.next()
+}
+
 // !+readlist
 func readList(lex *lexer, v reflect.Value) {
 	switch v.Kind() {
 	case reflect.Array: // (item ...)
-		for i := 0; !endList(lex); i++ {
+		i := 0
+		for ; !endList(lex); i++ {
+			if i == v.Len() {
+				lex.typeError(fmt.Sprintf("list of more than %d elements", i), v.Type())
+			}
 			read(lex, v.Index(i))
 		}
+		if i < v.Len() {
+			lex.typeError(fmt.Sprintf("list of %d elements", i), v.Type())
+		}
 
 	case reflect.Slice: // (item ...)
 		for !endList(lex) {
@@ -122,16 +263,35 @@ func readList(lex *lexer, v reflect.Value) {
 		}
 
 	case reflect.Struct: // ((name value) ...)
+		outer := lex.field
 		for !endList(lex) {
 			lex.consume('(')
 			if lex.token != scanner.Ident {
-				panic(fmt.Sprintf("got token %q, want field name", lex.text()))
+				lex.syntaxError("got %s, want field name", lex.describe())
 			}
 			name := lex.text()
+			lex.field = name
+			if outer != "" {
+				lex.field = outer + "." + name
+			}
+			var fv reflect.Value
+			if f, ok := fieldByName(fields(v.Type()), name); ok {
+				fv = settableField(v, f)
+			} else if lex.disallowUnknown {
+				lex.typeError(fmt.Sprintf("unknown field %q", name), v.Type())
+			}
 			lex.next()
-			read(lex, v.FieldByName(name))
+			if lex.token == '.' { // (name . value)
+				lex.next()
+			}
+			if fv.IsValid() {
+				read(lex, fv)
+			} else {
+				skip(lex) // ignore unknown fields
+			}
 			lex.consume(')')
 		}
+		lex.field = outer
 
 	case reflect.Map: // ((key value) ...)
 		v.Set(reflect.MakeMap(v.Type()))
@@ -139,21 +299,163 @@ func readList(lex *lexer, v reflect.Value) {
 			lex.consume('(')
 			key := reflect.New(v.Type().Key()).Elem()
 			read(lex, key)
+			if key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable() {
+				lex.typeError("map key of type "+key.Elem().Type().String(), v.Type())
+			}
+			if lex.token == '.' { // (key . value)
+				lex.next()
+			}
 			value := reflect.New(v.Type().Elem()).Elem()
 			read(lex, value)
 			v.SetMapIndex(key, value)
 			lex.consume(')')
 		}
 
+	case reflect.Interface: // ("type" value), for a non-empty interface
+		if lex.token != scanner.String {
+			lex.syntaxError("got %s, want type name", lex.describe())
+		}
+		name, err := strconv.Unquote(lex.text())
+		if err != nil {
+			lex.syntaxError("invalid string literal %s", lex.text())
+		}
+		t := lookupType(name)
+		if t == nil {
+			lex.typeError(fmt.Sprintf("value of unregistered type %q", name), v.Type())
+		}
+		if !t.AssignableTo(v.Type()) {
+			lex.typeError("value of type "+name, v.Type())
+		}
+		lex.next()
+		value := reflect.New(t).Elem()
+		read(lex, value)
+		v.Set(value)
+		if lex.token != ')' {
+			lex.syntaxError("got %s, want ')'", lex.describe())
+		}
+
 	default:
-		panic(fmt.Sprintf("cannot decode list into %v", v.Type()))
+		lex.typeError("list", v.Type())
+	}
+}
+
+// Unmarshaler is the interface implemented by types that can
+// unmarshal an S-expression representation of themselves.
+// The input is a single S-expression, reformatted with single spaces
+// between the elements of each list.
+type Unmarshaler interface {
+	UnmarshalSexpr([]byte) error
+}
+
+// DecoderUnmarshaler is the interface implemented by types that can
+// decode themselves from a Decoder, which Unmarshal and Decode prefer
+// to UnmarshalSexpr.  DecodeSexpr must consume exactly one S-expression.
+// The Decoder reads the enclosing input, so it honours the options of
+// the enclosing Decoder, and its errors, when DecodeSexpr returns them,
+// are reported with their positions in that input.
+type DecoderUnmarshaler interface {
+	DecodeSexpr(*Decoder) error
+}
+
+// unmarshal decodes the next S-expression into v using the
+// DecodeSexpr or UnmarshalSexpr method of *v, or, if the input is a
+// string literal, its UnmarshalText method.  It reports false if *v
+// has none of these methods.
+func unmarshal(lex *lexer, v reflect.Value) bool {
+	if !v.CanAddr() || v.Kind() == reflect.Interface || !v.Addr().CanInterface() {
+		return false
+	}
+	pos := lex.pos
+	var err error
+	switch u := v.Addr().Interface().(type) {
+	case DecoderUnmarshaler:
+		lex.reported = nil
+		err = u.DecodeSexpr(&Decoder{lex: lex, primed: true, nested: true})
+		if err != nil && err == lex.reported {
+			rethrow(err) // already describes its position
+		}
+	case Unmarshaler:
+		var buf bytes.Buffer
+		datum(lex, &buf)
+		err = u.UnmarshalSexpr(buf.Bytes())
+	case encoding.TextUnmarshaler:
+		if lex.token != scanner.String {
+			return false
+		}
+		text, qerr := strconv.Unquote(lex.text())
+		if qerr != nil {
+			lex.syntaxError("invalid string literal %s", lex.text())
+		}
+		lex.next()
+		err = u.UnmarshalText([]byte(text))
+	default:
+		return false
+	}
+	if err != nil {
+		panic(methodError{fmt.Errorf("sexpr: %d:%d: %s: %w", pos.Line, pos.Column, v.Type(), err)})
+	}
+	return true
+}
+
+// skip consumes a single S-expression without decoding it.
+func skip(lex *lexer) { datum(lex, nil) }
+
+// datum consumes a single S-expression, writing it to buf if non-nil.
+func datum(lex *lexer, buf *bytes.Buffer) {
+	depth := 0
+	space := false // whether a space must precede the next element
+	for {
+		switch lex.token {
+		case scanner.EOF:
+			lex.syntaxError("unexpected end of file")
+		case ')':
+			if depth == 0 {
+				lex.syntaxError("unexpected token %s", lex.describe())
+			}
+			depth--
+			space = false
+		}
+		if buf != nil {
+			if space {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(lex.text())
+		}
+		switch lex.token {
+		case '(':
+			depth++
+			space = false
+		case '\'', labelDef:
+			// a prefix of the following datum
+			lex.next()
+			space = false
+			continue
+		case '#':
+			// a prefix of the following #C(re im)
+			lex.next()
+			if lex.token != scanner.Ident || lex.text() != "C" {
+				lex.syntaxError("got %s after #, want C", lex.describe())
+			}
+			if buf != nil {
+				buf.WriteString("C")
+			}
+			lex.next()
+			space = false
+			continue
+		default:
+			space = true
+		}
+		lex.next()
+		if depth == 0 {
+			return
+		}
 	}
 }
 
 func endList(lex *lexer) bool {
 	switch lex.token {
 	case scanner.EOF:
-		panic("end of file")
+		lex.syntaxError("unexpected end of file")
 	case ')':
 		return true
 	}
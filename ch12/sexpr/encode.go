@@ -7,14 +7,22 @@ package sexpr
 
 import (
 	"bytes"
+	"encoding"
 	"fmt"
+	"io"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 //!+Marshal
 // Marshal encodes a Go value in S-expression form.
+// The entries of a map are sorted by key, and a value containing
+// a cycle of pointers, maps or slices is an error.
 func Marshal(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
+	var buf encoder
 	if err := encode(&buf, reflect.ValueOf(v)); err != nil {
 		return nil, err
 	}
@@ -23,75 +31,377 @@ func Marshal(v interface{}) ([]byte, error) {
 
 //!-Marshal
 
+// An Encoder writes S-expressions to an output stream.
+type Encoder struct {
+	w      io.Writer
+	labels bool
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetLabels determines whether the encoder uses the Common Lisp
+// notation #n= and #n# to encode shared pointers.  With labels, each
+// variable reachable by more than one path through pointers is encoded
+// only once, and cycles through pointers are not an error.
+func (enc *Encoder) SetLabels(on bool) { enc.labels = on }
+
+// Encode writes the S-expression encoding of v to the stream,
+// followed by a newline.
+func (enc *Encoder) Encode(v interface{}) error {
+	buf := encoder{refs: refs{labels: enc.labels}}
+	buf.refs.find(reflect.ValueOf(v))
+	if err := encode(&buf, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := enc.w.Write(buf.Bytes())
+	return err
+}
+
+// An encoder holds the state of a call to Marshal or Encode.
+type encoder struct {
+	bytes.Buffer
+	refs
+}
+
+// Marshaler is the interface implemented by types that can marshal
+// themselves into a valid S-expression.
+type Marshaler interface {
+	MarshalSexpr() ([]byte, error)
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// marshal returns the encoding of v produced by its MarshalSexpr
+// method, or by its MarshalText method as a string literal.
+// It reports false if v has neither method.  As with encoding/json,
+// methods with pointer receivers are called only for addressable
+// values, such as the elements of a slice or the fields of a struct
+// reached through a pointer.
+func marshal(v reflect.Value) ([]byte, bool, error) {
+	if !hasMarshaler(v) {
+		return nil, false, nil
+	}
+	return callMarshaler(v)
+}
+
+// callMarshaler calls the method of v that marshal would call.
+func callMarshaler(v reflect.Value) ([]byte, bool, error) {
+	if v.CanAddr() {
+		v = v.Addr() // the methods may have pointer receivers
+	}
+	switch m := v.Interface().(type) {
+	case Marshaler:
+		data, err := m.MarshalSexpr()
+		if err != nil {
+			return nil, true, fmt.Errorf("sexpr: error calling MarshalSexpr for type %s: %v", v.Type(), err)
+		}
+		return data, true, nil
+	case encoding.TextMarshaler:
+		text, err := m.MarshalText()
+		if err != nil {
+			return nil, true, fmt.Errorf("sexpr: error calling MarshalText for type %s: %v", v.Type(), err)
+		}
+		return []byte(strconv.Quote(string(text))), true, nil
+	}
+	return nil, false, nil
+}
+
+// hasMarshaler reports whether marshal would call a method of v.
+func hasMarshaler(v reflect.Value) bool {
+	if !v.IsValid() || v.Kind() == reflect.Interface ||
+		v.Kind() == reflect.Ptr && v.IsNil() || !v.CanInterface() {
+		return false
+	}
+	t := v.Type()
+	if v.CanAddr() {
+		t = reflect.PointerTo(t)
+	}
+	return t.Implements(marshalerType) || t.Implements(textMarshalerType)
+}
+
 // encode writes to buf an S-expression representation of v.
 //!+encode
-func encode(buf *bytes.Buffer, v reflect.Value) error {
-	switch v.Kind() {
-	case reflect.Invalid:
+func encode(buf *encoder, v reflect.Value) error {
+	if !v.IsValid() {
 		buf.WriteString("nil")
+		return nil
+	}
+	return typeEncoder(v.Type())(buf, v)
+}
+
+// An encoderFunc encodes values of one type.
+type encoderFunc func(buf *encoder, v reflect.Value) error
+
+var encoderCache sync.Map // map[reflect.Type]encoderFunc
+
+// typeEncoder returns the encoder for values of type t.  Encoders are
+// built once per type, so that repeated encoding of a type does not
+// repeat the inspection of its methods, kind and fields.
+func typeEncoder(t reflect.Type) encoderFunc {
+	if f, ok := encoderCache.Load(t); ok {
+		return f.(encoderFunc)
+	}
+
+	// To handle recursive types, record an indirect encoder
+	// that waits for the real one to be built.
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
+	wg.Add(1)
+	fi, loaded := encoderCache.LoadOrStore(t, encoderFunc(func(buf *encoder, v reflect.Value) error {
+		wg.Wait()
+		return f(buf, v)
+	}))
+	if loaded {
+		return fi.(encoderFunc)
+	}
+	f = newTypeEncoder(t)
+	wg.Done()
+	encoderCache.Store(t, f)
+	return f
+}
 
+// newTypeEncoder builds the encoder for values of type t.
+func newTypeEncoder(t reflect.Type) encoderFunc {
+	enc := newKindEncoder(t)
+	if t.Kind() == reflect.Interface {
+		return enc
+	}
+	pt := reflect.PointerTo(t)
+	valueMethod := t.Implements(marshalerType) || t.Implements(textMarshalerType)
+	ptrMethod := pt.Implements(marshalerType) || pt.Implements(textMarshalerType)
+	if !valueMethod && !ptrMethod {
+		return enc
+	}
+	// Whether a method is called depends on the value, as in hasMarshaler.
+	return func(buf *encoder, v reflect.Value) error {
+		if !v.CanInterface() || t.Kind() == reflect.Ptr && v.IsNil() ||
+			!(valueMethod || ptrMethod && v.CanAddr()) {
+			return enc(buf, v)
+		}
+		if data, ok, err := callMarshaler(v); ok {
+			if err != nil {
+				return err
+			}
+			buf.Write(data)
+			return nil
+		}
+		return enc(buf, v)
+	}
+}
+
+func newKindEncoder(t reflect.Type) encoderFunc {
+	switch t.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16,
 		reflect.Int32, reflect.Int64:
-		fmt.Fprintf(buf, "%d", v.Int())
+		return func(buf *encoder, v reflect.Value) error {
+			buf.Write(strconv.AppendInt(buf.AvailableBuffer(), v.Int(), 10))
+			return nil
+		}
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16,
 		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		fmt.Fprintf(buf, "%d", v.Uint())
+		return func(buf *encoder, v reflect.Value) error {
+			buf.Write(strconv.AppendUint(buf.AvailableBuffer(), v.Uint(), 10))
+			return nil
+		}
 
 	case reflect.String:
-		fmt.Fprintf(buf, "%q", v.String())
+		return func(buf *encoder, v reflect.Value) error {
+			buf.Write(strconv.AppendQuote(buf.AvailableBuffer(), v.String()))
+			return nil
+		}
 
-	case reflect.Ptr:
-		return encode(buf, v.Elem())
+	case reflect.Bool: // t or nil
+		return func(buf *encoder, v reflect.Value) error {
+			if v.Bool() {
+				buf.WriteString("t")
+			} else {
+				buf.WriteString("nil")
+			}
+			return nil
+		}
 
-	case reflect.Array, reflect.Slice: // (value ...)
-		buf.WriteByte('(')
-		for i := 0; i < v.Len(); i++ {
-			if i > 0 {
-				buf.WriteByte(' ')
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		return func(buf *encoder, v reflect.Value) error {
+			s, err := formatFloat(v.Float(), bits)
+			if err != nil {
+				return err
 			}
-			if err := encode(buf, v.Index(i)); err != nil {
+			buf.WriteString(s)
+			return nil
+		}
+
+	case reflect.Complex64, reflect.Complex128: // #C(real imag)
+		bits := t.Bits() / 2
+		return func(buf *encoder, v reflect.Value) error {
+			c := v.Complex()
+			re, err := formatFloat(real(c), bits)
+			if err != nil {
+				return err
+			}
+			im, err := formatFloat(imag(c), bits)
+			if err != nil {
 				return err
 			}
+			fmt.Fprintf(buf, "#C(%s %s)", re, im)
+			return nil
 		}
-		buf.WriteByte(')')
 
-	case reflect.Struct: // ((name value) ...)
-		buf.WriteByte('(')
-		for i := 0; i < v.NumField(); i++ {
-			if i > 0 {
-				buf.WriteByte(' ')
+	case reflect.Ptr:
+		elem := typeEncoder(t.Elem())
+		return func(buf *encoder, v reflect.Value) error {
+			if v.IsNil() {
+				buf.WriteString("nil")
+				return nil
+			}
+			label, done := buf.label(v)
+			buf.WriteString(label)
+			if done {
+				return nil
+			}
+			if err := buf.enter(v); err != nil {
+				return err
+			}
+			defer buf.leave(v)
+			return elem(buf, v.Elem())
+		}
+
+	case reflect.Interface: // ("type" value), for a non-empty interface
+		return func(buf *encoder, v reflect.Value) error {
+			if v.IsNil() {
+				buf.WriteString("nil")
+				return nil
+			}
+			if t.NumMethod() == 0 || isValueType(v.Elem().Type()) {
+				return encode(buf, v.Elem())
 			}
-			fmt.Fprintf(buf, "(%s ", v.Type().Field(i).Name)
-			if err := encode(buf, v.Field(i)); err != nil {
+			fmt.Fprintf(buf, "(%q ", typeName(v.Elem().Type()))
+			if err := encode(buf, v.Elem()); err != nil {
 				return err
 			}
 			buf.WriteByte(')')
+			return nil
 		}
-		buf.WriteByte(')')
 
-	case reflect.Map: // ((key value) ...)
-		buf.WriteByte('(')
-		for i, key := range v.MapKeys() {
-			if i > 0 {
-				buf.WriteByte(' ')
+	case reflect.Array, reflect.Slice: // (value ...)
+		elem := typeEncoder(t.Elem())
+		slice := t.Kind() == reflect.Slice
+		return func(buf *encoder, v reflect.Value) error {
+			if slice {
+				if err := buf.enter(v); err != nil {
+					return err
+				}
+				defer buf.leave(v)
 			}
 			buf.WriteByte('(')
-			if err := encode(buf, key); err != nil {
-				return err
+			for i := 0; i < v.Len(); i++ {
+				if i > 0 {
+					buf.WriteByte(' ')
+				}
+				if err := elem(buf, v.Index(i)); err != nil {
+					return err
+				}
 			}
-			buf.WriteByte(' ')
-			if err := encode(buf, v.MapIndex(key)); err != nil {
+			buf.WriteByte(')')
+			return nil
+		}
+
+	case reflect.Struct: // ((name value) ...)
+		type fieldEncoder struct {
+			field
+			enc encoderFunc
+		}
+		var fes []fieldEncoder
+		for _, f := range fields(t) {
+			fes = append(fes, fieldEncoder{f, typeEncoder(t.FieldByIndex(f.index).Type)})
+		}
+		return func(buf *encoder, v reflect.Value) error {
+			buf.WriteByte('(')
+			sep := ""
+			for _, f := range fes {
+				fv := fieldValue(v, f.field)
+				if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
+					continue
+				}
+				buf.WriteString(sep)
+				buf.WriteByte('(')
+				buf.WriteString(f.name)
+				buf.WriteByte(' ')
+				sep = " "
+				if err := f.enc(buf, fv); err != nil {
+					return err
+				}
+				buf.WriteByte(')')
+			}
+			buf.WriteByte(')')
+			return nil
+		}
+
+	case reflect.Map: // ((key value) ...)
+		key, elem := typeEncoder(t.Key()), typeEncoder(t.Elem())
+		return func(buf *encoder, v reflect.Value) error {
+			if err := buf.enter(v); err != nil {
 				return err
 			}
+			defer buf.leave(v)
+			buf.WriteByte('(')
+			for i, k := range sortedKeys(v) {
+				if i > 0 {
+					buf.WriteByte(' ')
+				}
+				buf.WriteByte('(')
+				if err := key(buf, k); err != nil {
+					return err
+				}
+				buf.WriteByte(' ')
+				if err := elem(buf, v.MapIndex(k)); err != nil {
+					return err
+				}
+				buf.WriteByte(')')
+			}
 			buf.WriteByte(')')
+			return nil
 		}
-		buf.WriteByte(')')
+	}
 
-	default: // float, complex, bool, chan, func, interface
+	// chan, func, unsafe.Pointer
+	return func(buf *encoder, v reflect.Value) error {
 		return fmt.Errorf("unsupported type: %s", v.Type())
 	}
-	return nil
 }
 
-//!-encode
\ No newline at end of file
+//!-encode
+
+// AppendFloat appends to b the encoding of f, a floating-point number
+// of the given size in bits, as Marshal would produce it.  It is for
+// use by MarshalSexpr methods such as those generated by sexprgen.
+func AppendFloat(b []byte, f float64, bits int) ([]byte, error) {
+	s, err := formatFloat(f, bits)
+	if err != nil {
+		return b, err
+	}
+	return append(b, s...), nil
+}
+
+// formatFloat formats a float of the given size in bits in the
+// shortest form that reads back as the same value.
+func formatFloat(f float64, bits int) (string, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", fmt.Errorf("unsupported value: %g", f)
+	}
+	s := strconv.FormatFloat(f, 'g', -1, bits)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0" // distinguish 1.0 from the integer 1
+	}
+	return s, nil
+}
@@ -0,0 +1,121 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package sexpr
+
+import (
+	"fmt"
+	"reflect"
+	"text/scanner"
+)
+
+// A SyntaxError describes malformed S-expression input.
+type SyntaxError struct {
+	Msg          string // description of the error
+	Line, Column int    // position of the error, starting at 1
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("sexpr: %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// An UnmarshalTypeError describes an S-expression value that
+// cannot be stored in a Go value of a particular type.
+type UnmarshalTypeError struct {
+	Value        string       // description of the value, e.g., "number 128"
+	Type         reflect.Type // type of the Go value it could not be assigned to
+	Field        string       // name of the enclosing struct field, if any
+	Line, Column int          // position of the value, starting at 1
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	field := ""
+	if e.Field != "" {
+		field = " field " + e.Field + " of"
+	}
+	return fmt.Sprintf("sexpr: %d:%d: cannot unmarshal %s into%s Go value of type %s",
+		e.Line, e.Column, e.Value, field, e.Type)
+}
+
+// An InvalidUnmarshalError describes an invalid argument to
+// Unmarshal or Decode, which must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "sexpr: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "sexpr: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "sexpr: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// The decoder reports errors by panicking with a *SyntaxError,
+// an *UnmarshalTypeError, or a methodError wrapping the error
+// returned by an UnmarshalSexpr or UnmarshalText method, which
+// the exported functions recover.
+
+type methodError struct{ err error }
+
+// syntaxError reports an error at the current token.
+func (lex *lexer) syntaxError(format string, args ...interface{}) {
+	pos := lex.pos
+	panic(&SyntaxError{fmt.Sprintf(format, args...), pos.Line, pos.Column})
+}
+
+// typeError reports that the value at the current token,
+// described by value, cannot be stored in a variable of type t.
+func (lex *lexer) typeError(value string, t reflect.Type) {
+	pos := lex.pos
+	panic(&UnmarshalTypeError{Value: value, Type: t, Field: lex.field, Line: pos.Line, Column: pos.Column})
+}
+
+// describe returns a description of the current token for use in errors.
+func (lex *lexer) describe() string {
+	if lex.token == scanner.EOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", lex.text())
+}
+
+// catchError converts a decoding error panic into an error.
+// Any other panic indicates a bug, and is not recovered.
+func catchError(err *error) {
+	if x := recover(); x != nil {
+		*err = recovered(x)
+	}
+}
+
+// catchError is like the function catchError, but also records the
+// error so that an enclosing decoder can recognize it; see unmarshal.
+func (dec *Decoder) catchError(err *error) {
+	if x := recover(); x != nil {
+		*err = recovered(x)
+		dec.lex.reported = *err
+	}
+}
+
+func recovered(x interface{}) error {
+	switch x := x.(type) {
+	case *SyntaxError:
+		return x
+	case *UnmarshalTypeError:
+		return x
+	case methodError:
+		return x.err
+	}
+	panic(x)
+}
+
+// rethrow resumes the panic of an error reported by a decoder
+// passed to a DecodeSexpr method, which the method returned.
+func rethrow(err error) {
+	switch err := err.(type) {
+	case *SyntaxError, *UnmarshalTypeError:
+		panic(err)
+	}
+	panic(methodError{err})
+}
@@ -0,0 +1,238 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package sexpr
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A field describes how a struct field is encoded.
+//
+// The encoding of each struct field can be customized by the format
+// string stored under the "sexpr" key in the struct field's tag.
+// The format string gives the name of the field, possibly followed
+// by a comma and the option "omitempty", which omits the field if it
+// has an empty value: false, 0, a nil pointer or interface, or an
+// empty array, slice, map or string.  The tag "-" omits the field.
+//
+//	Name  string `sexpr:"name"`
+//	Notes string `sexpr:",omitempty"`
+//	Cache []byte `sexpr:"-"`
+//
+// As in encoding/json, the fields of an embedded struct are promoted
+// to the outer struct, unless the embedded field has a name in its tag,
+// and unexported fields are ignored.
+type field struct {
+	name      string
+	index     []int // path of field indices, as for reflect.Value.FieldByIndex
+	tagged    bool  // whether the name came from a tag
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// fields returns the encoded fields of struct type t, in order.
+func fields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+// typeFields computes the fields of struct type t by a breadth-first
+// search of its embedded structs, following the rules of encoding/json:
+// among fields of the same name, the shallowest wins, and at equal
+// depth a tagged field wins; otherwise all fields of that name are
+// dropped.
+func typeFields(t reflect.Type) []field {
+	type level struct {
+		t     reflect.Type
+		index []int
+	}
+	var all []field
+	visited := map[reflect.Type]bool{}
+	for current := []level{{t, nil}}; len(current) > 0; {
+		var next []level
+		for _, l := range current {
+			if visited[l.t] {
+				continue
+			}
+			visited[l.t] = true
+			for i := 0; i < l.t.NumField(); i++ {
+				sf := l.t.Field(i)
+				ft := sf.Type
+				if sf.Anonymous && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if !sf.IsExported() && !(sf.Anonymous && ft.Kind() == reflect.Struct) {
+					continue // unexported non-embedded field
+				}
+				tag := sf.Tag.Get("sexpr")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(append([]int(nil), l.index...), i)
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, level{ft, index})
+					continue
+				}
+				if !sf.IsExported() {
+					continue // unexported embedded struct with a name
+				}
+				f := field{
+					name:      name,
+					index:     index,
+					tagged:    name != "",
+					omitEmpty: opts == "omitempty",
+				}
+				if f.name == "" {
+					f.name = sf.Name
+				}
+				all = append(all, f)
+			}
+		}
+		current = next
+	}
+
+	// Resolve conflicts among fields of the same name.
+	sort.SliceStable(all, func(i, j int) bool { return all[i].name < all[j].name })
+	var result []field
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].name == all[i].name {
+			j++
+		}
+		if f, ok := dominant(all[i:j]); ok {
+			result = append(result, f)
+		}
+		i = j
+	}
+
+	// Restore declaration order.
+	sort.Slice(result, func(i, j int) bool {
+		x, y := result[i].index, result[j].index
+		for k := 0; k < len(x) && k < len(y); k++ {
+			if x[k] != y[k] {
+				return x[k] < y[k]
+			}
+		}
+		return len(x) < len(y)
+	})
+	return result
+}
+
+// dominant returns the field that wins among fields of the same name,
+// which are in order of increasing depth.
+func dominant(fields []field) (field, bool) {
+	depth := len(fields[0].index)
+	var winners []field
+	for _, f := range fields {
+		if len(f.index) > depth {
+			break
+		}
+		winners = append(winners, f)
+	}
+	if len(winners) == 1 {
+		return winners[0], true
+	}
+	var tagged []field
+	for _, f := range winners {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return field{}, false
+}
+
+// fieldByName returns the field whose name matches name,
+// preferring an exact match to a case-insensitive one.
+func fieldByName(fields []field, name string) (field, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// fieldValue returns the value of field f of struct v, or an invalid
+// Value if the path to it passes through a nil embedded pointer.
+func fieldValue(v reflect.Value, f field) reflect.Value {
+	for _, i := range f.index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// settableField returns the field f of struct v,
+// allocating any nil embedded pointers along the way.
+func settableField(v reflect.Value, f field) reflect.Value {
+	for _, i := range f.index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{} // pointer to unexported struct type
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// encodedFields calls emit for each field of struct v that
+// should be encoded, with its name and value.
+func encodedFields(v reflect.Value, emit func(name string, v reflect.Value) error) error {
+	for _, f := range fields(v.Type()) {
+		fv := fieldValue(v, f)
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if err := emit(f.name, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package sexpr
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"unicode"
+)
+
+// The lexer uses text/scanner for strings and numbers, and extends it
+// with the Lisp reader syntax: symbols may contain punctuation such as
+// - and <=, a ; begins a comment that extends to the end of the line,
+// and #| ... |# encloses a comment, which may be nested.  A symbol
+// that looks like a number, such as -1 or +.5, is a number, and the
+// symbol . is the dot of a dotted pair (a . b).
+
+// Tokens beyond those of text/scanner.
+const (
+	labelDef rune = -(iota + 100) // #n=
+	labelRef                      // #n#
+)
+
+// symbolPunct holds the punctuation characters allowed in symbols.
+const symbolPunct = "+-*/<>=!?$%&^~_:.@"
+
+func newLexer(r io.Reader) *lexer {
+	lex := new(lexer)
+	lex.scan.Init(r)
+	lex.scan.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats | scanner.ScanStrings
+	lex.scan.IsIdentRune = func(ch rune, i int) bool {
+		return unicode.IsLetter(ch) || unicode.IsDigit(ch) && i > 0 ||
+			strings.ContainsRune(symbolPunct, ch)
+	}
+	lex.scan.Error = func(s *scanner.Scanner, msg string) {
+		lex.pos = s.Position
+		if !lex.pos.IsValid() {
+			lex.pos = s.Pos()
+		}
+		lex.syntaxError("%s", msg)
+	}
+	return lex
+}
+
+func (lex *lexer) next() {
+	for {
+		lex.token = lex.scan.Scan()
+		lex.lit = lex.scan.TokenText()
+		lex.pos = lex.scan.Position
+		if !lex.pos.IsValid() {
+			lex.pos = lex.scan.Pos() // end of file
+		}
+		switch lex.token {
+		case ';': // line comment
+			for ch := lex.scan.Peek(); ch != '\n' && ch != scanner.EOF; ch = lex.scan.Peek() {
+				lex.scan.Next()
+			}
+			continue
+		case '#':
+			switch ch := lex.scan.Peek(); {
+			case ch == '|':
+				lex.blockComment()
+				continue
+			case isDigit(ch):
+				lex.label()
+			}
+		case scanner.Ident:
+			if lex.lit == "." {
+				lex.token = '.'
+			} else if isNumber(lex.lit) {
+				lex.token = scanner.Float
+				if strings.Trim(lex.lit, "+-0123456789") == "" {
+					lex.token = scanner.Int
+				}
+			}
+		}
+		return
+	}
+}
+
+// blockComment skips the remainder of a #| ... |# comment.
+func (lex *lexer) blockComment() {
+	lex.scan.Next() // consume '|'
+	for depth := 1; depth > 0; {
+		switch lex.scan.Next() {
+		case scanner.EOF:
+			lex.syntaxError("comment not terminated")
+		case '|':
+			if lex.scan.Peek() == '#' {
+				lex.scan.Next()
+				depth--
+			}
+		case '#':
+			if lex.scan.Peek() == '|' {
+				lex.scan.Next()
+				depth++
+			}
+		}
+	}
+}
+
+// label reads the remainder of a label #n= or #n#.
+func (lex *lexer) label() {
+	for isDigit(lex.scan.Peek()) {
+		lex.lit += string(lex.scan.Next())
+	}
+	ch := lex.scan.Next()
+	switch ch {
+	case '=':
+		lex.token = labelDef
+	case '#':
+		lex.token = labelRef
+	default:
+		lex.syntaxError("got %q after %s, want = or #", ch, lex.lit)
+	}
+	lex.lit += string(ch)
+}
+
+// isNumber reports whether the symbol s has the syntax of a number.
+func isNumber(s string) bool {
+	t := strings.TrimLeft(s, "+-")
+	if len(s)-len(t) > 1 || t == "" ||
+		!isDigit(rune(t[0])) && !(t[0] == '.' && len(t) > 1 && isDigit(rune(t[1]))) {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil || errors.Is(err, strconv.ErrRange)
+}
+
+func isDigit(r rune) bool { return '0' <= r && r <= '9' }
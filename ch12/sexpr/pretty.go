@@ -10,22 +10,74 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"strings"
 )
 
+// MarshalIndent is like Marshal but breaks long lists across lines
+// so that the output fits within 80 columns where possible.
+// See IndentOptions for other layouts.
 func MarshalIndent(v interface{}) ([]byte, error) {
-	p := printer{width: margin}
+	return IndentOptions{}.Marshal(v)
+}
+
+// IndentOptions controls the layout of pretty-printed S-expressions.
+// The zero value is the layout used by MarshalIndent.
+type IndentOptions struct {
+	// Width is the line width within which the output should fit
+	// where possible.  The default is 80.
+	Width int
+
+	// Indent is the number of columns by which an element that
+	// begins a new line is indented from the parenthesis that
+	// opens its list.  The default, 1, aligns it with the first
+	// element of the list.
+	Indent int
+
+	// AlignPairs aligns the values of the (name value) pairs of a
+	// struct or map in a column, in the style of keyword arguments,
+	// when the struct or map does not fit on one line.
+	AlignPairs bool
+
+	// OnePerLine places each element of a list that does not fit on
+	// one line on a line of its own, instead of filling each line
+	// with as many elements as fit.
+	OnePerLine bool
+
+	// Labels enables the #n= and #n# notation for shared pointers;
+	// see Encoder.SetLabels.
+	Labels bool
+}
+
+const margin = 80
+
+// Marshal returns the pretty-printed S-expression encoding of v.
+func (opts IndentOptions) Marshal(v interface{}) ([]byte, error) {
+	if opts.Width <= 0 {
+		opts.Width = margin
+	}
+	if opts.Indent <= 0 {
+		opts.Indent = 1
+	}
+	p := printer{width: opts.Width, opts: opts}
+	p.refs.labels = opts.Labels
+	p.refs.find(reflect.ValueOf(v))
 	if err := pretty(&p, reflect.ValueOf(v)); err != nil {
 		return nil, err
 	}
 	return p.Bytes(), nil
 }
 
-const margin = 80
-
 type token struct {
 	kind rune // one of "s ()" (string, blank, start, end)
 	str  string
 	size int
+	pad  int // extra spaces after an unbroken blank in an aligned pair
+}
+
+// A block records the state of a list being printed.
+type block struct {
+	indent int  // remaining space at its opening parenthesis
+	broken bool // whether it does not fit on the line
 }
 
 type printer struct {
@@ -34,8 +86,11 @@ type printer struct {
 	rtotal int      // total number of spaces needed to print stream
 
 	bytes.Buffer
-	indents []int
-	width   int // remaining space
+	blocks []block
+	width  int // remaining space
+
+	opts IndentOptions
+	refs
 }
 
 func (p *printer) string(str string) {
@@ -76,17 +131,22 @@ func (p *printer) end() {
 		p.tokens = nil
 	}
 }
-func (p *printer) space() {
+func (p *printer) space() { p.blank(0) }
+
+// blank is like space, but if the blank does not break the line and
+// the list enclosing the current one is broken, it prints pad extra
+// spaces, to align the values of a list of pairs.
+func (p *printer) blank(pad int) {
 	last := len(p.stack) - 1
 	x := p.stack[last]
 	if x.kind == ' ' {
 		x.size += p.rtotal
 		p.stack = p.stack[:last] // pop
 	}
-	t := &token{kind: ' ', size: -p.rtotal}
+	t := &token{kind: ' ', size: -p.rtotal, pad: pad}
 	p.tokens = append(p.tokens, t)
 	p.stack = append(p.stack, t)
-	p.rtotal++
+	p.rtotal += 1 + pad
 }
 func (p *printer) print(t *token) {
 	switch t.kind {
@@ -94,16 +154,21 @@ func (p *printer) print(t *token) {
 		p.WriteString(t.str)
 		p.width -= len(t.str)
 	case '(':
-		p.indents = append(p.indents, p.width)
+		p.blocks = append(p.blocks, block{p.width, t.size > p.width})
 	case ')':
-		p.indents = p.indents[:len(p.indents)-1] // pop
+		p.blocks = p.blocks[:len(p.blocks)-1] // pop
 	case ' ':
-		if t.size > p.width {
-			p.width = p.indents[len(p.indents)-1] - 1
-			fmt.Fprintf(&p.Buffer, "\n%*s", margin-p.width, "")
+		top := p.blocks[len(p.blocks)-1]
+		if t.size > p.width || p.opts.OnePerLine && top.broken {
+			p.width = top.indent - p.opts.Indent
+			fmt.Fprintf(&p.Buffer, "\n%*s", p.opts.Width-p.width, "")
 		} else {
-			p.WriteByte(' ')
-			p.width--
+			n := 1
+			if len(p.blocks) > 1 && p.blocks[len(p.blocks)-2].broken {
+				n += t.pad
+			}
+			p.WriteString(strings.Repeat(" ", n))
+			p.width -= n
 		}
 	}
 }
@@ -111,7 +176,39 @@ func (p *printer) stringf(format string, args ...interface{}) {
 	p.string(fmt.Sprintf(format, args...))
 }
 
+var (
+	listType   = reflect.TypeOf(List(nil))
+	dottedType = reflect.TypeOf(Dotted{})
+)
+
 func pretty(p *printer, v reflect.Value) error {
+	// Lists of Values are printed as lists, not as the atoms
+	// produced by their MarshalSexpr methods.
+	if v.IsValid() {
+		switch v.Type() {
+		case listType:
+			return prettyList(p, v, reflect.Value{})
+		case dottedType:
+			return prettyList(p, v.Field(0), v.Field(1))
+		}
+	}
+
+	if data, ok, err := marshal(v); ok {
+		if err != nil {
+			return err
+		}
+		// Break the lists of a method's encoding, such as a struct
+		// encoded by a generated MarshalSexpr method, as those of
+		// the equivalent Value.  Labels are not read, so an encoding
+		// containing them is printed as a single token.
+		var x interface{}
+		if len(data) > 0 && data[0] == '(' && Unmarshal(data, &x) == nil {
+			return pretty(p, reflect.ValueOf(x))
+		}
+		p.string(string(data))
+		return nil
+	}
+
 	switch v.Kind() {
 	case reflect.Invalid:
 		p.string("nil")
@@ -127,37 +224,46 @@ func pretty(p *printer, v reflect.Value) error {
 	case reflect.String:
 		p.stringf("%q", v.String())
 
-	case reflect.Array, reflect.Slice: // (value ...)
-		p.begin()
-		for i := 0; i < v.Len(); i++ {
-			if i > 0 {
-				p.space()
-			}
-			if err := pretty(p, v.Index(i)); err != nil {
-				return err
-			}
+	case reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Complex64, reflect.Complex128:
+		s, err := atom(v)
+		if err != nil {
+			return err
 		}
-		p.end()
+		p.string(s)
 
-	case reflect.Struct: // ((name value ...)
-		p.begin()
-		for i := 0; i < v.NumField(); i++ {
-			if i > 0 {
-				p.space()
-			}
-			p.begin()
-			p.string(v.Type().Field(i).Name)
-			p.space()
-			if err := pretty(p, v.Field(i)); err != nil {
-				return err
+	case reflect.Array, reflect.Slice: // (value ...)
+		return prettyList(p, v, reflect.Value{})
+
+	case reflect.Struct: // ((name value) ...)
+		var names []string
+		var values []reflect.Value
+		encodedFields(v, func(name string, v reflect.Value) error {
+			names = append(names, name)
+			values = append(values, v)
+			return nil
+		})
+		return prettyPairs(p, names, values)
+
+	case reflect.Map: // ((key value) ...)
+		if err := p.enter(v); err != nil {
+			return err
+		}
+		defer p.leave(v)
+		keys := sortedKeys(v)
+		var names []string // encoded keys, if all are atoms
+		if p.opts.AlignPairs && isAtomKind(v.Type().Key().Kind()) {
+			for _, key := range keys {
+				name, err := atom(key)
+				if err != nil {
+					return err
+				}
+				names = append(names, name)
 			}
-			p.end()
 		}
-		p.end()
-
-	case reflect.Map: // ((key value ...)
 		p.begin()
-		for i, key := range v.MapKeys() {
+		width := maxLen(names)
+		for i, key := range keys {
 			if i > 0 {
 				p.space()
 			}
@@ -165,7 +271,11 @@ func pretty(p *printer, v reflect.Value) error {
 			if err := pretty(p, key); err != nil {
 				return err
 			}
-			p.space()
+			if names != nil {
+				p.blank(width - len(names[i]))
+			} else {
+				p.space()
+			}
 			if err := pretty(p, v.MapIndex(key)); err != nil {
 				return err
 			}
@@ -174,10 +284,129 @@ func pretty(p *printer, v reflect.Value) error {
 		p.end()
 
 	case reflect.Ptr:
+		if v.IsNil() {
+			p.string("nil")
+			return nil
+		}
+		label, done := p.label(v)
+		if label != "" {
+			p.string(label)
+		}
+		if done {
+			return nil
+		}
+		if err := p.enter(v); err != nil {
+			return err
+		}
+		defer p.leave(v)
 		return pretty(p, v.Elem())
 
-	default: // float, complex, bool, chan, func, interface
+	case reflect.Interface: // ("type" value), for a non-empty interface
+		if v.IsNil() {
+			p.string("nil")
+			return nil
+		}
+		if v.NumMethod() == 0 || isValueType(v.Elem().Type()) {
+			return pretty(p, v.Elem())
+		}
+		p.begin()
+		p.stringf("%q", typeName(v.Elem().Type()))
+		p.space()
+		if err := pretty(p, v.Elem()); err != nil {
+			return err
+		}
+		p.end()
+
+	default: // chan, func, unsafe.Pointer
 		return fmt.Errorf("unsupported type: %s", v.Type())
 	}
 	return nil
 }
+
+// prettyList prints the elements of the array or slice v as a list,
+// or, if tail is valid, as a dotted list (v... . tail).
+func prettyList(p *printer, v, tail reflect.Value) error {
+	if v.Kind() == reflect.Slice {
+		if err := p.enter(v); err != nil {
+			return err
+		}
+		defer p.leave(v)
+	}
+	p.begin()
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			p.space()
+		}
+		if err := pretty(p, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	if tail.IsValid() {
+		p.space()
+		p.string(".")
+		p.space()
+		if err := pretty(p, tail); err != nil {
+			return err
+		}
+	}
+	p.end()
+	return nil
+}
+
+// prettyPairs prints the fields of a struct as a list of pairs.
+func prettyPairs(p *printer, names []string, values []reflect.Value) error {
+	width := 0
+	if p.opts.AlignPairs {
+		width = maxLen(names)
+	}
+	p.begin()
+	for i, name := range names {
+		if i > 0 {
+			p.space()
+		}
+		p.begin()
+		p.string(name)
+		if p.opts.AlignPairs {
+			p.blank(width - len(name))
+		} else {
+			p.space()
+		}
+		if err := pretty(p, values[i]); err != nil {
+			return err
+		}
+		p.end()
+	}
+	p.end()
+	return nil
+}
+
+// atom returns the encoding of v, which is of an atom kind.
+func atom(v reflect.Value) (string, error) {
+	var buf encoder
+	if err := encode(&buf, v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// isAtomKind reports whether values of kind k are encoded as atoms.
+func isAtomKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+func maxLen(names []string) int {
+	n := 0
+	for _, name := range names {
+		if len(name) > n {
+			n = len(name)
+		}
+	}
+	return n
+}
@@ -0,0 +1,187 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package sexpr
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A ref identifies the variable to which a pointer, map or slice refers.
+type ref struct {
+	p uintptr
+	t reflect.Type
+	n int // length of a slice
+}
+
+func refOf(v reflect.Value) ref {
+	r := ref{p: v.Pointer(), t: v.Type()}
+	if v.Kind() == reflect.Slice {
+		r.n = v.Len()
+	}
+	return r
+}
+
+// refs tracks the pointers, maps and slices on the path from the
+// root to the value being encoded, so that the encoder can report
+// a cycle instead of recursing forever.
+//
+// If labels is enabled, shared pointers are instead encoded using the
+// Common Lisp notation #n= for the first occurrence of a value and
+// #n# for each later one, which also breaks cycles through pointers:
+//
+//	(#1=((Name "a") (Next #2=((Name "b") (Next #1#)))) #2#)
+type refs struct {
+	path   map[ref]bool
+	labels bool
+	shared map[ref]int // label of each shared pointer, or 0 if not yet encoded
+	n      int         // number of labels assigned
+}
+
+// enter records that the encoder is visiting the pointer, map or slice v,
+// and reports an error if it is already doing so.
+func (r *refs) enter(v reflect.Value) error {
+	k := refOf(v)
+	if r.path[k] {
+		return fmt.Errorf("sexpr: encountered a cycle via %s", v.Type())
+	}
+	if r.path == nil {
+		r.path = make(map[ref]bool)
+	}
+	r.path[k] = true
+	return nil
+}
+
+func (r *refs) leave(v reflect.Value) { delete(r.path, refOf(v)) }
+
+// label returns the label with which to prefix the encoding of
+// the non-nil pointer v: #n= for the first occurrence of a shared
+// pointer, or #n# for later ones, in which case done is true and
+// the pointer's target should not be encoded.
+func (r *refs) label(v reflect.Value) (label string, done bool) {
+	k := refOf(v)
+	n, ok := r.shared[k]
+	switch {
+	case !ok:
+		return "", false
+	case n > 0:
+		return fmt.Sprintf("#%d#", n), true
+	}
+	r.n++
+	r.shared[k] = r.n
+	return fmt.Sprintf("#%d=", r.n), false
+}
+
+// find records in r.shared the pointers reachable more than once
+// from v, if labels are enabled.
+func (r *refs) find(v reflect.Value) {
+	if !r.labels {
+		return
+	}
+	seen := make(map[ref]bool)
+	var visit func(v reflect.Value)
+	visit = func(v reflect.Value) {
+		if hasMarshaler(v) {
+			return // opaque
+		}
+		switch v.Kind() {
+		case reflect.Ptr:
+			if v.IsNil() {
+				return
+			}
+			k := refOf(v)
+			if seen[k] {
+				if r.shared == nil {
+					r.shared = make(map[ref]int)
+				}
+				r.shared[k] = 0
+				return
+			}
+			seen[k] = true
+			visit(v.Elem())
+		case reflect.Interface:
+			if !v.IsNil() {
+				visit(v.Elem())
+			}
+		case reflect.Array, reflect.Slice:
+			if v.Kind() == reflect.Slice {
+				// Stop at a cycle through a slice; encode reports it.
+				k := refOf(v)
+				if seen[k] {
+					return
+				}
+				seen[k] = true
+			}
+			for i := 0; i < v.Len(); i++ {
+				visit(v.Index(i))
+			}
+		case reflect.Struct:
+			encodedFields(v, func(_ string, v reflect.Value) error {
+				visit(v)
+				return nil
+			})
+		case reflect.Map:
+			k := refOf(v)
+			if seen[k] {
+				return
+			}
+			seen[k] = true
+			for _, key := range sortedKeys(v) {
+				visit(key)
+				visit(v.MapIndex(key))
+			}
+		}
+	}
+	visit(v)
+}
+
+// sortedKeys returns the keys of map v in a deterministic order:
+// numbers and booleans by value, strings lexically, interface values
+// by the name of their dynamic type and then by value, and all others
+// by their encoding.
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return compareKeys(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+func compareKeys(x, y reflect.Value) int {
+	switch x.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compare(x.Int() < y.Int(), x.Int() > y.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return compare(x.Uint() < y.Uint(), x.Uint() > y.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compare(x.Float() < y.Float(), x.Float() > y.Float())
+	case reflect.Bool:
+		return compare(!x.Bool() && y.Bool(), x.Bool() && !y.Bool())
+	case reflect.String:
+		return strings.Compare(x.String(), y.String())
+	case reflect.Interface:
+		switch {
+		case x.IsNil() || y.IsNil():
+			return compare(x.IsNil() && !y.IsNil(), !x.IsNil() && y.IsNil())
+		case x.Elem().Type() != y.Elem().Type():
+			return strings.Compare(typeName(x.Elem().Type()), typeName(y.Elem().Type()))
+		}
+		return compareKeys(x.Elem(), y.Elem())
+	}
+	var bx, by encoder
+	encode(&bx, x) // errors are harmless here
+	encode(&by, y)
+	return bytes.Compare(bx.Bytes(), by.Bytes())
+}
+
+func compare(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return +1
+	}
+	return 0
+}
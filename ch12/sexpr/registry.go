@@ -0,0 +1,63 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package sexpr
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A value of a non-empty interface type is encoded as a list
+// ("name" value) holding the name of its dynamic type.  To decode it,
+// Unmarshal must know which type the name denotes; the registry
+// records this correspondence.  Types without methods, such as int,
+// cannot be the dynamic type of such a value, so need not be registered.
+var registry struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}
+
+// Register records the dynamic type of value under its name as
+// reported by reflect.Type.String, e.g., "main.Movie", so that
+// Unmarshal can decode interface values of that type.
+func Register(value interface{}) {
+	RegisterName(reflect.TypeOf(value).String(), value)
+}
+
+// RegisterName is like Register but records the type under the given
+// name, which Marshal then uses in place of reflect.Type.String.
+func RegisterName(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	registry.Lock()
+	defer registry.Unlock()
+	if other, ok := registry.types[name]; ok && other != t {
+		panic(fmt.Sprintf("sexpr: registering duplicate types for %q: %s != %s", name, other, t))
+	}
+	registry.types[name] = t
+	registry.names[t] = name
+}
+
+// typeName returns the name under which values of type t are encoded.
+func typeName(t reflect.Type) string {
+	registry.RLock()
+	defer registry.RUnlock()
+	if name, ok := registry.names[t]; ok {
+		return name
+	}
+	return t.String()
+}
+
+// lookupType returns the type registered under name, or nil.
+func lookupType(name string) reflect.Type {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.types[name]
+}
+
+func init() {
+	registry.types = make(map[string]reflect.Type)
+	registry.names = make(map[reflect.Type]string)
+}
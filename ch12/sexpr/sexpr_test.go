@@ -4,8 +4,15 @@
 package sexpr
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Test verifies that encoding and decoding a complex data value
@@ -71,4 +78,703 @@ func Test(t *testing.T) {
 		t.Fatal(err)
 	}
 	t.Logf("MarshalIdent() = %s\n", data)
-}
\ No newline at end of file
+}
+
+// TestKinds checks the encoding of each kind of value,
+// and that decoding it produces an equal value.
+func TestKinds(t *testing.T) {
+	Register(time.Duration(0))
+	var delay fmt.Stringer = 1500 * time.Millisecond
+	for _, test := range []struct {
+		v    interface{} // pointer to the value
+		want string
+	}{
+		{new(bool), "nil"},
+		{&[]bool{true, false}, "(t nil)"},
+		{&[]int8{-128, 127}, "(-128 127)"},
+		{&[]int64{-9223372036854775808}, "(-9223372036854775808)"},
+		{&[]uint16{0, 65535}, "(0 65535)"},
+		{&[]uint64{18446744073709551615}, "(18446744073709551615)"},
+		{&[]float64{1, -2.5, 1e21, 6.02214076e-23}, "(1.0 -2.5 1e+21 6.02214076e-23)"},
+		{&[]float32{0.1}, "(0.1)"},
+		{&[]complex128{1 + 2i, -0.5i}, "(#C(1.0 2.0) #C(0.0 -0.5))"},
+		{&[]complex64{complex(1.5, 0)}, "(#C(1.5 0.0))"},
+		{&delay, `("time.Duration" 1500000000)`},
+	} {
+		data, err := Marshal(test.v)
+		if err != nil {
+			t.Errorf("Marshal(%#v): %v", test.v, err)
+			continue
+		}
+		if string(data) != test.want {
+			t.Errorf("Marshal(%#v) = %s, want %s", test.v, data, test.want)
+		}
+		if data, err := MarshalIndent(test.v); err != nil || string(data) != test.want {
+			t.Errorf("MarshalIndent(%#v) = %s, %v, want %s", test.v, data, err, test.want)
+		}
+		got := reflect.New(reflect.TypeOf(test.v).Elem())
+		if err := Unmarshal(data, got.Interface()); err != nil {
+			t.Errorf("Unmarshal(%s): %v", data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Interface(), test.v) {
+			t.Errorf("Unmarshal(%s) = %#v, want %#v", data, got.Elem(), reflect.ValueOf(test.v).Elem())
+		}
+	}
+
+	for _, v := range []interface{}{math.Inf(1), math.NaN(), make(chan int)} {
+		if data, err := Marshal(v); err == nil {
+			t.Errorf("Marshal(%v) = %s, want error", v, data)
+		}
+		if data, err := MarshalIndent(v); err == nil {
+			t.Errorf("MarshalIndent(%v) = %s, want error", v, data)
+		}
+	}
+	var n int8
+	if err := Unmarshal([]byte("128"), &n); err == nil {
+		t.Errorf("Unmarshal(128) into int8 = %d, want error", n)
+	}
+	var x fmt.Stringer
+	if err := Unmarshal([]byte(`("main.unknown" 1)`), &x); err == nil {
+		t.Errorf("Unmarshal of unregistered type = %v, want error", x)
+	}
+}
+
+// TestEmptyInterface checks that a value held in an interface{} is
+// encoded without its type name, as the equivalent Value, and that
+// decoding it yields that Value, which encodes as the same text.
+func TestEmptyInterface(t *testing.T) {
+	type point struct{ X, Y int }
+	var shape interface{} = point{1, -2}
+	for _, test := range []struct {
+		v     interface{} // pointer to the value
+		want  string
+		value interface{} // the decoded value
+	}{
+		{&[]interface{}{nil, 1, "one", true, 1.5, uint64(math.MaxUint64)},
+			`(nil 1 "one" t 1.5 18446744073709551615)`,
+			[]interface{}{nil, Int(1), String("one"), Symbol("t"), Float(1.5), Uint(math.MaxUint64)}},
+		{&shape, `((X 1) (Y -2))`,
+			List{List{Symbol("X"), Int(1)}, List{Symbol("Y"), Int(-2)}}},
+		{&struct{ Any interface{} }{42}, `((Any 42))`, struct{ Any interface{} }{Int(42)}},
+		{&struct{ Any interface{} }{map[string]interface{}{"a": 1.5, "b": []uint8{7}}}, `((Any (("a" 1.5) ("b" (7)))))`,
+			struct{ Any interface{} }{List{List{String("a"), Float(1.5)}, List{String("b"), List{Int(7)}}}}},
+		{&[]interface{}{"string", "x"}, `("string" "x")`, []interface{}{String("string"), String("x")}},
+	} {
+		data, err := Marshal(test.v)
+		if err != nil {
+			t.Errorf("Marshal(%#v): %v", test.v, err)
+			continue
+		}
+		if string(data) != test.want {
+			t.Errorf("Marshal(%#v) = %s, want %s", test.v, data, test.want)
+		}
+		if data, err := MarshalIndent(test.v); err != nil || string(data) != test.want {
+			t.Errorf("MarshalIndent(%#v) = %s, %v, want %s", test.v, data, err, test.want)
+		}
+		got := reflect.New(reflect.TypeOf(test.v).Elem())
+		if err := Unmarshal(data, got.Interface()); err != nil {
+			t.Errorf("Unmarshal(%s): %v", data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), test.value) {
+			t.Errorf("Unmarshal(%s) = %#v, want %#v", data, got.Elem(), test.value)
+		}
+		// The round trip is exact.
+		if again, err := Marshal(got.Interface()); err != nil || string(again) != string(data) {
+			t.Errorf("Marshal(Unmarshal(%s)) = %s, %v", data, again, err)
+		}
+	}
+
+	var x interface{}
+	if err := Unmarshal([]byte("18446744073709551616"), &x); err == nil {
+		t.Errorf("Unmarshal(1<<64) into interface{} = %#v, want error", x)
+	}
+}
+
+// TestFields checks the handling of struct field tags and embedded structs.
+func TestFields(t *testing.T) {
+	type Base struct {
+		ID   int
+		Name string `sexpr:"name"`
+	}
+	type Audit struct {
+		ID      int // conflicts with Base.ID at the same depth
+		Created string
+	}
+	type Named struct{ Name string }
+	type Record struct {
+		*Base
+		Audit
+		Title  string `sexpr:"title"`
+		Notes  string `sexpr:",omitempty"`
+		Tags   []int  `sexpr:"tags,omitempty"`
+		Cache  []byte `sexpr:"-"`
+		Nested Named  `sexpr:"nested"`
+		secret int
+	}
+	r := Record{
+		Base:   &Base{ID: 1, Name: "one"},
+		Audit:  Audit{ID: 2, Created: "today"},
+		Title:  "T",
+		Cache:  []byte("x"),
+		Nested: Named{"n"},
+		secret: 3,
+	}
+	const want = `((name "one") (Created "today") (title "T") (nested ((Name "n"))))`
+	data, err := Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+	pretty, err := MarshalIndent(r)
+	if err != nil {
+		t.Fatalf("MarshalIndent: %v", err)
+	}
+	if string(pretty) != want {
+		t.Errorf("MarshalIndent = %s, want %s", pretty, want)
+	}
+
+	// Decoding matches names without regard to case, allocates
+	// embedded pointers, and ignores unknown and omitted fields.
+	var got Record
+	input := `((NAME "one") (created "today") (Title "T") (Cache (1 2)) (Notes "n")
+		(tags (1 -2)) (Unknown ((a #C(1 2)) (b -3) ())) (nested ((name "n"))))`
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want2 := Record{
+		Base:   &Base{Name: "one"},
+		Audit:  Audit{Created: "today"},
+		Title:  "T",
+		Notes:  "n",
+		Tags:   []int{1, -2},
+		Nested: Named{"n"},
+	}
+	if !reflect.DeepEqual(got, want2) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, want2)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	type entry struct {
+		Level string
+		Code  int
+	}
+	const input = `((Level "info") (Code 1))
+((Level "warn") (Code -2))
+((Level "error") (Code 3))`
+
+	// Decode successive top-level values.
+	dec := NewDecoder(strings.NewReader(input))
+	var got []entry
+	for {
+		var e entry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		got = append(got, e)
+	}
+	want := []entry{{"info", 1}, {"warn", -2}, {"error", 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode = %v, want %v", got, want)
+	}
+
+	// Read tokens, decoding the elements of a list one at a time.
+	dec = NewDecoder(strings.NewReader(`(x "s" -1 2.5 #C(1.0 -1.0) ((Code 7)) nil)`))
+	var toks []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		toks = append(toks, tok)
+		if len(toks) == 6 {
+			var e entry
+			if err := dec.Decode(&e); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			toks = append(toks, e)
+		}
+	}
+	wantToks := []Token{
+		StartList{}, Symbol("x"), String("s"), Int(-1), Float(2.5), Complex(1 - 1i),
+		entry{Code: 7}, Symbol("nil"), EndList{},
+	}
+	if !reflect.DeepEqual(toks, wantToks) {
+		t.Errorf("Token = %v, want %v", toks, wantToks)
+	}
+
+	// DecodeValue reads a registered type's ("name" value) form as a list.
+	dec = NewDecoder(strings.NewReader(`("int" 1) 'x`))
+	var values []Value
+	for {
+		x, err := dec.DecodeValue()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("DecodeValue: %v", err)
+		}
+		values = append(values, x)
+	}
+	wantValues := []Value{List{String("int"), Int(1)}, List{Symbol("quote"), Symbol("x")}}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("DecodeValue = %v, want %v", values, wantValues)
+	}
+
+	dec = NewDecoder(strings.NewReader(`("a" ]`))
+	for i := 0; i < 3; i++ {
+		if _, err := dec.Token(); i == 2 && err == nil {
+			t.Errorf("Token on ] succeeded, want error")
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	Register(time.Duration(0))
+	type inner struct{ N int8 }
+	type record struct {
+		Name  string
+		Pair  [2]int
+		Inner inner
+		Any   interface{}
+		Str   fmt.Stringer
+	}
+	for _, test := range []struct {
+		input string
+		want  string // error message
+	}{
+		{``, `sexpr: 1:1: unexpected token end of file`},
+		{`((Name "x")`, `sexpr: 1:12: unexpected end of file`},
+		{`((Name "x")) x`, `sexpr: 1:14: got "x" after top-level value, want end of file`},
+		{`((Name "\q"))`, `sexpr: 1:8: invalid char escape`},
+		{`((Name "x`, `sexpr: 1:8: literal not terminated`},
+		{`(("Name" "x"))`, `sexpr: 1:3: got "\"Name\"", want field name`},
+		{`((Name 1))`, `sexpr: 1:8: cannot unmarshal number 1 into field Name of Go value of type string`},
+		{`((Pair (1 2 3)))`, `sexpr: 1:13: cannot unmarshal list of more than 2 elements into field Pair of Go value of type [2]int`},
+		{`((Pair (1)))`, `sexpr: 1:10: cannot unmarshal list of 1 elements into field Pair of Go value of type [2]int`},
+		{"\n((Inner ((N 128))))", `sexpr: 2:13: cannot unmarshal number 128 into field Inner.N of Go value of type int8`},
+		{`((Inner ((N -x))))`, `sexpr: 1:13: cannot unmarshal symbol -x into field Inner.N of Go value of type int8`},
+		{`((Str ("main.unknown" 1)))`, `sexpr: 1:8: cannot unmarshal value of unregistered type "main.unknown" into field Str of Go value of type fmt.Stringer`},
+		{`((Str ("time.Duration" 1 2)))`, `sexpr: 1:26: got "2", want ')'`},
+		{`((Name #X(1 2)))`, `sexpr: 1:9: got "X" after #, want C`},
+		{`((Name foo))`, `sexpr: 1:8: cannot unmarshal symbol foo into field Name of Go value of type string`},
+	} {
+		var r record
+		err := Unmarshal([]byte(test.input), &r)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("Unmarshal(%q) = %v, want %s", test.input, err, test.want)
+		}
+	}
+
+	var n int
+	err := Unmarshal([]byte(`"one"`), &n)
+	if e, ok := err.(*UnmarshalTypeError); !ok || e.Type != reflect.TypeOf(n) || e.Line != 1 || e.Column != 1 {
+		t.Errorf("Unmarshal into int = %#v, want *UnmarshalTypeError", err)
+	}
+	var s []int
+	if err := Unmarshal([]byte(`(1`), &s); err == nil {
+		t.Errorf("Unmarshal((1) succeeded")
+	} else if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("Unmarshal((1) = %#v, want *SyntaxError", err)
+	}
+	if err := Unmarshal([]byte(`1`), n); err == nil || err.Error() != "sexpr: Unmarshal(non-pointer int)" {
+		t.Errorf("Unmarshal(non-pointer) = %v", err)
+	}
+
+	// Unknown fields are skipped unless disallowed.
+	const input = `((Name "x") (Extra (1 #C(1.0 2.0) -3)) (Pair (1 2)))`
+	dec := NewDecoder(strings.NewReader(input))
+	var r record
+	if err := dec.Decode(&r); err != nil || r.Name != "x" || r.Pair != [2]int{1, 2} {
+		t.Errorf("Decode = %+v, %v", r, err)
+	}
+	dec = NewDecoder(strings.NewReader(input))
+	dec.DisallowUnknownFields()
+	const want = `sexpr: 1:14: cannot unmarshal unknown field "Extra" into field Extra of Go value of type sexpr.record`
+	if err := dec.Decode(&r); err == nil || err.Error() != want {
+		t.Errorf("Decode with DisallowUnknownFields = %v, want %s", err, want)
+	}
+}
+
+// FuzzUnmarshal checks that Unmarshal reports an error for,
+// rather than panicking on, arbitrary input.
+func FuzzUnmarshal(f *testing.F) {
+	type record struct {
+		Name  string
+		Flag  bool
+		Num   int8
+		Uint  uint16
+		Float float32
+		Cmplx complex64
+		Ptr   *string
+		Array [2]int
+		Slice []string
+		Map   map[string]int
+		Keys  map[interface{}]bool
+		Any   interface{}
+		Inner struct{ X, Y float64 }
+	}
+	for _, seed := range []string{
+		`((Name "x") (Flag t) (Num -3) (Uint 7) (Float 1.5) (Cmplx #C(1.0 -2.0)))`,
+		`((Ptr "p") (Array (1 2)) (Slice ("a" "b")) (Map (("k" 1))) (Inner ((X 1.0) (Y 2.0))))`,
+		`((Keys ((1 t) (18446744073709551615 t))) (Any ("s" nil)))`,
+		`((Any (("k" t) ("j" . 2))))`,
+		`((name "x") (unknown (1 (2 #C(3 4)) -5)))`,
+		`((Keys ((() t) ((1) t))))`,
+		`((Ptr #1="p") (unknown (#2=(1) #2#)))`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var r record
+		Unmarshal(data, &r) // must not panic
+
+		var v interface{}
+		Unmarshal(data, &v)
+
+		dec := NewDecoder(bytes.NewReader(data))
+		for {
+			if _, err := dec.Token(); err != nil {
+				break
+			}
+		}
+	})
+}
+
+// A temp is a temperature in degrees Celsius.
+type temp float64
+
+func (t temp) MarshalSexpr() ([]byte, error) {
+	return []byte(fmt.Sprintf("(celsius %g)", float64(t))), nil
+}
+
+func (t *temp) UnmarshalSexpr(data []byte) error {
+	var f float64
+	if _, err := fmt.Sscanf(string(data), "(celsius %g)", &f); err != nil {
+		return err
+	}
+	if f < -273.15 {
+		return errBelowZero
+	}
+	*t = temp(f)
+	return nil
+}
+
+var errBelowZero = errors.New("below absolute zero")
+
+// An id has methods only on its pointer type.
+type id struct{ n int }
+
+func (x *id) MarshalSexpr() ([]byte, error) { return []byte(fmt.Sprintf("#C(%d.0 0.0)", x.n)), nil }
+func (x *id) UnmarshalSexpr(data []byte) error {
+	_, err := fmt.Sscanf(string(data), "#C(%d.0 0.0)", &x.n)
+	return err
+}
+
+func TestMarshaler(t *testing.T) {
+	type reading struct {
+		Temps []temp
+		When  time.Time
+		IDs   []id
+		Max   *temp
+	}
+	max := temp(-1.5)
+	r := reading{
+		Temps: []temp{21.5, -3},
+		When:  time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC),
+		IDs:   []id{{1}, {2}},
+		Max:   &max,
+	}
+	const want = `((Temps ((celsius 21.5) (celsius -3))) (When "2016-01-02T15:04:05Z") (IDs (#C(1.0 0.0) #C(2.0 0.0))) (Max (celsius -1.5)))`
+	data, err := Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+	if data, err := MarshalIndent(r); err != nil || !strings.Contains(string(data), `(When "2016-01-02T15:04:05Z")`) {
+		t.Errorf("MarshalIndent = %s, %v", data, err)
+	}
+	var got reading
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, r)
+	}
+
+	// Errors from the methods are reported with the position of the value.
+	err = Unmarshal([]byte(`((Temps ((celsius -300))))`), &got)
+	if !errors.Is(err, errBelowZero) || !strings.HasPrefix(err.Error(), "sexpr: 1:10: sexpr.temp: ") {
+		t.Errorf("Unmarshal of invalid temp = %v", err)
+	}
+	err = Unmarshal([]byte(`((When "yesterday"))`), &got)
+	if err == nil || !strings.HasPrefix(err.Error(), "sexpr: 1:8: time.Time: ") {
+		t.Errorf("Unmarshal of invalid time = %v", err)
+	}
+}
+
+func TestMapOrder(t *testing.T) {
+	for _, test := range []struct {
+		v    interface{}
+		want string
+	}{
+		{map[string]int{"b": 2, "a": 1, "c": 3, "": 0}, `(("" 0) ("a" 1) ("b" 2) ("c" 3))`},
+		{map[int]bool{10: true, -1: false, 2: true}, `((-1 nil) (2 t) (10 t))`},
+		{map[float64]int{2.5: 1, -0.5: 2}, `((-0.5 2) (2.5 1))`},
+		{map[bool]int{true: 1, false: 0}, `((nil 0) (t 1))`},
+		{map[[2]int]int{{2, 1}: 1, {1, 2}: 2}, `(((1 2) 2) ((2 1) 1))`},
+		{map[interface{}]int{"x": 1, 2: 2, nil: 3, 1: 4}, `((nil 3) (1 4) (2 2) ("x" 1))`},
+	} {
+		for i := 0; i < 5; i++ {
+			data, err := Marshal(test.v)
+			if err != nil || string(data) != test.want {
+				t.Errorf("Marshal(%v) = %s, %v, want %s", test.v, data, err, test.want)
+				break
+			}
+			data, err = MarshalIndent(test.v)
+			if err != nil || string(data) != test.want {
+				t.Errorf("MarshalIndent(%v) = %s, %v, want %s", test.v, data, err, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestIndentOptions(t *testing.T) {
+	type movie struct {
+		Title  string
+		Year   int
+		Actor  map[string]string
+		Rating float64
+		Notes  Value
+	}
+	m := movie{
+		Title: "Dr. Strangelove",
+		Year:  1964,
+		Actor: map[string]string{
+			"Dr. Strangelove":     "Peter Sellers",
+			"Gen. Buck Turgidson": "George C. Scott",
+		},
+		Rating: 8.4,
+		Notes:  List{Symbol("quote"), Dotted{List{Int(1), String("two")}, Float(3)}},
+	}
+	for _, test := range []struct {
+		opts IndentOptions
+		want string
+	}{
+		{IndentOptions{}, `((Title "Dr. Strangelove") (Year 1964)
+ (Actor
+  (("Dr. Strangelove" "Peter Sellers")
+   ("Gen. Buck Turgidson" "George C. Scott"))) (Rating 8.4)
+ (Notes (quote (1 "two" . 3.0))))`},
+		{IndentOptions{Width: 200}, `((Title "Dr. Strangelove") (Year 1964) (Actor (("Dr. Strangelove" "Peter Sellers") ("Gen. Buck Turgidson" "George C. Scott"))) (Rating 8.4) (Notes (quote (1 "two" . 3.0))))`},
+		{IndentOptions{Width: 60, Indent: 2, AlignPairs: true, OnePerLine: true}, `((Title  "Dr. Strangelove")
+  (Year   1964)
+  (Actor
+    (("Dr. Strangelove"     "Peter Sellers")
+      ("Gen. Buck Turgidson" "George C. Scott")))
+  (Rating 8.4)
+  (Notes  (quote (1 "two" . 3.0))))`},
+	} {
+		data, err := test.opts.Marshal(m)
+		if err != nil || string(data) != test.want {
+			t.Errorf("%+v.Marshal = %s, %v, want %s", test.opts, data, err, test.want)
+		}
+	}
+
+	// Shared pointers are labeled if requested.
+	type node struct {
+		Name string
+		Next *node
+	}
+	a := &node{Name: "a"}
+	a.Next = a
+	const want = `#1=((Name "a") (Next #1#))`
+	if data, err := (IndentOptions{Labels: true}).Marshal(a); err != nil || string(data) != want {
+		t.Errorf("Marshal(cycle) with labels = %s, %v, want %s", data, err, want)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	a := &node{Name: "a"}
+	b := &node{Name: "b", Next: a}
+	a.Next = b
+	const wantErr = "sexpr: encountered a cycle via *sexpr.node"
+	if _, err := Marshal(a); err == nil || err.Error() != wantErr {
+		t.Errorf("Marshal(cycle) = %v, want %s", err, wantErr)
+	}
+	if _, err := MarshalIndent(a); err == nil || err.Error() != wantErr {
+		t.Errorf("MarshalIndent(cycle) = %v, want %s", err, wantErr)
+	}
+	m := map[string]interface{}{}
+	m["self"] = m
+	if _, err := Marshal(m); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Marshal(cyclic map) = %v, want cycle error", err)
+	}
+
+	// Shared structure without a cycle is duplicated.
+	c := &node{Name: "c"}
+	data, err := Marshal([]*node{c, c})
+	if want := `(((Name "c") (Next nil)) ((Name "c") (Next nil)))`; err != nil || string(data) != want {
+		t.Errorf("Marshal(shared) = %s, %v, want %s", data, err, want)
+	}
+
+	// With labels, shared pointers are encoded once.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetLabels(true)
+	if err := enc.Encode([]*node{a, b, c}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(a); err != nil {
+		t.Fatal(err)
+	}
+	const want = `(#1=((Name "a") (Next #2=((Name "b") (Next #1#)))) #2# ((Name "c") (Next nil)))
+#1=((Name "a") (Next ((Name "b") (Next #1#))))
+`
+	if buf.String() != want {
+		t.Errorf("Encode with labels = %s, want %s", buf.String(), want)
+	}
+
+	dec := NewDecoder(&buf)
+	var nodes []*node
+	if err := dec.Decode(&nodes); err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 || nodes[0].Next != nodes[1] || nodes[1].Next != nodes[0] ||
+		nodes[0].Name != "a" || nodes[1].Name != "b" || nodes[2].Name != "c" {
+		t.Errorf("Decode(%s) = %v", want, nodes)
+	}
+	var n *node
+	if err := dec.Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Next.Next != n {
+		t.Errorf("Decode of cycle did not produce a cycle")
+	}
+
+	for _, test := range []struct{ input, want string }{
+		{`(#1# nil)`, "sexpr: 1:2: undefined label #1#"},
+		{`(#1=((Name "a")) #1=((Name "b")))`, "sexpr: 1:18: label #1= defined twice"},
+		{`(#1 ((Name "a")))`, `sexpr: 1:2: got ' ' after #1, want = or #`},
+	} {
+		var nodes []*node
+		if err := Unmarshal([]byte(test.input), &nodes); err == nil || err.Error() != test.want {
+			t.Errorf("Unmarshal(%s) = %v, want %s", test.input, err, test.want)
+		}
+	}
+	// Labels may be skipped along with unknown fields.
+	var x struct{ Name string }
+	if err := Unmarshal([]byte(`((Other (#1=((Name "a")) #1#)) (Name "x"))`), &x); err != nil || x.Name != "x" {
+		t.Errorf("Unmarshal with labelled unknown field = %v, %v", x, err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	const input = `; A hand-written configuration file.
+(config #| block comments #| may nest |# |#
+  (name . "server")           ; a dotted pair
+  (max-results 100)
+  (ops (+ - <= *x* a.b :key))
+  (nums (-1 +2 -.5 1e3))
+  (quoted 'x)
+  (pairs ((a . 1) (b . (2 3)) (c . nil) (d e . f)))
+  (empty ()))`
+	var v interface{}
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := List{
+		Symbol("config"),
+		Dotted{List{Symbol("name")}, String("server")},
+		List{Symbol("max-results"), Int(100)},
+		List{Symbol("ops"), List{Symbol("+"), Symbol("-"), Symbol("<="), Symbol("*x*"), Symbol("a.b"), Symbol(":key")}},
+		List{Symbol("nums"), List{Int(-1), Int(2), Float(-0.5), Float(1000)}},
+		List{Symbol("quoted"), List{Symbol("quote"), Symbol("x")}},
+		List{Symbol("pairs"), List{
+			Dotted{List{Symbol("a")}, Int(1)},
+			List{Symbol("b"), Int(2), Int(3)},
+			List{Symbol("c")},
+			Dotted{List{Symbol("d"), Symbol("e")}, Symbol("f")},
+		}},
+		List{Symbol("empty"), List{}},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal = %#v, want %#v", v, want)
+	}
+
+	// A Value marshals back to equivalent text.
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	const wantText = `(config (name . "server") (max-results 100) (ops (+ - <= *x* a.b :key)) ` +
+		`(nums (-1 2 -0.5 1000.0)) (quoted (quote x)) (pairs ((a . 1) (b 2 3) (c) (d e . f))) (empty ()))`
+	if string(data) != wantText {
+		t.Errorf("Marshal = %s, want %s", data, wantText)
+	}
+	var v2 interface{}
+	if err := Unmarshal(data, &v2); err != nil || !reflect.DeepEqual(v2, v) {
+		t.Errorf("Unmarshal(Marshal(v)) = %v, %v", v2, err)
+	}
+
+	// Struct fields and map entries may be written as dotted pairs,
+	// and values in an interface{} field are decoded generically.
+	var config struct {
+		Name       string
+		MaxResults int `sexpr:"max-results"`
+		Ops        interface{}
+		Pairs      map[string]interface{}
+	}
+	const structInput = `((name . "server") (max-results 100) (ops (+ 1 "two")) (pairs (("a" . 1) ("b" . nil))))`
+	if err := Unmarshal([]byte(structInput), &config); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if config.Name != "server" || config.MaxResults != 100 ||
+		!reflect.DeepEqual(config.Ops, List{Symbol("+"), Int(1), String("two")}) ||
+		!reflect.DeepEqual(config.Pairs, map[string]interface{}{"a": Int(1), "b": nil}) {
+		t.Errorf("Unmarshal = %+v", config)
+	}
+
+	// Tokens.
+	dec := NewDecoder(strings.NewReader(`('a . -1) ; done`))
+	var toks []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		toks = append(toks, tok)
+	}
+	wantToks := []Token{StartList{}, Quote{}, Symbol("a"), Dot{}, Int(-1), EndList{}}
+	if !reflect.DeepEqual(toks, wantToks) {
+		t.Errorf("Token = %v, want %v", toks, wantToks)
+	}
+
+	for _, test := range []struct{ input, want string }{
+		{`(a #| open`, "sexpr: 1:4: comment not terminated"},
+		{`(. a)`, `sexpr: 1:2: unexpected token "."`},
+		{`(a . b c)`, `sexpr: 1:8: got "c" after tail of dotted list, want ')'`},
+		{`(#1=(a))`, `sexpr: 1:2: label "#1=" in a value of type interface{}`},
+	} {
+		var v interface{}
+		if err := Unmarshal([]byte(test.input), &v); err == nil || err.Error() != test.want {
+			t.Errorf("Unmarshal(%s) = %v, want %s", test.input, err, test.want)
+		}
+	}
+}
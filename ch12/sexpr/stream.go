@@ -0,0 +1,213 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package sexpr
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"text/scanner"
+)
+
+// A Decoder reads and decodes S-expressions from an input stream.
+type Decoder struct {
+	lex    *lexer
+	primed bool // whether lex.token holds the first token
+	nested bool // whether lex belongs to an enclosing Unmarshal or Decode
+
+	name    string           // name of the current field of DecodeFields
+	namePos scanner.Position // position of that name
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{lex: newLexer(r)}
+}
+
+// DisallowUnknownFields causes the Decoder to return an error when
+// the input contains a struct field name that matches no field of
+// the destination struct.
+func (dec *Decoder) DisallowUnknownFields() { dec.lex.disallowUnknown = true }
+
+// Decode reads the next S-expression from the input and stores it
+// in the variable pointed to by out.  At the end of the input it
+// returns io.EOF.
+func (dec *Decoder) Decode(out interface{}) (err error) {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(out)}
+	}
+	defer dec.catchError(&err)
+	if dec.eof() {
+		return io.EOF
+	}
+	if !dec.nested {
+		dec.lex.labels = nil // labels are local to each value
+	}
+	read(dec.lex, v.Elem())
+	return nil
+}
+
+// DecodeValue reads the next S-expression from the input and returns
+// it as a Value, as Decode into an interface{} does.  It reads any
+// S-expression exactly as written, except for labels.
+// At the end of the input it returns nil, io.EOF.
+func (dec *Decoder) DecodeValue() (x Value, err error) {
+	defer dec.catchError(&err)
+	if dec.eof() {
+		return nil, io.EOF
+	}
+	return readValue(dec.lex), nil
+}
+
+// Skip reads and discards the next S-expression from the input.
+// At the end of the input it returns io.EOF.
+func (dec *Decoder) Skip() (err error) {
+	defer dec.catchError(&err)
+	if dec.eof() {
+		return io.EOF
+	}
+	skip(dec.lex)
+	return nil
+}
+
+// SkipField discards the value of the current field of DecodeFields,
+// whose name matches no field of the struct pointed to by ptr.  If
+// DisallowUnknownFields has been called, it instead reports an error,
+// as Decode would.
+func (dec *Decoder) SkipField(ptr interface{}) (err error) {
+	defer dec.catchError(&err)
+	if dec.lex.disallowUnknown {
+		panic(&UnmarshalTypeError{
+			Value: fmt.Sprintf("unknown field %q", dec.name),
+			Type:  reflect.TypeOf(ptr).Elem(),
+			Field: dec.lex.field,
+			Line:  dec.namePos.Line, Column: dec.namePos.Column,
+		})
+	}
+	if dec.eof() {
+		return io.EOF
+	}
+	skip(dec.lex)
+	return nil
+}
+
+// DecodeFields reads the next S-expression from the input, which must
+// be a list of fields ((name value) ...) such as Marshal produces for a
+// struct, or nil, which has no fields.  For each field it calls field
+// with the field's name; field must consume the field's value, by
+// calling Decode, or SkipField if it does not recognize the name, and
+// any error it returns stops decoding.  DecodeFields is intended for
+// DecodeSexpr methods, such as those generated by sexprgen, that
+// decode a struct without reflection.
+func (dec *Decoder) DecodeFields(field func(name string) error) (err error) {
+	defer dec.catchError(&err)
+	if dec.eof() {
+		return io.EOF
+	}
+	lex := dec.lex
+	switch {
+	case lex.token == scanner.Ident && lex.text() == "nil":
+		lex.next()
+		return nil
+	}
+	lex.consume('(')
+	outer := lex.field
+	defer func() { lex.field = outer }()
+	for !endList(lex) {
+		lex.consume('(')
+		if lex.token != scanner.Ident {
+			lex.syntaxError("got %s, want field name", lex.describe())
+		}
+		name := lex.text()
+		lex.field = name
+		if outer != "" {
+			lex.field = outer + "." + name
+		}
+		dec.name, dec.namePos = name, lex.pos
+		lex.next()
+		if lex.token == '.' { // (name . value)
+			lex.next()
+		}
+		if err := field(name); err != nil {
+			return err
+		}
+		lex.consume(')')
+	}
+	lex.next()
+	return nil
+}
+
+// A Token is an interface holding one of the token types:
+// Symbol, String, Int, Uint, Float, Complex, Quote, Dot, StartList
+// or EndList.
+type Token interface{}
+
+type (
+	Symbol    string     // a symbol such as nil, t or a struct field name
+	String    string     // a string literal, unquoted
+	Int       int64      // an integer literal
+	Uint      uint64     // an integer literal too large for an Int
+	Float     float64    // a floating-point literal
+	Complex   complex128 // a complex literal #C(re im)
+	Quote     struct{}   // a quotation mark, as in 'x
+	Dot       struct{}   // the dot of a dotted list (a . b)
+	StartList struct{}   // an opening parenthesis
+	EndList   struct{}   // a closing parenthesis
+)
+
+// Token returns the next token in the input stream.
+// At the end of the input it returns nil, io.EOF.
+//
+// Token does not check that parentheses are balanced, and does not
+// support the labels #n= and #n# of shared structure.  Calls to
+// Token may be interleaved with calls to Decode, for example to
+// decode the elements of a long list one at a time.
+func (dec *Decoder) Token() (tok Token, err error) {
+	defer dec.catchError(&err)
+	dec.prime()
+	lex := dec.lex
+	switch lex.token {
+	case scanner.EOF:
+		return nil, io.EOF
+	case scanner.Ident, scanner.String, scanner.Int, scanner.Float, '#':
+		return readAtom(lex), nil
+	case '\'':
+		tok = Quote{}
+	case '.':
+		tok = Dot{}
+	case '(':
+		tok = StartList{}
+	case ')':
+		tok = EndList{}
+	default:
+		lex.syntaxError("unexpected token %s", lex.describe())
+	}
+	lex.next()
+	return tok, nil
+}
+
+// eof reports whether the input is exhausted.  For a decoder passed
+// to a DecodeSexpr method, the value must be present, so the end of
+// the input is a syntax error.
+func (dec *Decoder) eof() bool {
+	dec.prime()
+	if dec.lex.token != scanner.EOF {
+		return false
+	}
+	if dec.nested {
+		dec.lex.syntaxError("unexpected end of file")
+	}
+	return true
+}
+
+// prime reads the first token, if it has not already been read.
+// It is deferred until the first call to Decode or Token
+// so that NewDecoder does not block reading its input.
+func (dec *Decoder) prime() {
+	if !dec.primed {
+		dec.primed = true
+		dec.lex.next()
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package sexpr
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"text/scanner"
+)
+
+// A Value is an S-expression decoded without reference to a Go type,
+// as when decoding into an interface{}.  It holds one of the atom
+// types Symbol, String, Int, Uint, Float and Complex, or a List or
+// Dotted list of Values; a nil Value is the symbol nil.  A quoted
+// value 'x is read as the list (quote x).
+//
+// Decoding into an interface{} always yields a Value, and Marshal
+// encodes the contents of an interface{} as it would encode them
+// elsewhere, so that decoding them yields the equivalent Value: an
+// int becomes an Int, a struct or map a List of pairs, and true the
+// Symbol t.  Only a value of a non-empty interface type, such as
+// fmt.Stringer, is encoded in the list form ("type" value) that
+// decodes as a value of the named type; see Register.
+type Value interface{}
+
+// A List is a proper list of values, (a b c).
+type List []Value
+
+// A Dotted is an improper list (a b . c) whose final element, Tail,
+// is not a list.
+type Dotted struct {
+	List List
+	Tail Value
+}
+
+// Symbols, lists and dotted lists marshal themselves;
+// the other atom types use the usual encoding of their kind.
+
+func (s Symbol) MarshalSexpr() ([]byte, error) { return []byte(s), nil }
+
+func (l List) MarshalSexpr() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	if err := writeValues(&buf, l); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(')')
+	return buf.Bytes(), nil
+}
+
+func (d Dotted) MarshalSexpr() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	values := append(append([]Value(nil), d.List...), Symbol("."), d.Tail)
+	if err := writeValues(&buf, values); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(')')
+	return buf.Bytes(), nil
+}
+
+func writeValues(buf *bytes.Buffer, values []Value) error {
+	for i, x := range values {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		data, err := Marshal(x)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+// isValueType reports whether t is one of the types of a Value,
+// which are encoded without the ("type" value) wrapper of other
+// interface values since they decode as themselves.
+func isValueType(t reflect.Type) bool {
+	switch t {
+	case reflect.TypeOf(Symbol("")), reflect.TypeOf(String("")),
+		reflect.TypeOf(Int(0)), reflect.TypeOf(Uint(0)), reflect.TypeOf(Float(0)),
+		reflect.TypeOf(Complex(0)), reflect.TypeOf(List(nil)),
+		reflect.TypeOf(Dotted{}):
+		return true
+	}
+	return false
+}
+
+// readValue reads an S-expression as a Value.
+func readValue(lex *lexer) Value {
+	switch lex.token {
+	case '\'': // 'x
+		lex.next()
+		return List{Symbol("quote"), readValue(lex)}
+	case '(':
+		lex.next()
+		x := readValueList(lex)
+		lex.next() // consume ')'
+		return x
+	case labelDef, labelRef:
+		lex.syntaxError("label %s in a value of type interface{}", lex.describe())
+	}
+	if x := readAtom(lex); x != Symbol("nil") {
+		return x
+	}
+	return nil
+}
+
+// readValueList reads the elements of a list, up to the ')'.
+func readValueList(lex *lexer) Value {
+	list := List{}
+	for !endList(lex) {
+		if lex.token == '.' && len(list) > 0 {
+			lex.next()
+			tail := readValue(lex)
+			if lex.token != ')' {
+				lex.syntaxError("got %s after tail of dotted list, want ')'", lex.describe())
+			}
+			// Normalize (a . (b c)) to (a b c) and (a . nil) to (a).
+			switch tail := tail.(type) {
+			case nil:
+				return list
+			case List:
+				return append(list, tail...)
+			case Dotted:
+				return Dotted{append(list, tail.List...), tail.Tail}
+			}
+			return Dotted{list, tail}
+		}
+		list = append(list, readValue(lex))
+	}
+	return list
+}
+
+// readAtom reads a symbol, string, number, or #C(re im) complex number.
+func readAtom(lex *lexer) Value {
+	var x Value
+	switch lex.token {
+	case scanner.Ident:
+		x = Symbol(lex.text())
+	case scanner.String:
+		s, err := strconv.Unquote(lex.text())
+		if err != nil {
+			lex.syntaxError("invalid string literal %s", lex.text())
+		}
+		x = String(s)
+	case scanner.Int:
+		if i, err := strconv.ParseInt(lex.text(), 10, 64); err == nil {
+			x = Int(i)
+		} else if u, err := strconv.ParseUint(lex.text(), 10, 64); err == nil {
+			x = Uint(u) // too large for an Int
+		} else {
+			lex.typeError("number "+lex.text(), reflect.TypeOf(Uint(0)))
+		}
+	case scanner.Float:
+		f, err := strconv.ParseFloat(lex.text(), 64)
+		if err != nil {
+			lex.typeError("number "+lex.text(), reflect.TypeOf(Float(0)))
+		}
+		x = Float(f)
+	case '#':
+		c := reflect.New(reflect.TypeOf(complex128(0))).Elem()
+		read(lex, c)
+		return Complex(c.Complex())
+	default:
+		lex.syntaxError("unexpected token %s", lex.describe())
+	}
+	lex.next()
+	return x
+}
@@ -0,0 +1,251 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go_example/ch12/sexpr"
+)
+
+// An object is a JSON object whose members keep their input order.
+type object []member
+
+type member struct {
+	key   string
+	value interface{}
+}
+
+func (obj object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range obj {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSON(m.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalJSON(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalJSON is like json.Marshal, but like the encoder used for
+// output, it does not escape the characters <, > and &.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// readJSON reads the next JSON value from dec, which must use
+// json.Number for numbers, as one of nil, bool, string, json.Number,
+// []interface{} or object.  At the end of the input it returns io.EOF.
+func readJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			x, err := readJSON(dec)
+			if err != nil {
+				return nil, eof(err)
+			}
+			list = append(list, x)
+		}
+		_, err := dec.Token() // ']'
+		return list, eof(err)
+	case json.Delim('{'):
+		obj := object{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, eof(err)
+			}
+			value, err := readJSON(dec)
+			if err != nil {
+				return nil, eof(err)
+			}
+			obj = append(obj, member{key.(string), value})
+		}
+		_, err := dec.Token() // '}'
+		return obj, eof(err)
+	}
+	return tok, nil
+}
+
+// eof converts an io.EOF within a value into io.ErrUnexpectedEOF.
+func eof(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// fromSexpr converts an S-expression into the JSON form of readJSON.
+func fromSexpr(x sexpr.Value) (interface{}, error) {
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case sexpr.Symbol:
+		if x == "t" {
+			return true, nil
+		}
+		return string(x), nil
+	case sexpr.String:
+		return string(x), nil
+	case sexpr.Int:
+		return json.Number(strconv.FormatInt(int64(x), 10)), nil
+	case sexpr.Float:
+		return json.Number(strconv.FormatFloat(float64(x), 'g', -1, 64)), nil
+	case sexpr.List:
+		if obj, ok, err := fromPairs(x); ok {
+			return obj, err
+		}
+		list := []interface{}{}
+		for _, elem := range x {
+			y, err := fromSexpr(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, y)
+		}
+		return list, nil
+	}
+	data, _ := sexpr.Marshal(x)
+	return nil, fmt.Errorf("%s has no JSON equivalent", data)
+}
+
+// fromPairs converts a list of pairs (key value) or (key . value),
+// whose keys are symbols or strings, into an object.
+// It reports false if the list is not of that form.
+func fromPairs(list sexpr.List) (object, bool, error) {
+	if len(list) == 0 {
+		return nil, false, nil
+	}
+	obj := make(object, 0, len(list))
+	for _, elem := range list {
+		var key, value sexpr.Value
+		switch elem := elem.(type) {
+		case sexpr.List:
+			if len(elem) != 2 {
+				return nil, false, nil
+			}
+			key, value = elem[0], elem[1]
+		case sexpr.Dotted:
+			if len(elem.List) != 1 {
+				return nil, false, nil
+			}
+			key, value = elem.List[0], elem.Tail
+		default:
+			return nil, false, nil
+		}
+		var name string
+		switch key := key.(type) {
+		case sexpr.Symbol:
+			name = string(key)
+		case sexpr.String:
+			name = string(key)
+		default:
+			return nil, false, nil
+		}
+		obj = append(obj, member{name, value})
+	}
+	for i := range obj {
+		y, err := fromSexpr(obj[i].value)
+		if err != nil {
+			return nil, true, err
+		}
+		obj[i].value = y
+	}
+	return obj, true, nil
+}
+
+// toSexpr converts a value in the JSON form of readJSON
+// into an S-expression.
+func toSexpr(x interface{}) sexpr.Value {
+	switch x := x.(type) {
+	case bool:
+		if x {
+			return sexpr.Symbol("t")
+		}
+		return nil
+	case string:
+		return sexpr.String(x)
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return sexpr.Int(i)
+		}
+		f, _ := x.Float64() // json.Decoder has checked the syntax
+		return sexpr.Float(f)
+	case []interface{}:
+		list := sexpr.List{}
+		for _, elem := range x {
+			list = append(list, toSexpr(elem))
+		}
+		return list
+	case object:
+		list := sexpr.List{}
+		for _, m := range x {
+			var key sexpr.Value = sexpr.String(m.key)
+			if isSymbol(m.key) {
+				key = sexpr.Symbol(m.key)
+			}
+			list = append(list, sexpr.List{key, toSexpr(m.value)})
+		}
+		return list
+	}
+	return nil // null
+}
+
+// isSymbol reports whether s reads back as the symbol s.
+func isSymbol(s string) bool {
+	if s == "nil" {
+		return false // reads as the empty list
+	}
+	dec := sexpr.NewDecoder(strings.NewReader(s))
+	tok, err := dec.Token()
+	if err != nil || tok != sexpr.Symbol(s) {
+		return false
+	}
+	_, err = dec.Token()
+	return err == io.EOF
+}
+
+func writeSexpr(x interface{}, compact bool) error {
+	var data []byte
+	var err error
+	if compact {
+		data, err = sexpr.Marshal(x)
+	} else {
+		data, err = sexpr.MarshalIndent(x)
+	}
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = out.Write(data)
+	return err
+}
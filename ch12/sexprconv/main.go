@@ -0,0 +1,177 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+// Sexprconv converts between S-expressions, JSON and YAML-like text.
+//
+// Usage:
+//
+//	sexprconv [-from sexpr|json] [-to json|sexpr|yaml] [-compact] [file ...]
+//
+// Sexprconv reads each named file, or its standard input if there are
+// none, and writes every value it contains to the standard output in
+// the requested form, pretty-printed unless -compact is given.
+// Malformed input is reported with its file name, line and column,
+// and causes sexprconv to exit with status 1.
+//
+// S-expressions and JSON correspond as follows:
+//
+//	nil                  null
+//	t                    true
+//	"s", symbol s        "s"
+//	1, 2.5               1, 2.5
+//	(a b c)              [a, b, c]
+//	((k v) (k2 . v2))    {"k": v, "k2": v2}
+//
+// A list is converted to an object if every element is a pair whose
+// first element is a symbol or string.  JSON false becomes nil, and
+// object keys become symbols where possible.  Complex numbers and
+// dotted lists other than pairs have no JSON equivalent.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go_example/ch12/sexpr"
+)
+
+var (
+	from    = flag.String("from", "sexpr", "input format: sexpr or json")
+	to      = flag.String("to", "json", "output format: json, sexpr or yaml")
+	compact = flag.Bool("compact", false, "write each value on one line")
+)
+
+var out io.Writer = os.Stdout // modified during testing
+
+func main() {
+	flag.Parse()
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status := 0
+	for _, file := range files {
+		if err := convertFile(file); err != nil {
+			fmt.Fprintf(os.Stderr, "sexprconv: %v\n", err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+func convertFile(file string) error {
+	var data []byte
+	var err error
+	if file == "-" {
+		file = "<stdin>"
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	return convert(file, data, *from, *to, *compact)
+}
+
+// convert converts each value in data, read from the named file,
+// from one format to another.  S-expressions are read as sexpr.Values,
+// and JSON as the values described at readJSON.
+func convert(file string, data []byte, from, to string, compact bool) error {
+	var read func() (interface{}, error)
+	switch from {
+	case "sexpr":
+		dec := sexpr.NewDecoder(bytes.NewReader(data))
+		read = func() (interface{}, error) { return dec.DecodeValue() }
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		read = func() (interface{}, error) { return readJSON(dec) }
+	default:
+		return fmt.Errorf("unknown input format %q", from)
+	}
+
+	var write func(x interface{}) error
+	switch to {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		if !compact {
+			enc.SetIndent("", "  ")
+		}
+		write = enc.Encode
+	case "sexpr":
+		write = func(x interface{}) error { return writeSexpr(x, compact) }
+	case "yaml":
+		n := 0
+		write = func(x interface{}) error {
+			if n++; n > 1 {
+				fmt.Fprintln(out, "---")
+			}
+			return writeYAML(x, compact)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", to)
+	}
+
+	for {
+		x, err := read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return position(file, data, err)
+		}
+		switch {
+		case from == "sexpr" && to != "sexpr":
+			if x, err = fromSexpr(x); err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+		case from == "json" && to == "sexpr":
+			x = toSexpr(x)
+		}
+		if err := write(x); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+}
+
+// position prefixes err with the file name and, for syntax errors,
+// the line and column at which it occurred.
+func position(file string, data []byte, err error) error {
+	var serr *sexpr.SyntaxError
+	var terr *sexpr.UnmarshalTypeError
+	var jerr *json.SyntaxError
+	switch {
+	case errors.As(err, &serr):
+		return fmt.Errorf("%s:%d:%d: %s", file, serr.Line, serr.Column, serr.Msg)
+	case errors.As(err, &terr):
+		return fmt.Errorf("%s:%d:%d: invalid %s", file, terr.Line, terr.Column, terr.Value)
+	case errors.As(err, &jerr):
+		line, col := lineCol(data, jerr.Offset-1) // Offset follows the bad byte
+		return fmt.Errorf("%s:%d:%d: %v", file, line, col, jerr)
+	case err == io.ErrUnexpectedEOF:
+		line, col := lineCol(data, int64(len(data)))
+		return fmt.Errorf("%s:%d:%d: unexpected end of JSON input", file, line, col)
+	}
+	return fmt.Errorf("%s: %v", file, err)
+}
+
+// lineCol returns the line and column, starting at 1,
+// of the byte at the given offset in data.
+func lineCol(data []byte, offset int64) (line, col int) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = 1 + bytes.Count(before, []byte("\n"))
+	col = 1 + len(before) - (bytes.LastIndexByte(before, '\n') + 1)
+	return line, col
+}
@@ -0,0 +1,91 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	const movie = `; a movie
+((Title "Dr. Strangelove") (Year 1964) (Rating 8.4) (Color nil) (Restored t)
+ (Actor (("Dr. Strangelove" . "Peter Sellers"))) (Oscars ("Best Actor")) (Tags ()))
+(1 "<2>")`
+	for _, test := range []struct {
+		from, to string
+		compact  bool
+		input    string
+		want     string
+	}{
+		{"sexpr", "json", true, movie, `{"Title":"Dr. Strangelove","Year":1964,"Rating":8.4,"Color":null,"Restored":true,"Actor":{"Dr. Strangelove":"Peter Sellers"},"Oscars":["Best Actor"],"Tags":[]}
+[1,"<2>"]
+`},
+		{"sexpr", "json", false, `((a 1) (b (2 3)))`, `{
+  "a": 1,
+  "b": [
+    2,
+    3
+  ]
+}
+`},
+		{"sexpr", "yaml", false, movie, `Title: "Dr. Strangelove"
+Year: 1964
+Rating: 8.4
+Color: null
+Restored: true
+Actor:
+  "Dr. Strangelove": "Peter Sellers"
+Oscars:
+  - "Best Actor"
+Tags: []
+---
+- 1
+- "<2>"
+`},
+		{"sexpr", "yaml", false, `(((a 1) (b 2)) (3 (4)))`, `- a: 1
+  b: 2
+- - 3
+  - - 4
+`},
+		{"json", "sexpr", true, `{"Title": "Dr. Strangelove", "null": null, "no": false, "a b": [1, 2.5, 1e3, true]} []`,
+			`((Title "Dr. Strangelove") (null nil) (no nil) ("a b" (1 2.5 1000.0 t)))
+()
+`},
+		{"sexpr", "sexpr", false, `(quote #| comment |# (1 . 2))`, "(quote (1 . 2))\n"},
+	} {
+		buf := new(bytes.Buffer)
+		out = buf
+		if err := convert("test", []byte(test.input), test.from, test.to, test.compact); err != nil {
+			t.Errorf("convert %s to %s: %v", test.from, test.to, err)
+			continue
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("convert %s to %s (%q) = %s, want %s", test.from, test.to, test.input, got, test.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		from, to string
+		input    string
+		want     string
+	}{
+		{"sexpr", "json", "(1\n (2 \"x)", "test:2:5: literal not terminated"},
+		{"sexpr", "json", "(1 2))", "test:1:6: unexpected token \")\""},
+		{"sexpr", "json", "(1 99999999999999999999)", "test:1:4: invalid number 99999999999999999999"},
+		{"sexpr", "json", "(1 #C(1 2))", "test: #C(1.0 2.0) has no JSON equivalent"},
+		{"sexpr", "yaml", "(1 . 2)", "test: (1 . 2) has no JSON equivalent"},
+		{"json", "sexpr", "{\"a\": [1,\n 2 3]}", "test:2:4: invalid character '3' after array element"},
+		{"json", "sexpr", "[1, 2", "test:1:5: unexpected end of JSON input"},
+		{"xml", "json", "", `unknown input format "xml"`},
+	} {
+		out = new(bytes.Buffer)
+		err := convert("test", []byte(test.input), test.from, test.to, false)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("convert %s to %s (%q) = %v, want %s", test.from, test.to, test.input, err, test.want)
+		}
+	}
+}
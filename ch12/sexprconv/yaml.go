@@ -0,0 +1,105 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// writeYAML writes a value in the JSON form of readJSON as YAML block
+// collections, or, if compact, as a single line of YAML flow style,
+// which is JSON.  Strings are always double-quoted, so the output is
+// a subset of YAML that needs no knowledge of its implicit typing.
+func writeYAML(x interface{}, compact bool) error {
+	var buf bytes.Buffer
+	if compact {
+		data, err := marshalJSON(x)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	} else {
+		yaml(&buf, x, 0)
+	}
+	buf.WriteByte('\n')
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// yaml writes x, whose first line begins at the current position
+// and whose later lines are indented by indent spaces.
+func yaml(buf *bytes.Buffer, x interface{}, indent int) {
+	newline := "\n" + strings.Repeat(" ", indent)
+	switch x := x.(type) {
+	case object:
+		if len(x) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		for i, m := range x {
+			if i > 0 {
+				buf.WriteString(newline)
+			}
+			buf.WriteString(yamlKey(m.key) + ":")
+			if isBlock(m.value) {
+				buf.WriteString(newline + "  ")
+				yaml(buf, m.value, indent+2)
+			} else {
+				buf.WriteByte(' ')
+				yaml(buf, m.value, indent)
+			}
+		}
+	case []interface{}:
+		if len(x) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		for i, elem := range x {
+			if i > 0 {
+				buf.WriteString(newline)
+			}
+			buf.WriteString("- ")
+			yaml(buf, elem, indent+2)
+		}
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		fmt.Fprint(buf, x)
+	case json.Number:
+		buf.WriteString(string(x))
+	case string:
+		buf.WriteString(strconv.Quote(x))
+	}
+}
+
+// isBlock reports whether x is written as a non-empty block collection.
+func isBlock(x interface{}) bool {
+	switch x := x.(type) {
+	case object:
+		return len(x) > 0
+	case []interface{}:
+		return len(x) > 0
+	}
+	return false
+}
+
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// yamlKey returns key as a plain scalar if it cannot be mistaken
+// for a value of another type, or as a double-quoted string.
+func yamlKey(key string) string {
+	switch strings.ToLower(key) {
+	case "null", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return strconv.Quote(key)
+	}
+	if plainKey.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
@@ -0,0 +1,50 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+// Package example declares types whose S-expression methods
+// are generated by sexprgen.
+package example
+
+import (
+	"strings"
+	"time"
+)
+
+//go:generate go run go_example/ch12/sexprgen -type=Movie,Credit
+
+type Movie struct {
+	Title, Subtitle string
+	Year            int
+	Rating          float32 `sexpr:"rating,omitempty"`
+	Color           bool
+	Runtime         time.Duration
+	Released        time.Time
+	Format          Format
+	Actor           map[string]string
+	Oscars          []string
+	Scores          [3]int8
+	Sequel          *string
+	Director        *Credit
+	Cast            []Credit
+	Budget          uint64 `sexpr:",omitempty"`
+	Notes           interface{}
+	Cache           []byte `sexpr:"-"`
+	views           int
+}
+
+type Credit struct {
+	Name string
+	Role string `sexpr:",omitempty"`
+}
+
+// A Format is a film format, which is encoded in lower case.
+type Format string
+
+func (f Format) MarshalText() ([]byte, error) {
+	return []byte(strings.ToLower(string(f))), nil
+}
+
+func (f *Format) UnmarshalText(text []byte) error {
+	*f = Format(strings.ToUpper(string(text)))
+	return nil
+}
@@ -0,0 +1,253 @@
+// Code generated by "sexprgen -type=Movie,Credit"; DO NOT EDIT.
+
+package example
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"go_example/ch12/sexpr"
+)
+
+// MarshalSexpr encodes x as sexpr.Marshal would.
+func (x *Movie) MarshalSexpr() ([]byte, error) {
+	var data []byte
+	var err error
+	b := append(make([]byte, 0, 401), '(')
+	b = append(b, "(Title "...)
+	b = strconv.AppendQuote(b, string(x.Title))
+	b = append(b, ')')
+	b = append(b, " (Subtitle "...)
+	b = strconv.AppendQuote(b, string(x.Subtitle))
+	b = append(b, ')')
+	b = append(b, " (Year "...)
+	b = strconv.AppendInt(b, int64(x.Year), 10)
+	b = append(b, ')')
+	if x.Rating != 0 {
+		b = append(b, " (rating "...)
+		b, err = sexpr.AppendFloat(b, float64(x.Rating), 32)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, ')')
+	}
+	b = append(b, " (Color "...)
+	if x.Color {
+		b = append(b, 't')
+	} else {
+		b = append(b, "nil"...)
+	}
+	b = append(b, ')')
+	b = append(b, " (Runtime "...)
+	b = strconv.AppendInt(b, int64(x.Runtime), 10)
+	b = append(b, ')')
+	b = append(b, " (Released "...)
+	data, err = sexpr.Marshal(&x.Released)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, data...)
+	b = append(b, ')')
+	b = append(b, " (Format "...)
+	data, err = sexpr.Marshal(&x.Format)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, data...)
+	b = append(b, ')')
+	b = append(b, " (Actor "...)
+	data, err = sexpr.Marshal(&x.Actor)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, data...)
+	b = append(b, ')')
+	b = append(b, " (Oscars "...)
+	b = append(b, '(')
+	for i0 := range x.Oscars {
+		if i0 > 0 {
+			b = append(b, ' ')
+		}
+		b = strconv.AppendQuote(b, string(x.Oscars[i0]))
+	}
+	b = append(b, ')')
+	b = append(b, ')')
+	b = append(b, " (Scores "...)
+	b = append(b, '(')
+	for i0 := range x.Scores {
+		if i0 > 0 {
+			b = append(b, ' ')
+		}
+		b = strconv.AppendInt(b, int64(x.Scores[i0]), 10)
+	}
+	b = append(b, ')')
+	b = append(b, ')')
+	b = append(b, " (Sequel "...)
+	if x.Sequel == nil {
+		b = append(b, "nil"...)
+	} else {
+		b = strconv.AppendQuote(b, string(*x.Sequel))
+	}
+	b = append(b, ')')
+	b = append(b, " (Director "...)
+	if x.Director == nil {
+		b = append(b, "nil"...)
+	} else {
+		data, err = x.Director.MarshalSexpr()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, data...)
+	}
+	b = append(b, ')')
+	b = append(b, " (Cast "...)
+	b = append(b, '(')
+	for i0 := range x.Cast {
+		if i0 > 0 {
+			b = append(b, ' ')
+		}
+		data, err = x.Cast[i0].MarshalSexpr()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, data...)
+	}
+	b = append(b, ')')
+	b = append(b, ')')
+	if x.Budget != 0 {
+		b = append(b, " (Budget "...)
+		b = strconv.AppendUint(b, uint64(x.Budget), 10)
+		b = append(b, ')')
+	}
+	b = append(b, " (Notes "...)
+	data, err = sexpr.Marshal(&x.Notes)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, data...)
+	b = append(b, ')')
+	b = append(b, ')')
+	return b, nil
+}
+
+// DecodeSexpr decodes the next S-expression of dec into x
+// as dec.Decode would.
+func (x *Movie) DecodeSexpr(dec *sexpr.Decoder) error {
+	return dec.DecodeFields(func(name string) error {
+		switch name {
+		case "Title":
+			return dec.Decode(&x.Title)
+		case "Subtitle":
+			return dec.Decode(&x.Subtitle)
+		case "Year":
+			return dec.Decode(&x.Year)
+		case "rating":
+			return dec.Decode(&x.Rating)
+		case "Color":
+			return dec.Decode(&x.Color)
+		case "Runtime":
+			return dec.Decode(&x.Runtime)
+		case "Released":
+			return dec.Decode(&x.Released)
+		case "Format":
+			return dec.Decode(&x.Format)
+		case "Actor":
+			return dec.Decode(&x.Actor)
+		case "Oscars":
+			return dec.Decode(&x.Oscars)
+		case "Scores":
+			return dec.Decode(&x.Scores)
+		case "Sequel":
+			return dec.Decode(&x.Sequel)
+		case "Director":
+			return dec.Decode(&x.Director)
+		case "Cast":
+			return dec.Decode(&x.Cast)
+		case "Budget":
+			return dec.Decode(&x.Budget)
+		case "Notes":
+			return dec.Decode(&x.Notes)
+		}
+		switch {
+		case strings.EqualFold(name, "Title"):
+			return dec.Decode(&x.Title)
+		case strings.EqualFold(name, "Subtitle"):
+			return dec.Decode(&x.Subtitle)
+		case strings.EqualFold(name, "Year"):
+			return dec.Decode(&x.Year)
+		case strings.EqualFold(name, "rating"):
+			return dec.Decode(&x.Rating)
+		case strings.EqualFold(name, "Color"):
+			return dec.Decode(&x.Color)
+		case strings.EqualFold(name, "Runtime"):
+			return dec.Decode(&x.Runtime)
+		case strings.EqualFold(name, "Released"):
+			return dec.Decode(&x.Released)
+		case strings.EqualFold(name, "Format"):
+			return dec.Decode(&x.Format)
+		case strings.EqualFold(name, "Actor"):
+			return dec.Decode(&x.Actor)
+		case strings.EqualFold(name, "Oscars"):
+			return dec.Decode(&x.Oscars)
+		case strings.EqualFold(name, "Scores"):
+			return dec.Decode(&x.Scores)
+		case strings.EqualFold(name, "Sequel"):
+			return dec.Decode(&x.Sequel)
+		case strings.EqualFold(name, "Director"):
+			return dec.Decode(&x.Director)
+		case strings.EqualFold(name, "Cast"):
+			return dec.Decode(&x.Cast)
+		case strings.EqualFold(name, "Budget"):
+			return dec.Decode(&x.Budget)
+		case strings.EqualFold(name, "Notes"):
+			return dec.Decode(&x.Notes)
+		}
+		return dec.SkipField(x)
+	})
+}
+
+// UnmarshalSexpr decodes data into x as sexpr.Unmarshal would.
+func (x *Movie) UnmarshalSexpr(data []byte) error {
+	return x.DecodeSexpr(sexpr.NewDecoder(bytes.NewReader(data)))
+}
+
+// MarshalSexpr encodes x as sexpr.Marshal would.
+func (x *Credit) MarshalSexpr() ([]byte, error) {
+	b := append(make([]byte, 0, 48), '(')
+	b = append(b, "(Name "...)
+	b = strconv.AppendQuote(b, string(x.Name))
+	b = append(b, ')')
+	if len(x.Role) != 0 {
+		b = append(b, " (Role "...)
+		b = strconv.AppendQuote(b, string(x.Role))
+		b = append(b, ')')
+	}
+	b = append(b, ')')
+	return b, nil
+}
+
+// DecodeSexpr decodes the next S-expression of dec into x
+// as dec.Decode would.
+func (x *Credit) DecodeSexpr(dec *sexpr.Decoder) error {
+	return dec.DecodeFields(func(name string) error {
+		switch name {
+		case "Name":
+			return dec.Decode(&x.Name)
+		case "Role":
+			return dec.Decode(&x.Role)
+		}
+		switch {
+		case strings.EqualFold(name, "Name"):
+			return dec.Decode(&x.Name)
+		case strings.EqualFold(name, "Role"):
+			return dec.Decode(&x.Role)
+		}
+		return dec.SkipField(x)
+	})
+}
+
+// UnmarshalSexpr decodes data into x as sexpr.Unmarshal would.
+func (x *Credit) UnmarshalSexpr(data []byte) error {
+	return x.DecodeSexpr(sexpr.NewDecoder(bytes.NewReader(data)))
+}
@@ -0,0 +1,166 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package example
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go_example/ch12/sexpr"
+)
+
+var strangelove = Movie{
+	Title:    "Dr. Strangelove",
+	Subtitle: "How I Learned to Stop Worrying and Love the Bomb",
+	Year:     1964,
+	Rating:   8.4,
+	Runtime:  95 * time.Minute,
+	Released: time.Date(1964, 1, 29, 0, 0, 0, 0, time.UTC),
+	Format:   "35MM",
+	Actor: map[string]string{
+		"Dr. Strangelove":     "Peter Sellers",
+		"Gen. Buck Turgidson": "George C. Scott",
+	},
+	Oscars:   []string{"Best Actor (Nomin.)", "Best Picture (Nomin.)"},
+	Scores:   [3]int8{9, -1, 7},
+	Director: &Credit{Name: "Stanley Kubrick"},
+	Cast:     []Credit{{"Peter Sellers", "Mandrake"}, {"Slim Pickens", ""}},
+	Notes:    sexpr.List{sexpr.Float(1.5), sexpr.String("two")}, // an interface{} decodes as a Value
+}
+
+// TestMarshal checks that the generated methods encode as the
+// reflective encoder does.  Marshal of a Movie, which is not
+// addressable, does not call the pointer methods.
+func TestMarshal(t *testing.T) {
+	sequel := "Son of Strangelove"
+	for _, m := range []Movie{
+		strangelove,
+		{},
+		{Budget: 1 << 63, Sequel: &sequel, Color: true},
+	} {
+		want, err := sexpr.Marshal(m)
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", m, err)
+		}
+		got, err := sexpr.Marshal(&m)
+		if err != nil {
+			t.Fatalf("Marshal(&%v): %v", m, err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("generated encoding:\n%s\nwant:\n%s", got, want)
+		}
+	}
+
+	// Slices of structs, for which the methods are called.
+	want, _ := sexpr.Marshal([]Credit{{"a", "b"}})
+	if string(want) != `(((Name "a") (Role "b")))` {
+		t.Errorf("Marshal([]Credit) = %s", want)
+	}
+}
+
+// TestMarshalIndent checks that the encoding of the generated
+// methods is laid out as that of the reflective encoder is.
+func TestMarshalIndent(t *testing.T) {
+	want, err := sexpr.MarshalIndent(strangelove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := sexpr.MarshalIndent(&strangelove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("MarshalIndent(&strangelove) =\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(string(want), "\n") {
+		t.Errorf("MarshalIndent(strangelove) = %s, want several lines", want)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data, err := sexpr.Marshal(&strangelove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Movie
+	if err := sexpr.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", data, err)
+	}
+	if !reflect.DeepEqual(got, strangelove) {
+		t.Errorf("Unmarshal(%s) = %+v, want %+v", data, got, strangelove)
+	}
+
+	// Names are matched without regard to case, unknown
+	// fields are skipped, and dotted pairs are accepted.
+	got = Movie{}
+	err = sexpr.Unmarshal([]byte(`((title . "T") (RATING 1.5) (Unknown (1 2)) (cast (((name "n")))))`), &got)
+	want := Movie{Title: "T", Rating: 1.5, Cast: []Credit{{Name: "n"}}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal = %+v, %v, want %+v", got, err, want)
+	}
+
+	if err := sexpr.Unmarshal([]byte(`((Year "1964"))`), &got); err == nil {
+		t.Errorf("Unmarshal of string into Year succeeded")
+	}
+}
+
+// TestDecodeErrors checks that the generated methods honour the
+// options of the enclosing Decoder and report errors at their
+// positions in its input, as the reflective decoder does.
+func TestDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"((Title \"T\")\n (Director ((Name \"n\") (Bogus 1))))",
+			`sexpr: 2:25: cannot unmarshal unknown field "Bogus" into field Director.Bogus of Go value of type example.Credit`},
+		{"((Cast (((Name \"n\")) ((Role 2)))))",
+			`sexpr: 1:29: cannot unmarshal number 2 into field Cast.Role of Go value of type string`},
+		{"((Cast (((Name \"n\")",
+			`sexpr: 1:20: unexpected end of file`},
+	} {
+		dec := sexpr.NewDecoder(strings.NewReader(test.input))
+		dec.DisallowUnknownFields()
+		var m Movie
+		err := dec.Decode(&m)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("Decode(%s) = %v, want %s", test.input, err, test.want)
+		}
+	}
+
+	var typeErr *sexpr.UnmarshalTypeError
+	err := sexpr.Unmarshal([]byte(`((Director ((Name 1))))`), new(Movie))
+	if !errors.As(err, &typeErr) || typeErr.Field != "Director.Name" {
+		t.Errorf("Unmarshal error %v (%T), want *UnmarshalTypeError for Director.Name", err, err)
+	}
+}
+
+// The benchmarks encode a slice of credits, whose elements are
+// addressable, and an array of them, whose elements are not.
+
+var credits = func() (a [1000]Credit) {
+	for i := range a {
+		a[i] = Credit{"Peter Sellers", "Mandrake"}
+	}
+	return
+}()
+
+func BenchmarkMarshalGenerated(b *testing.B) {
+	s := credits[:]
+	for i := 0; i < b.N; i++ {
+		if _, err := sexpr.Marshal(s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalReflect(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := sexpr.Marshal(credits); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
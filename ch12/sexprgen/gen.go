@@ -0,0 +1,403 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const sexprPath = "go_example/ch12/sexpr"
+
+// generate returns the source of a file declaring the methods of the
+// named types of the package in dir, ignoring any existing file out.
+func generate(dir, out string, names []string) ([]byte, error) {
+	pkg, err := load(dir, out)
+	if err != nil {
+		return nil, err
+	}
+	g := &generator{types: make(map[*types.Named]bool), imports: make(map[string]bool)}
+	var named []*types.Named
+	for _, name := range names {
+		obj, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("no type %s in package %s", name, pkg.Name())
+		}
+		t, ok := obj.Type().(*types.Named)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a named type", name)
+		}
+		if _, ok := t.Underlying().(*types.Struct); !ok {
+			return nil, fmt.Errorf("%s is not a struct type", name)
+		}
+		g.types[t] = true
+		named = append(named, t)
+	}
+	for _, t := range named {
+		if err := g.marshal(t); err != nil {
+			return nil, err
+		}
+		g.unmarshal(t)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"sexprgen -type=%s\"; DO NOT EDIT.\n\n", strings.Join(names, ","))
+	fmt.Fprintf(&buf, "package %s\n\n", pkg.Name())
+	var imports []string
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		// The standard packages precede package sexpr.
+		if (imports[i] == sexprPath) != (imports[j] == sexprPath) {
+			return imports[j] == sexprPath
+		}
+		return imports[i] < imports[j]
+	})
+	fmt.Fprintf(&buf, "import (\n")
+	for _, path := range imports {
+		if path == sexprPath {
+			buf.WriteByte('\n') // after the standard packages
+		}
+		fmt.Fprintf(&buf, "%q\n", path)
+	}
+	fmt.Fprintf(&buf, ")\n")
+	buf.Write(g.buf.Bytes())
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("internal error: invalid Go generated: %v\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// load type-checks the package in dir, ignoring the file out.
+// Errors in the package are ignored, since they may be caused by
+// an out-of-date generated file.
+func load(dir, out string) (*types.Package, error) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		if name == out {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, fset, files, nil)
+	return pkg, nil
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	types   map[*types.Named]bool // the types whose methods are generated
+	imports map[string]bool
+
+	// Variables used by the statements of a MarshalSexpr method.
+	needData, needErr bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// A field describes the encoding of a struct field,
+// as determined by package sexpr.
+type field struct {
+	name      string // name in the S-expression
+	goName    string
+	typ       types.Type
+	tagged    bool
+	omitEmpty bool
+}
+
+// fields returns the encoded fields of struct type t, in order.
+func fields(t *types.Named) ([]field, error) {
+	st := t.Underlying().(*types.Struct)
+	var all []field
+	count := make(map[string]int)
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+		if v.Embedded() {
+			return nil, fmt.Errorf("%s: embedded field %s is not supported", t.Obj().Name(), v.Name())
+		}
+		tag := reflect.StructTag(st.Tag(i)).Get("sexpr")
+		if !v.Exported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		f := field{name, v.Name(), v.Type(), name != "", opts == "omitempty"}
+		if f.name == "" {
+			f.name = v.Name()
+		}
+		all = append(all, f)
+		count[f.name]++
+	}
+
+	// As in package sexpr, of several fields with the same name,
+	// only a sole tagged one is encoded.
+	tagged := make(map[string]int)
+	for _, f := range all {
+		if f.tagged {
+			tagged[f.name]++
+		}
+	}
+	var result []field
+	for _, f := range all {
+		if count[f.name] == 1 || f.tagged && tagged[f.name] == 1 {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+// marshal generates the MarshalSexpr method of t.
+func (g *generator) marshal(t *types.Named) error {
+	fields, err := fields(t)
+	if err != nil {
+		return err
+	}
+	var body generator
+	body.types, body.imports = g.types, g.imports
+	optional := true // whether all previous fields may be omitted
+	for i, f := range fields {
+		x := "x." + f.goName
+		cond := ""
+		if f.omitEmpty {
+			cond = nonEmpty(x, f.typ)
+		}
+		if cond != "" {
+			body.printf("if %s {\n", cond)
+		}
+		switch {
+		case i == 0:
+			body.printf("b = append(b, %q...)\n", "("+f.name+" ")
+		case optional:
+			body.printf("if len(b) > 1 {\nb = append(b, ' ')\n}\n")
+			body.printf("b = append(b, %q...)\n", "("+f.name+" ")
+		default:
+			body.printf("b = append(b, %q...)\n", " ("+f.name+" ")
+		}
+		body.encode(x, f.typ, 0)
+		body.printf("b = append(b, ')')\n")
+		if cond != "" {
+			body.printf("}\n")
+		} else {
+			optional = false
+		}
+	}
+
+	name := t.Obj().Name()
+	g.printf("\n// MarshalSexpr encodes x as sexpr.Marshal would.\n")
+	g.printf("func (x *%s) MarshalSexpr() ([]byte, error) {\n", name)
+	if body.needData {
+		g.printf("var data []byte\n")
+	}
+	if body.needErr {
+		g.printf("var err error\n")
+	}
+	size := 2 // an estimate of the length of the encoding
+	for _, f := range fields {
+		size += len(f.name) + 3 + 16
+	}
+	g.printf("b := append(make([]byte, 0, %d), '(')\n", size)
+	g.buf.Write(body.buf.Bytes())
+	g.printf("b = append(b, ')')\n")
+	g.printf("return b, nil\n")
+	g.printf("}\n")
+	return nil
+}
+
+// unmarshal generates the DecodeSexpr and UnmarshalSexpr methods of t.
+func (g *generator) unmarshal(t *types.Named) {
+	fields, _ := fields(t) // error reported by marshal
+	g.imports["bytes"] = true
+	g.imports[sexprPath] = true
+	name := t.Obj().Name()
+	g.printf("\n// DecodeSexpr decodes the next S-expression of dec into x\n")
+	g.printf("// as dec.Decode would.\n")
+	g.printf("func (x *%s) DecodeSexpr(dec *sexpr.Decoder) error {\n", name)
+	g.printf("return dec.DecodeFields(func(name string) error {\n")
+	if len(fields) > 0 {
+		g.imports["strings"] = true
+		g.printf("switch name {\n")
+		for _, f := range fields {
+			g.printf("case %q:\nreturn dec.Decode(&x.%s)\n", f.name, f.goName)
+		}
+		g.printf("}\n")
+		g.printf("switch {\n")
+		for _, f := range fields {
+			g.printf("case strings.EqualFold(name, %q):\nreturn dec.Decode(&x.%s)\n", f.name, f.goName)
+		}
+		g.printf("}\n")
+	}
+	g.printf("return dec.SkipField(x)\n")
+	g.printf("})\n")
+	g.printf("}\n")
+
+	g.printf("\n// UnmarshalSexpr decodes data into x as sexpr.Unmarshal would.\n")
+	g.printf("func (x *%s) UnmarshalSexpr(data []byte) error {\n", name)
+	g.printf("return x.DecodeSexpr(sexpr.NewDecoder(bytes.NewReader(data)))\n")
+	g.printf("}\n")
+}
+
+// encode emits statements that append to b the encoding of the
+// addressable expression x of type t.
+func (g *generator) encode(x string, t types.Type, depth int) {
+	switch {
+	case g.isGenerated(t):
+		if strings.HasPrefix(x, "*") {
+			x = x[1:] // call the method on the pointer
+		}
+		g.needData = true
+		g.printf("data, err = %s.MarshalSexpr()\n", x)
+		g.check()
+		g.printf("b = append(b, data...)\n")
+		return
+
+	case !g.direct(t):
+		g.imports[sexprPath] = true
+		g.needData = true
+		g.printf("data, err = sexpr.Marshal(%s)\n", addr(x))
+		g.check()
+		g.printf("b = append(b, data...)\n")
+		return
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		info := u.Info()
+		switch {
+		case info&types.IsString != 0:
+			g.imports["strconv"] = true
+			g.printf("b = strconv.AppendQuote(b, string(%s))\n", x)
+		case info&types.IsUnsigned != 0:
+			g.imports["strconv"] = true
+			g.printf("b = strconv.AppendUint(b, uint64(%s), 10)\n", x)
+		case info&types.IsInteger != 0:
+			g.imports["strconv"] = true
+			g.printf("b = strconv.AppendInt(b, int64(%s), 10)\n", x)
+		case info&types.IsBoolean != 0:
+			g.printf("if %s {\nb = append(b, 't')\n} else {\nb = append(b, \"nil\"...)\n}\n", x)
+		case info&types.IsFloat != 0:
+			bits := 64
+			if u.Kind() == types.Float32 {
+				bits = 32
+			}
+			g.imports[sexprPath] = true
+			g.printf("b, err = sexpr.AppendFloat(b, float64(%s), %d)\n", x, bits)
+			g.check()
+		}
+
+	case *types.Pointer:
+		g.printf("if %s == nil {\nb = append(b, \"nil\"...)\n} else {\n", x)
+		g.encode("*"+x, u.Elem(), depth)
+		g.printf("}\n")
+
+	case *types.Slice, *types.Array:
+		elem := u.(interface{ Elem() types.Type }).Elem()
+		i := fmt.Sprintf("i%d", depth)
+		if strings.HasPrefix(x, "*") {
+			x = "(" + x + ")"
+		}
+		g.printf("b = append(b, '(')\n")
+		g.printf("for %s := range %s {\n", i, x)
+		g.printf("if %s > 0 {\nb = append(b, ' ')\n}\n", i)
+		g.encode(x+"["+i+"]", elem, depth+1)
+		g.printf("}\n")
+		g.printf("b = append(b, ')')\n")
+	}
+}
+
+// check emits a check of err.
+func (g *generator) check() {
+	g.needErr = true
+	g.printf("if err != nil {\nreturn nil, err\n}\n")
+}
+
+// isGenerated reports whether t is one of the types
+// whose methods are being generated.
+func (g *generator) isGenerated(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && g.types[named]
+}
+
+// direct reports whether values of type t can be encoded
+// by the code emitted by encode, without calling sexpr.Marshal.
+func (g *generator) direct(t types.Type) bool {
+	if g.isGenerated(t) {
+		return true
+	}
+	if hasMarshaler(t) {
+		return false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return u.Info()&(types.IsString|types.IsInteger|types.IsBoolean|types.IsFloat) != 0
+	case *types.Pointer:
+		return g.direct(u.Elem())
+	case *types.Slice:
+		return g.direct(u.Elem())
+	case *types.Array:
+		return g.direct(u.Elem())
+	}
+	return false
+}
+
+// hasMarshaler reports whether t or *t has a method
+// that package sexpr calls to encode a value.
+func hasMarshaler(t types.Type) bool {
+	mset := types.NewMethodSet(types.NewPointer(t))
+	return mset.Lookup(nil, "MarshalSexpr") != nil || mset.Lookup(nil, "MarshalText") != nil
+}
+
+// nonEmpty returns a condition that x, of type t, is not an empty
+// value that omitempty omits, or "" if it is never empty.
+func nonEmpty(x string, t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch info := u.Info(); {
+		case info&types.IsString != 0:
+			return "len(" + x + ") != 0"
+		case info&types.IsBoolean != 0:
+			return x
+		case info&types.IsNumeric != 0:
+			return x + " != 0"
+		}
+	case *types.Slice, *types.Map, *types.Array:
+		return "len(" + x + ") != 0"
+	case *types.Pointer, *types.Interface:
+		return x + " != nil"
+	}
+	return ""
+}
+
+// addr returns an expression for the address of x.
+func addr(x string) string {
+	if strings.HasPrefix(x, "*") {
+		return x[1:]
+	}
+	return "&" + x
+}
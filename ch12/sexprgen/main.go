@@ -0,0 +1,69 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+// Sexprgen generates MarshalSexpr, DecodeSexpr and UnmarshalSexpr
+// methods for named struct types, so that package sexpr can encode and
+// decode them without examining them by reflection.
+//
+// Usage:
+//
+//	sexprgen -type=T[,U...] [-output file] [dir]
+//
+// Sexprgen is typically run by a go:generate directive in the package
+// that declares the types:
+//
+//	//go:generate go run go_example/ch12/sexprgen -type=Movie,Credit
+//
+// It writes the methods to t_sexpr.go, where t is the lower-cased name
+// of the first type, in the package directory.  The methods have
+// pointer receivers, and MarshalSexpr produces exactly the output of
+// sexpr.Marshal for the same addressable value.  Fields of basic types,
+// of the other listed types, and slices, arrays and pointers of these
+// are encoded directly; other fields are encoded by sexpr.Marshal.
+// DecodeSexpr and UnmarshalSexpr accept the same input as
+// sexpr.Unmarshal; the package sexpr decoder calls DecodeSexpr, which
+// reads from the enclosing Decoder and so honours its options.
+//
+// The types must not have embedded fields.  Since the generated methods
+// do not track the pointers they follow, values of the types must not
+// contain cycles, and their shared pointers are not labeled.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct type names; required")
+	output    = flag.String("output", "", "output file name; default dir/<type>_sexpr.go")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("sexprgen: ")
+	flag.Parse()
+	if *typeNames == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+	names := strings.Split(*typeNames, ",")
+	file := *output
+	if file == "" {
+		file = filepath.Join(dir, strings.ToLower(names[0])+"_sexpr.go")
+	}
+	src, err := generate(dir, filepath.Base(file), names)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(file, src, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
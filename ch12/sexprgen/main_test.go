@@ -0,0 +1,38 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGenerate checks that the generated file in package example,
+// which is tested there, is up to date.
+func TestGenerate(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("example", "movie_sexpr.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := generate("example", "movie_sexpr.go", []string{"Movie", "Credit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("example/movie_sexpr.go is out of date; run go generate ./ch12/sexprgen/example")
+	}
+
+	for _, test := range []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"Format"}, "Format is not a struct type"},
+		{[]string{"Movie", "Film"}, "no type Film in package example"},
+	} {
+		if _, err := generate("example", "movie_sexpr.go", test.names); err == nil || err.Error() != test.want {
+			t.Errorf("generate(%v) = %v, want %s", test.names, err, test.want)
+		}
+	}
+}
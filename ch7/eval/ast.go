@@ -3,6 +3,8 @@
 
 package eval
 
+import "fmt"
+
 // An Expr is an arithmetic expression.
 type Expr interface {
 	// Eval returns the value of this Expr in the environment env.
@@ -21,20 +23,335 @@ type literal float64
 
 // A unary represents a unary operator expression, e.g., -x.
 type unary struct {
-	op rune // one of '+', '-'
-	x  Expr
+	op   string // one of "+", "-", "!"
+	x    Expr
+	span Span
 }
 
 // A binary represents a binary operator expression, e.g., x+y.
 type binary struct {
-	op   rune // one of '+', '-', '*', '/'
+	op   string // one of "+", "-", "*", "/", "<", "<=", ">", ">=", "==", "!=", "&&", "||"
 	x, y Expr
+	span Span
+}
+
+// A conditional represents a ternary conditional expression, e.g., x > 0 ? x : -x.
+type conditional struct {
+	cond, x, y Expr
+	span       Span
 }
 
 // A call represents a function call expression, e.g., sin(x).
 type call struct {
-	fn   string // one of "pow", "sin", "sqrt"
+	fn   string // the name of a function in Funcs
+	args []Expr
+	span Span
+}
+
+// A let binds a variable within an expression,
+// e.g., let r = sqrt(x*x + y*y) in sin(r)/r.
+type let struct {
+	v       Var
+	x, body Expr
+	span    Span
+}
+
+// A block is a sequence of function definitions followed by
+// an expression that may call them, e.g., f(a) = a*a; f(x) + 1.
+type block struct {
+	defs []*Def
+	body Expr
+	span Span
+}
+
+// An apply represents a call to a function defined in a block, e.g., f(x).
+type apply struct {
+	def  *Def
 	args []Expr
+	span Span
+}
+
+//!-ast
+
+// A varRef is an occurrence of a variable in the input to Parse.
+// It evaluates and checks as the Var it embeds, and records its span.
+type varRef struct {
+	Var
+	span Span
+}
+
+// A Def is a named function definition, e.g., f(a, b) = a*a + b.
+// The body of a function may refer only to its parameters.
+type Def struct {
+	Name   string
+	Params []Var
+	Body   Expr
+}
+
+// A Pos is a position in the input to Parse.
+type Pos struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number, starting at 1 (character count per line)
+}
+
+func (p Pos) String() string { return fmt.Sprintf("%d:%d", p.Line, p.Column) }
+
+// A Span is the range of input [Start, End) from which an Expr was parsed.
+// Each node produced by Parse, other than a literal, records its span,
+// as does each occurrence of a variable; the span of a node constructed
+// in any other way is zero.
+type Span struct {
+	Start, End Pos
+}
+
+// spanOf returns the span of e, which is zero for a literal
+// and for a node that was not produced by Parse.
+func spanOf(e Expr) Span {
+	switch e := e.(type) {
+	case varRef:
+		return e.span
+	case unary:
+		return e.span
+	case binary:
+		return e.span
+	case conditional:
+		return e.span
+	case call:
+		return e.span
+	case let:
+		return e.span
+	case block:
+		return e.span
+	case apply:
+		return e.span
+	}
+	return Span{}
+}
+
+// inspect traverses e in depth-first order, calling f for each node.
+// If f returns false, inspect skips the children of that node.
+// The bodies of defined functions are visited only in their block.
+func inspect(e Expr, f func(Expr) bool) {
+	if !f(e) {
+		return
+	}
+	switch e := e.(type) {
+	case unary:
+		inspect(e.x, f)
+	case binary:
+		inspect(e.x, f)
+		inspect(e.y, f)
+	case conditional:
+		inspect(e.cond, f)
+		inspect(e.x, f)
+		inspect(e.y, f)
+	case call:
+		for _, arg := range e.args {
+			inspect(arg, f)
+		}
+	case let:
+		inspect(e.x, f)
+		inspect(e.body, f)
+	case block:
+		for _, d := range e.defs {
+			inspect(d.Body, f)
+		}
+		inspect(e.body, f)
+	case apply:
+		for _, arg := range e.args {
+			inspect(arg, f)
+		}
+	}
+}
+
+// reaches reports whether evaluating the function from
+// may call the function to, possibly indirectly.
+func reaches(from, to *Def) bool {
+	seen := make(map[*Def]bool)
+	var visit func(d *Def) bool
+	visit = func(d *Def) bool {
+		if d == to {
+			return true
+		}
+		if seen[d] || d.Body == nil {
+			return false
+		}
+		seen[d] = true
+		found := false
+		inspect(d.Body, func(e Expr) bool {
+			if a, ok := e.(apply); ok && visit(a.def) {
+				found = true
+			}
+			return !found
+		})
+		return found
+	}
+	return visit(from)
+}
+
+// callsFunc reports whether evaluating the function named from in
+// Funcs may call the function named to, possibly indirectly through
+// the definitions of other functions made by Def.Func.
+func callsFunc(from, to string) bool {
+	seen := make(map[*Def]bool)
+	var visit func(e Expr) bool
+	visit = func(e Expr) bool {
+		found := false
+		inspect(e, func(e Expr) bool {
+			var d *Def
+			switch e := e.(type) {
+			case call:
+				if e.fn == to {
+					found = true
+				} else if f := Funcs.lookup(e.fn); f != nil {
+					d = f.def
+				}
+			case apply:
+				d = e.def
+			}
+			if d != nil && !seen[d] {
+				seen[d] = true
+				found = visit(d.Body)
+			}
+			return !found
+		})
+		return found
+	}
+	if f := Funcs.lookup(from); f != nil && f.def != nil {
+		return visit(f.def.Body)
+	}
+	return false
+}
+
+// expand returns a copy of e without position information in which
+// let-bound variables are replaced by their values, calls to defined
+// functions are replaced by their bodies, and every other variable v
+// is replaced by env[v], if present.  The result refers only to
+// variables that are free in e.
+func expand(e Expr, env map[Var]Expr) Expr {
+	switch e := e.(type) {
+	case Var:
+		if x, ok := env[e]; ok {
+			return x
+		}
+		return e
+	case varRef:
+		return expand(e.Var, env)
+	case literal:
+		return e
+	case unary:
+		return unary{op: e.op, x: expand(e.x, env)}
+	case binary:
+		return binary{op: e.op, x: expand(e.x, env), y: expand(e.y, env)}
+	case conditional:
+		return conditional{cond: expand(e.cond, env), x: expand(e.x, env), y: expand(e.y, env)}
+	case call:
+		return call{fn: e.fn, args: expandAll(e.args, env)}
+	case let:
+		return expand(e.body, bind(env, e.v, expand(e.x, env)))
+	case block:
+		return expand(e.body, env)
+	case apply:
+		// The body of a function refers only to its parameters.
+		params := make(map[Var]Expr)
+		for i, arg := range expandAll(e.args, env) {
+			params[e.def.Params[i]] = arg
+		}
+		return expand(e.def.Body, params)
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
+
+func expandAll(exprs []Expr, env map[Var]Expr) []Expr {
+	result := make([]Expr, len(exprs))
+	for i, e := range exprs {
+		result[i] = expand(e, env)
+	}
+	return result
 }
 
-//!-ast
\ No newline at end of file
+// bind returns a copy of env in which v is bound to x.
+func bind(env map[Var]Expr, v Var, x Expr) map[Var]Expr {
+	result := map[Var]Expr{v: x}
+	for k, y := range env {
+		if k != v {
+			result[k] = y
+		}
+	}
+	return result
+}
+
+// maxSize is the largest number of nodes that an expression may have
+// once calls to defined functions are inlined.  As each definition may
+// call the previous one several times, the size of the inlined form
+// may be exponential in the size of the input.
+const maxSize = 1 << 20
+
+// checkSize reports an error if e would have more than maxSize nodes
+// once calls to defined functions are inlined.  If substitute is
+// true, arguments and let-bound values are substituted for each
+// occurrence of a variable, as by expand; otherwise each is counted
+// once, as by Compile and EvalBatch, which hold them in temporaries.
+func checkSize(e Expr, substitute bool) error {
+	steps := 0
+	var size func(e Expr, env map[Var]int) int
+	size = func(e Expr, env map[Var]int) int {
+		// Give up rather than walk an exponentially large tree.
+		if steps++; steps > maxSize {
+			return maxSize + 1
+		}
+		n := 1
+		switch e := e.(type) {
+		case Var:
+			if x, ok := env[e]; ok {
+				n = x
+			}
+		case varRef:
+			return size(e.Var, env)
+		case unary:
+			n += size(e.x, env)
+		case binary:
+			n += size(e.x, env) + size(e.y, env)
+		case conditional:
+			n += size(e.cond, env) + size(e.x, env) + size(e.y, env)
+		case call:
+			for _, arg := range e.args {
+				n += size(arg, env)
+			}
+		case let:
+			x := size(e.x, env)
+			if !substitute {
+				return n + x + size(e.body, env)
+			}
+			inner := map[Var]int{e.v: x}
+			for v, n := range env {
+				if v != e.v {
+					inner[v] = n
+				}
+			}
+			n = size(e.body, inner)
+		case block:
+			n = size(e.body, env)
+		case apply:
+			params := make(map[Var]int)
+			for i, arg := range e.args {
+				x := size(arg, env)
+				if substitute {
+					params[e.def.Params[i]] = x
+				} else {
+					n += x
+				}
+			}
+			n += size(e.def.Body, params)
+		}
+		if n > maxSize {
+			n = maxSize + 1 // avoid overflow
+		}
+		return n
+	}
+	if size(e, nil) > maxSize {
+		return fmt.Errorf("expression too large: more than %d nodes after expanding calls", maxSize)
+	}
+	return nil
+}
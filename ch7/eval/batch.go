@@ -0,0 +1,269 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// batchSize is the number of rows evaluated together.  It is small
+// enough that the intermediate columns of an expression stay in cache.
+const batchSize = 1024
+
+// EvalBatch evaluates e once for each row of the table cols, in which
+// each variable names a column of equal length, and returns the
+// results in row order.  Variables without a column are zero.
+//
+// Rather than walking the tree once per row, EvalBatch evaluates each
+// node over a batch of rows at a time.  If workers > 1, batches are
+// shared among that many goroutines; if workers is 0, GOMAXPROCS
+// goroutines are used.  As the body of a defined function is
+// evaluated anew at each call, EvalBatch reports an error if e
+// would be too large once calls are inlined.
+func EvalBatch(e Expr, cols map[Var][]float64, workers int) ([]float64, error) {
+	if err := e.Check(map[Var]bool{}); err != nil {
+		return nil, err
+	}
+	if err := checkSize(e, false); err != nil {
+		return nil, err
+	}
+	n := -1
+	for v, col := range cols {
+		if n >= 0 && len(col) != n {
+			return nil, fmt.Errorf("column %s has %d rows, want %d", v, len(col), n)
+		}
+		n = len(col)
+	}
+	if n < 0 {
+		n = 0
+	}
+	result := make([]float64, n)
+
+	batches := make(chan int)
+	go func() {
+		for lo := 0; lo < n; lo += batchSize {
+			batches <- lo
+		}
+		close(batches)
+	}()
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := batch{cols: make(map[Var][]float64, len(cols))}
+			for lo := range batches {
+				hi := lo + batchSize
+				if hi > n {
+					hi = n
+				}
+				b.n = hi - lo
+				for v, col := range cols {
+					b.cols[v] = col[lo:hi]
+				}
+				z := b.eval(e)
+				copy(result[lo:hi], z)
+				b.release(z)
+			}
+		}()
+	}
+	wg.Wait()
+	return result, nil
+}
+
+// A batch holds a range of rows of each column.
+type batch struct {
+	n    int // number of rows
+	cols map[Var][]float64
+	free [][]float64 // columns available for reuse
+}
+
+// alloc returns a column of length b.n with unspecified contents.
+func (b *batch) alloc() []float64 {
+	if k := len(b.free); k > 0 {
+		col := b.free[k-1]
+		b.free = b.free[:k-1]
+		return col[:b.n]
+	}
+	return make([]float64, b.n, batchSize)
+}
+
+// release makes columns returned by alloc available for reuse.
+func (b *batch) release(cols ...[]float64) {
+	b.free = append(b.free, cols...)
+}
+
+// eval returns a new column holding the value of e for each row
+// of the batch.  The caller may overwrite or release the result.
+func (b *batch) eval(e Expr) []float64 {
+	switch e := e.(type) {
+	case Var:
+		z := b.alloc()
+		if col, ok := b.cols[e]; ok {
+			copy(z, col)
+		} else {
+			for i := range z {
+				z[i] = 0
+			}
+		}
+		return z
+	case varRef:
+		return b.eval(e.Var)
+
+	case literal:
+		z := b.alloc()
+		for i := range z {
+			z[i] = float64(e)
+		}
+		return z
+
+	case unary:
+		z := b.eval(e.x)
+		switch e.op {
+		case "+":
+		case "-":
+			for i := range z {
+				z[i] = -z[i]
+			}
+		case "!":
+			for i := range z {
+				z[i] = truth(z[i] == 0)
+			}
+		default:
+			panic(fmt.Sprintf("unsupported unary operator: %q", e.op))
+		}
+		return z
+
+	case binary:
+		z, y := b.eval(e.x), b.eval(e.y)
+		switch e.op {
+		case "+":
+			for i := range z {
+				z[i] += y[i]
+			}
+		case "-":
+			for i := range z {
+				z[i] -= y[i]
+			}
+		case "*":
+			for i := range z {
+				z[i] *= y[i]
+			}
+		case "/":
+			for i := range z {
+				z[i] /= y[i]
+			}
+		case "<":
+			for i := range z {
+				z[i] = truth(z[i] < y[i])
+			}
+		case "<=":
+			for i := range z {
+				z[i] = truth(z[i] <= y[i])
+			}
+		case ">":
+			for i := range z {
+				z[i] = truth(z[i] > y[i])
+			}
+		case ">=":
+			for i := range z {
+				z[i] = truth(z[i] >= y[i])
+			}
+		case "==":
+			for i := range z {
+				z[i] = truth(z[i] == y[i])
+			}
+		case "!=":
+			for i := range z {
+				z[i] = truth(z[i] != y[i])
+			}
+		case "&&":
+			for i := range z {
+				z[i] = truth(z[i] != 0 && y[i] != 0)
+			}
+		case "||":
+			for i := range z {
+				z[i] = truth(z[i] != 0 || y[i] != 0)
+			}
+		default:
+			panic(fmt.Sprintf("unsupported binary operator: %q", e.op))
+		}
+		b.release(y)
+		return z
+
+	case conditional:
+		// Both branches are evaluated for every row;
+		// expressions have no side effects.
+		z, x, y := b.eval(e.cond), b.eval(e.x), b.eval(e.y)
+		for i := range z {
+			if z[i] != 0 {
+				z[i] = x[i]
+			} else {
+				z[i] = y[i]
+			}
+		}
+		b.release(x, y)
+		return z
+
+	case call:
+		f := Funcs.lookup(e.fn)
+		if f == nil {
+			panic(fmt.Sprintf("unsupported function call: %s", e.fn))
+		}
+		args := make([][]float64, len(e.args))
+		for i, arg := range e.args {
+			args[i] = b.eval(arg)
+		}
+		z := b.alloc()
+		row := make([]float64, len(args))
+		for i := range z {
+			for j, arg := range args {
+				row[j] = arg[i]
+			}
+			z[i] = f.Impl(row)
+		}
+		b.release(args...)
+		return z
+
+	case let:
+		x := b.eval(e.x)
+		saved, hidden := b.cols[e.v]
+		b.cols[e.v] = x
+		z := b.eval(e.body)
+		if hidden {
+			b.cols[e.v] = saved
+		} else {
+			delete(b.cols, e.v)
+		}
+		b.release(x)
+		return z
+
+	case block:
+		return b.eval(e.body)
+
+	case apply:
+		// The body of a function refers only to its parameters.
+		args := make([][]float64, len(e.args))
+		params := make(map[Var][]float64, len(e.args))
+		for i, arg := range e.args {
+			args[i] = b.eval(arg)
+			params[e.def.Params[i]] = args[i]
+		}
+		outer := b.cols
+		b.cols = params
+		z := b.eval(e.def.Body)
+		b.cols = outer
+		b.release(args...)
+		return z
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
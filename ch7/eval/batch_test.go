@@ -0,0 +1,126 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+// testColumns returns a table of n rows in columns x and y.
+func testColumns(n int) map[Var][]float64 {
+	x, y := make([]float64, n), make([]float64, n)
+	for i := range x {
+		x[i] = float64(i%100)/50 - 1
+		y[i] = float64(i%37) / 10
+	}
+	return map[Var][]float64{"x": x, "y": y}
+}
+
+func TestEvalBatch(t *testing.T) {
+	const n = 2500 // not a multiple of batchSize
+	cols := testColumns(n)
+	for _, input := range []string{
+		benchExpr,
+		"x",
+		"-x + !y",
+		"x > 0 && y < 1 || x == y",
+		"max(x, y, 0.5) - hypot(x, y)",
+		"let r = sqrt(x*x + y*y) in let x = r * 2 in x / r + y",
+		"f(a, b) = a * b; g(c) = f(c, c) + c; g(x) - f(y, 2)",
+		"f(a) = a; f(x) + f(1)",
+		"z + 1",
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", input, err)
+			continue
+		}
+		for _, workers := range []int{1, 3, 0} {
+			got, err := EvalBatch(expr, cols, workers)
+			if err != nil {
+				t.Errorf("EvalBatch(%s): %v", input, err)
+				break
+			}
+			if len(got) != n {
+				t.Errorf("EvalBatch(%s) returned %d rows, want %d", input, len(got), n)
+				break
+			}
+			for i := range got {
+				want := expr.Eval(Env{"x": cols["x"][i], "y": cols["y"][i]})
+				if got[i] != want && !(math.IsNaN(got[i]) && math.IsNaN(want)) {
+					t.Errorf("EvalBatch(%s, workers=%d)[%d] = %g, want %g",
+						input, workers, i, got[i], want)
+					break
+				}
+			}
+		}
+	}
+
+	// The input columns must be unchanged.
+	want := testColumns(n)
+	for v, col := range cols {
+		for i := range col {
+			if col[i] != want[v][i] {
+				t.Fatalf("EvalBatch modified column %s", v)
+			}
+		}
+	}
+}
+
+func TestEvalBatchErrors(t *testing.T) {
+	expr, err := Parse("frob(x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EvalBatch(expr, testColumns(10), 1); err == nil {
+		t.Error("EvalBatch(frob(x)) succeeded, want error")
+	}
+	cols := testColumns(10)
+	cols["y"] = cols["y"][:5]
+	if _, err := EvalBatch(Var("x"), cols, 1); err == nil {
+		t.Error("EvalBatch with ragged columns succeeded, want error")
+	}
+	if got, err := EvalBatch(Var("x"), nil, 1); err != nil || len(got) != 0 {
+		t.Errorf("EvalBatch with no columns = %v, %v, want empty result", got, err)
+	}
+}
+
+const benchRows = 50000
+
+func BenchmarkRowEval(b *testing.B) {
+	expr, err := Parse(benchExpr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cols := testColumns(benchRows)
+	result := make([]float64, benchRows)
+	for i := 0; i < b.N; i++ {
+		for row := range result {
+			env := Env{"x": cols["x"][row], "y": cols["y"][row]}
+			result[row] = expr.Eval(env)
+		}
+	}
+}
+
+func BenchmarkEvalBatch(b *testing.B) {
+	benchmarkEvalBatch(b, 1)
+}
+
+func BenchmarkEvalBatchParallel(b *testing.B) {
+	benchmarkEvalBatch(b, 0)
+}
+
+func benchmarkEvalBatch(b *testing.B, workers int) {
+	expr, err := Parse(benchExpr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cols := testColumns(benchRows)
+	for i := 0; i < b.N; i++ {
+		if _, err := EvalBatch(expr, cols, workers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -5,7 +5,7 @@ package eval
 
 import (
 	"fmt"
-	"strings"
+	"sort"
 )
 
 //!+Check
@@ -20,14 +20,16 @@ func (literal) Check(vars map[Var]bool) error {
 }
 
 func (u unary) Check(vars map[Var]bool) error {
-	if !strings.ContainsRune("+-", u.op) {
+	switch u.op {
+	case "+", "-", "!":
+	default:
 		return fmt.Errorf("unexpected unary op %q", u.op)
 	}
 	return u.x.Check(vars)
 }
 
 func (b binary) Check(vars map[Var]bool) error {
-	if !strings.ContainsRune("+-*/", b.op) {
+	if precedence(b.op) == 0 {
 		return fmt.Errorf("unexpected binary op %q", b.op)
 	}
 	if err := b.x.Check(vars); err != nil {
@@ -36,14 +38,23 @@ func (b binary) Check(vars map[Var]bool) error {
 	return b.y.Check(vars)
 }
 
+func (c conditional) Check(vars map[Var]bool) error {
+	if err := c.cond.Check(vars); err != nil {
+		return err
+	}
+	if err := c.x.Check(vars); err != nil {
+		return err
+	}
+	return c.y.Check(vars)
+}
+
 func (c call) Check(vars map[Var]bool) error {
-	arity, ok := numParams[c.fn]
-	if !ok {
+	f := Funcs.lookup(c.fn)
+	if f == nil {
 		return fmt.Errorf("unknown function %q", c.fn)
 	}
-	if len(c.args) != arity {
-		return fmt.Errorf("call to %s has %d args, want %d",
-			c.fn, len(c.args), arity)
+	if err := f.checkArgs(c.fn, len(c.args)); err != nil {
+		return err
 	}
 	for _, arg := range c.args {
 		if err := arg.Check(vars); err != nil {
@@ -53,6 +64,270 @@ func (c call) Check(vars map[Var]bool) error {
 	return nil
 }
 
-var numParams = map[string]int{"pow": 2, "sin": 1, "sqrt": 1}
+//!-Check
+
+func (l let) Check(vars map[Var]bool) error {
+	if err := l.x.Check(vars); err != nil {
+		return err
+	}
+	inner := make(map[Var]bool)
+	if err := l.body.Check(inner); err != nil {
+		return err
+	}
+	for v := range inner {
+		if v != l.v {
+			vars[v] = true
+		}
+	}
+	return nil
+}
+
+func (b block) Check(vars map[Var]bool) error {
+	for _, d := range b.defs {
+		if err := d.Check(); err != nil {
+			return err
+		}
+	}
+	return b.body.Check(vars)
+}
+
+func (a apply) Check(vars map[Var]bool) error {
+	if len(a.args) != len(a.def.Params) {
+		return fmt.Errorf("call to %s has %d args, want %d",
+			a.def.Name, len(a.args), len(a.def.Params))
+	}
+	for _, arg := range a.args {
+		if err := arg.Check(vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check reports the first error in the definition, including
+// references to variables other than its parameters and
+// recursive calls.  A call to a function that was made by Def.Func
+// and registered in Funcs is recursive if that function may call
+// one named d.Name, since registering d would replace it.
+func (d *Def) Check() error {
+	if err := d.checkParams(); err != nil {
+		return err
+	}
+	var err error
+	inspect(d.Body, func(e Expr) bool {
+		switch e := e.(type) {
+		case apply:
+			if err == nil && reaches(e.def, d) {
+				err = fmt.Errorf("recursive call to %s", e.def.Name)
+			}
+		case call:
+			if err == nil && callsFunc(e.fn, d.Name) {
+				err = fmt.Errorf("recursive call to %s", e.fn)
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	vars := make(map[Var]bool)
+	if err := d.Body.Check(vars); err != nil {
+		return err
+	}
+	for _, v := range sortedVars(vars) {
+		if !d.isParam(v) {
+			return fmt.Errorf("undefined variable %s in definition of %s", v, d.Name)
+		}
+	}
+	return nil
+}
+
+func (d *Def) checkParams() error {
+	for i, p := range d.Params {
+		for _, q := range d.Params[:i] {
+			if p == q {
+				return fmt.Errorf("duplicate parameter %s in definition of %s", p, d.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Def) isParam(v Var) bool {
+	for _, p := range d.Params {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedVars(vars map[Var]bool) []Var {
+	var list []Var
+	for v := range vars {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// A Diagnostic describes a problem in an expression and its location.
+type Diagnostic struct {
+	Span Span
+	Msg  string
+}
+
+// Error returns the message of the diagnostic, without its position.
+func (d *Diagnostic) Error() string { return d.Msg }
+
+// An ErrorList is a list of diagnostics ordered by position.
+// Its Error method reports the first of them.
+type ErrorList []*Diagnostic
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
 
-//!-Check
\ No newline at end of file
+// Check reports every problem in e: unexpected operators, calls to
+// unknown functions, calls with the wrong number of arguments,
+// recursive function definitions, references within a definition
+// to variables other than its parameters, and, if schema is non-nil,
+// free variables that are not in schema.
+//
+// If there are any problems, Check returns an ErrorList.  Each
+// diagnostic has the span of the innermost node at fault, such as
+// the occurrence of an undefined variable.  The spans are zero if e
+// was not produced by Parse.
+func Check(e Expr, schema map[Var]bool) error {
+	c := &checker{schema: schema}
+	c.check(e)
+	return c.errors.sorted()
+}
+
+// sorted returns the list ordered by position, or nil if it is empty.
+func (list ErrorList) sorted() error {
+	if len(list) == 0 {
+		return nil
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Span.Start.Offset < list[j].Span.Start.Offset
+	})
+	return list
+}
+
+type checker struct {
+	schema map[Var]bool
+	scope  map[Var]bool // variables bound by enclosing lets and parameters
+	def    *Def         // the enclosing definition, or nil
+	span   Span         // span of the innermost enclosing node that has one
+	errors ErrorList
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.errors = append(c.errors, &Diagnostic{c.span, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) check(e Expr) {
+	if span := spanOf(e); span != (Span{}) {
+		outer := c.span
+		c.span = span
+		defer func() { c.span = outer }()
+	}
+	switch e := e.(type) {
+	case varRef:
+		c.check(e.Var) // at the span of e
+
+	case Var:
+		switch {
+		case c.scope[e]:
+			// ok
+		case c.def != nil:
+			c.errorf("undefined variable %s in definition of %s", e, c.def.Name)
+		case c.schema != nil && !c.schema[e]:
+			c.errorf("undefined variable %s", e)
+		}
+
+	case let:
+		c.check(e.x)
+		outer := c.scope
+		c.scope = map[Var]bool{e.v: true}
+		for v := range outer {
+			c.scope[v] = true
+		}
+		c.check(e.body)
+		c.scope = outer
+
+	case block:
+		for _, d := range e.defs {
+			c.checkDef(d)
+		}
+		c.check(e.body)
+
+	case apply:
+		if len(e.args) != len(e.def.Params) {
+			c.errorf("call to %s has %d args, want %d",
+				e.def.Name, len(e.args), len(e.def.Params))
+		}
+		if c.def != nil && reaches(e.def, c.def) {
+			c.errorf("recursive call to %s", e.def.Name)
+		}
+		for _, arg := range e.args {
+			c.check(arg)
+		}
+
+	case literal:
+		// ok
+
+	case unary:
+		switch e.op {
+		case "+", "-", "!":
+		default:
+			c.errorf("unexpected unary op %q", e.op)
+		}
+		c.check(e.x)
+
+	case binary:
+		if precedence(e.op) == 0 {
+			c.errorf("unexpected binary op %q", e.op)
+		}
+		c.check(e.x)
+		c.check(e.y)
+
+	case conditional:
+		c.check(e.cond)
+		c.check(e.x)
+		c.check(e.y)
+
+	case call:
+		if f := Funcs.lookup(e.fn); f == nil {
+			c.errorf("unknown function %q", e.fn)
+		} else if err := f.checkArgs(e.fn, len(e.args)); err != nil {
+			c.errorf("%s", err)
+		}
+		for _, arg := range e.args {
+			c.check(arg)
+		}
+
+	default:
+		c.errorf("unknown Expr: %T", e)
+	}
+}
+
+func (c *checker) checkDef(d *Def) {
+	if err := d.checkParams(); err != nil {
+		c.errorf("%s", err)
+	}
+	outerScope, outerDef := c.scope, c.def
+	c.scope, c.def = make(map[Var]bool), d
+	for _, p := range d.Params {
+		c.scope[p] = true
+	}
+	c.check(d.Body)
+	c.scope, c.def = outerScope, outerDef
+}
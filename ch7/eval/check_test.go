@@ -0,0 +1,89 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  []string // "span: msg" for each error
+	}{
+		{"x + * y", []string{"1:5-1:6: unexpected '*'"}},
+		{"(x + y", []string{"1:7-1:7: got end of file, want ')'"}},
+		{"f(%) + g(x,, y)", []string{
+			"1:3-1:4: unexpected '%'",
+			"1:12-1:13: unexpected ','",
+		}},
+		{"1e999 +\n  $ - 2", []string{
+			`1:1-1:6: strconv.ParseFloat: parsing "1e999": value out of range`,
+			"2:3-2:4: unexpected '$'",
+		}},
+	} {
+		_, err := Parse(test.input)
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("Parse(%q) error = %v, want ErrorList", test.input, err)
+			continue
+		}
+		var got []string
+		for _, d := range list {
+			got = append(got, fmt.Sprintf("%s-%s: %s", d.Span.Start, d.Span.End, d.Msg))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("Parse(%q) errors:\n%s\nwant:\n%s",
+				test.input, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestCheckDiagnostics(t *testing.T) {
+	schema := map[Var]bool{"x": true, "y": true}
+	for _, test := range []struct {
+		input string
+		want  []string
+	}{
+		{"sqrt(x*x + y*y)", nil},
+		{"frob(x) + sqrt(x, y)\n  + pow(z, 2)", []string{
+			`1:1-1:8: unknown function "frob"`,
+			"1:11-1:21: call to sqrt has 2 args, want 1",
+			"2:9-2:10: undefined variable z",
+		}},
+		{"x > 0 ? w : max()", []string{
+			"1:9-1:10: undefined variable w",
+			"1:13-1:18: call to max has 0 args, want at least 1",
+		}},
+		{"a + b", []string{
+			"1:1-1:2: undefined variable a",
+			"1:5-1:6: undefined variable b",
+		}},
+		{"b", []string{"1:1-1:2: undefined variable b"}},
+		{"let a = 1 in (a + long)", []string{"1:19-1:23: undefined variable long"}},
+	} {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var got []string
+		list, _ := Check(expr, schema).(ErrorList)
+		for _, d := range list {
+			got = append(got, fmt.Sprintf("%s-%s: %s", d.Span.Start, d.Span.End, d.Msg))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("Check(%q):\n%s\nwant:\n%s",
+				test.input, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+
+	// Without a schema, any variable is allowed.
+	expr, _ := Parse("z + 1")
+	if err := Check(expr, nil); err != nil {
+		t.Errorf("Check(z + 1, nil) = %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A Program is an expression compiled to instructions for a small
+// stack machine.  Variables are resolved to slots at compile time,
+// so evaluating a Program involves neither interface dispatch nor
+// map lookups.
+//
+// A Program is immutable and may be evaluated concurrently.
+type Program struct {
+	Vars []Var // the variable held in each slot, in sorted order
+
+	code   []instr
+	consts []float64
+	funcs  []*Func
+	slots  int       // number of slots: len(Vars) plus temporaries
+	depth  int       // maximum stack depth
+	frames sync.Pool // of *[]float64 holding slots and stack
+}
+
+type opcode uint8
+
+const (
+	opConst         opcode = iota // push consts[arg]
+	opLoad                        // push slots[arg]
+	opStore                       // pop slots[arg]
+	opNeg                         // x -> -x
+	opNot                         // x -> !x
+	opBool                        // x -> x != 0
+	opAdd                         // x y -> x+y
+	opSub                         // x y -> x-y
+	opMul                         // x y -> x*y
+	opDiv                         // x y -> x/y
+	opLt                          // x y -> x<y
+	opLe                          // x y -> x<=y
+	opGt                          // x y -> x>y
+	opGe                          // x y -> x>=y
+	opEq                          // x y -> x==y
+	opNe                          // x y -> x!=y
+	opJump                        // pc = arg
+	opJumpIfZero                  // pop x; if x == 0, pc = arg
+	opJumpIfNonzero               // pop x; if x != 0, pc = arg
+	opCall                        // x1 ... xn -> funcs[arg](x1, ..., xn)
+)
+
+var binaryOpcodes = map[string]opcode{
+	"+": opAdd, "-": opSub, "*": opMul, "/": opDiv,
+	"<": opLt, "<=": opLe, ">": opGt, ">=": opGe, "==": opEq, "!=": opNe,
+}
+
+type instr struct {
+	op    opcode
+	arg   int32
+	nargs int32 // number of arguments, for opCall
+}
+
+// Compile checks e and compiles it to a Program.  The body of a
+// defined function is inlined at each call, so Compile reports an
+// error if the program would be too large.
+func Compile(e Expr) (*Program, error) {
+	vars := make(map[Var]bool)
+	if err := e.Check(vars); err != nil {
+		return nil, err
+	}
+	if err := checkSize(e, false); err != nil {
+		return nil, err
+	}
+	c := &compiler{
+		prog:   new(Program),
+		slots:  make(map[Var]int),
+		consts: make(map[float64]int),
+	}
+	for v := range vars {
+		c.prog.Vars = append(c.prog.Vars, v)
+	}
+	sort.Slice(c.prog.Vars, func(i, j int) bool {
+		return c.prog.Vars[i] < c.prog.Vars[j]
+	})
+	for i, v := range c.prog.Vars {
+		c.slots[v] = i
+	}
+	c.prog.slots = len(c.prog.Vars)
+	c.expr(e)
+	return c.prog, nil
+}
+
+type compiler struct {
+	prog   *Program
+	slots  map[Var]int // slot of each variable in scope
+	consts map[float64]int
+	sp     int // current stack depth
+}
+
+func (c *compiler) emit(op opcode, arg int) int {
+	c.prog.code = append(c.prog.code, instr{op: op, arg: int32(arg)})
+	return len(c.prog.code) - 1
+}
+
+// push records that the instruction just emitted grew the stack by n.
+func (c *compiler) push(n int) {
+	c.sp += n
+	if c.sp > c.prog.depth {
+		c.prog.depth = c.sp
+	}
+}
+
+// patch sets the target of the jump at pc to the next instruction.
+func (c *compiler) patch(pc int) {
+	c.prog.code[pc].arg = int32(len(c.prog.code))
+}
+
+func (c *compiler) constant(f float64) {
+	i, ok := c.consts[f]
+	if !ok {
+		i = len(c.prog.consts)
+		c.prog.consts = append(c.prog.consts, f)
+		c.consts[f] = i
+	}
+	c.emit(opConst, i)
+	c.push(1)
+}
+
+// store emits code to pop the top of the stack into a new slot.
+func (c *compiler) store() int {
+	slot := c.prog.slots
+	c.prog.slots++
+	c.emit(opStore, slot)
+	c.push(-1)
+	return slot
+}
+
+func (c *compiler) expr(e Expr) {
+	switch e := e.(type) {
+	case literal:
+		c.constant(float64(e))
+
+	case Var:
+		c.emit(opLoad, c.slots[e])
+		c.push(1)
+
+	case varRef:
+		c.expr(e.Var)
+
+	case unary:
+		c.expr(e.x)
+		switch e.op {
+		case "-":
+			c.emit(opNeg, 0)
+		case "!":
+			c.emit(opNot, 0)
+		}
+
+	case binary:
+		switch e.op {
+		case "&&", "||":
+			// x && y  =>  x; jz L1; y; bool; jmp L2; L1: const 0; L2:
+			// x || y  =>  x; jnz L1; y; bool; jmp L2; L1: const 1; L2:
+			jump, short := opJumpIfZero, 0.0
+			if e.op == "||" {
+				jump, short = opJumpIfNonzero, 1.0
+			}
+			c.expr(e.x)
+			l1 := c.emit(jump, 0)
+			c.push(-1)
+			c.expr(e.y)
+			c.emit(opBool, 0)
+			l2 := c.emit(opJump, 0)
+			c.push(-1)
+			c.patch(l1)
+			c.constant(short)
+			c.patch(l2)
+			return
+		}
+		c.expr(e.x)
+		c.expr(e.y)
+		c.emit(binaryOpcodes[e.op], 0)
+		c.push(-1)
+
+	case conditional:
+		c.expr(e.cond)
+		l1 := c.emit(opJumpIfZero, 0)
+		c.push(-1)
+		c.expr(e.x)
+		l2 := c.emit(opJump, 0)
+		c.push(-1)
+		c.patch(l1)
+		c.expr(e.y)
+		c.patch(l2)
+
+	case call:
+		for _, arg := range e.args {
+			c.expr(arg)
+		}
+		c.prog.funcs = append(c.prog.funcs, Funcs.lookup(e.fn))
+		pc := c.emit(opCall, len(c.prog.funcs)-1)
+		c.prog.code[pc].nargs = int32(len(e.args))
+		c.push(1 - len(e.args))
+
+	case let:
+		c.expr(e.x)
+		slot := c.store()
+		outer := c.slots
+		c.slots = map[Var]int{e.v: slot}
+		for v, i := range outer {
+			if v != e.v {
+				c.slots[v] = i
+			}
+		}
+		c.expr(e.body)
+		c.slots = outer
+
+	case block:
+		c.expr(e.body)
+
+	case apply:
+		// Inline the body of the function, whose
+		// parameters are held in new slots.
+		params := make(map[Var]int)
+		for i, arg := range e.args {
+			c.expr(arg)
+			params[e.def.Params[i]] = c.store()
+		}
+		outer := c.slots
+		c.slots = params
+		c.expr(e.def.Body)
+		c.slots = outer
+
+	default:
+		panic(fmt.Sprintf("unknown Expr: %T", e))
+	}
+}
+
+// Slots returns a slice of variable values from env
+// in the order expected by Eval.
+func (p *Program) Slots(env Env) []float64 {
+	slots := make([]float64, len(p.Vars))
+	for i, v := range p.Vars {
+		slots[i] = env[v]
+	}
+	return slots
+}
+
+// Eval evaluates the program with the value of
+// variable p.Vars[i] in slots[i].
+func (p *Program) Eval(slots []float64) float64 {
+	// The stack escapes to the functions called by opCall,
+	// so reuse frames rather than allocating one per call.
+	fp, _ := p.frames.Get().(*[]float64)
+	if fp == nil {
+		f := make([]float64, p.slots+p.depth)
+		fp = &f
+	}
+	defer p.frames.Put(fp)
+	frame := *fp
+	copy(frame, slots[:len(p.Vars)])
+	slots = frame[:p.slots]
+	stack := frame[p.slots:p.slots:len(frame)]
+	for pc := 0; pc < len(p.code); pc++ {
+		in := p.code[pc]
+		switch in.op {
+		case opConst:
+			stack = append(stack, p.consts[in.arg])
+			continue
+		case opLoad:
+			stack = append(stack, slots[in.arg])
+			continue
+		case opStore:
+			top := len(stack) - 1
+			slots[in.arg] = stack[top]
+			stack = stack[:top]
+			continue
+		case opJump:
+			pc = int(in.arg) - 1
+			continue
+		case opCall:
+			n := len(stack) - int(in.nargs)
+			y := p.funcs[in.arg].Impl(stack[n:])
+			stack = append(stack[:n], y)
+			continue
+		}
+
+		top := len(stack) - 1
+		x := stack[top]
+		switch in.op {
+		case opNeg:
+			stack[top] = -x
+			continue
+		case opNot:
+			stack[top] = truth(x == 0)
+			continue
+		case opBool:
+			stack[top] = truth(x != 0)
+			continue
+		case opJumpIfZero, opJumpIfNonzero:
+			stack = stack[:top]
+			if (x == 0) == (in.op == opJumpIfZero) {
+				pc = int(in.arg) - 1
+			}
+			continue
+		}
+
+		// binary operators
+		stack = stack[:top]
+		top--
+		x, y := stack[top], x
+		switch in.op {
+		case opAdd:
+			stack[top] = x + y
+		case opSub:
+			stack[top] = x - y
+		case opMul:
+			stack[top] = x * y
+		case opDiv:
+			stack[top] = x / y
+		case opLt:
+			stack[top] = truth(x < y)
+		case opLe:
+			stack[top] = truth(x <= y)
+		case opGt:
+			stack[top] = truth(x > y)
+		case opGe:
+			stack[top] = truth(x >= y)
+		case opEq:
+			stack[top] = truth(x == y)
+		case opNe:
+			stack[top] = truth(x != y)
+		default:
+			panic(fmt.Sprintf("unknown opcode %d", in.op))
+		}
+	}
+	return stack[0]
+}
@@ -0,0 +1,84 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	env := Env{"x": 3, "y": -4, "z": 0.5, "A": 87616, "pi": math.Pi, "F": 212}
+	for _, input := range []string{
+		"sqrt(A / pi)",
+		"pow(x, 3) + pow(y, 3)",
+		"5 / 9 * (F - 32)",
+		"-1 + -x",
+		"+x - -y",
+		"x > 3 && y <= 2 ? z : -z",
+		"x >= 3 && y <= 2 ? z : -z",
+		"x < 0 || y < 0",
+		"x < 0 || y > 0",
+		"!(x == 3) != !z",
+		"x < 0 ? -1 : x == 0 ? 0 : 1",
+		"max(x, y, z, 1, 2, 3) * hypot(x, y)",
+		"0 && 1/0",
+		"1 || 1/0",
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		prog, err := Compile(expr)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", input, err)
+			continue
+		}
+		want := expr.Eval(env)
+		if got := prog.Eval(prog.Slots(env)); got != want {
+			t.Errorf("%s: compiled Eval = %g, tree Eval = %g", input, got, want)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	expr, err := Parse("frob(x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Compile(expr); err == nil {
+		t.Error("Compile(frob(x)) succeeded, want error")
+	}
+}
+
+const benchExpr = "sqrt(x*x + y*y) < 1 ? sin(x) * cos(y) : pow(x, 2) / (1 + y*y)"
+
+func BenchmarkTreeEval(b *testing.B) {
+	expr, err := Parse(benchExpr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	env := Env{"x": 0.5, "y": 0.25}
+	for i := 0; i < b.N; i++ {
+		env["x"] = float64(i%100) / 50
+		expr.Eval(env)
+	}
+}
+
+func BenchmarkProgramEval(b *testing.B) {
+	expr, err := Parse(benchExpr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(expr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	slots := prog.Slots(Env{"x": 0.5, "y": 0.25})
+	for i := 0; i < b.N; i++ {
+		slots[0] = float64(i%100) / 50
+		prog.Eval(slots)
+	}
+}
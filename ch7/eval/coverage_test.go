@@ -20,8 +20,11 @@ func TestCoverage(t *testing.T) {
 		want  string // expected error from Parse/Check or result from Eval
 	}{
 		{"x % 2", nil, "unexpected '%'"},
-		{"!true", nil, "unexpected '!'"},
-		{"log(10)", nil, `unknown function "log"`},
+		{"x & y", nil, "unexpected '&'"},
+		{"x >= 0 ? sqrt(x) : -1", Env{"x": 49}, "7"},
+		{"frob(10)", nil, `unknown function "frob"`},
+		{"max()", nil, "call to max has 0 args, want at least 1"},
+		{"log10(1000) + max(x, 2, y)", Env{"x": 1, "y": 3}, "6"},
 		{"sqrt(1, 2)", nil, "call to sqrt has 2 args, want 1"},
 		{"sqrt(A / pi)", Env{"A": 87616, "pi": math.Pi}, "167"},
 		{"pow(x, 3) + pow(y, 3)", Env{"x": 9, "y": 10}, "1729"},
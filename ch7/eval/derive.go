@@ -0,0 +1,296 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"math"
+)
+
+// Derive returns the derivative of e with respect to v.
+//
+// Comparisons, logical operators and step functions such as floor
+// are treated as piecewise constant, so their derivative is zero.
+// Derive reports an error if e calls a function that has no
+// derivative rule, such as gamma or one registered by the caller.
+//
+// Let-bound variables and calls to defined functions are expanded
+// in the result.
+func Derive(e Expr, v Var) (d Expr, err error) {
+	defer recoverEval(&err)
+	return derive(expand(e, nil), v), nil
+}
+
+func derive(e Expr, v Var) Expr {
+	switch e := e.(type) {
+	case literal:
+		return literal(0)
+
+	case Var:
+		if e == v {
+			return literal(1)
+		}
+		return literal(0)
+
+	case unary:
+		switch e.op {
+		case "+":
+			return derive(e.x, v)
+		case "-":
+			return negExpr(derive(e.x, v))
+		case "!":
+			return literal(0)
+		}
+		panic(fmt.Sprintf("unsupported unary operator: %q", e.op))
+
+	case binary:
+		switch e.op {
+		case "+":
+			return addExpr(derive(e.x, v), derive(e.y, v))
+		case "-":
+			return subExpr(derive(e.x, v), derive(e.y, v))
+		case "*": // (xy)' = x'y + xy'
+			return addExpr(mulExpr(derive(e.x, v), e.y), mulExpr(e.x, derive(e.y, v)))
+		case "/": // (x/y)' = (x'y - xy') / y²
+			return divExpr(
+				subExpr(mulExpr(derive(e.x, v), e.y), mulExpr(e.x, derive(e.y, v))),
+				mulExpr(e.y, e.y))
+		}
+		if precedence(e.op) > 0 {
+			return literal(0) // comparison or logical operator
+		}
+		panic(fmt.Sprintf("unsupported binary operator: %q", e.op))
+
+	case conditional:
+		return condExpr(e.cond, derive(e.x, v), derive(e.y, v))
+
+	case call:
+		switch e.fn {
+		case "min", "max":
+			// max(x, rest...)' = x >= max(rest...) ? x' : max(rest...)'
+			if len(e.args) == 1 {
+				return derive(e.args[0], v)
+			}
+			op := ">="
+			if e.fn == "min" {
+				op = "<="
+			}
+			x, rest := e.args[0], call{fn: e.fn, args: e.args[1:]}
+			return condExpr(binary{op: op, x: x, y: rest}, derive(x, v), derive(rest, v))
+		}
+		partials, ok := derivatives[e.fn]
+		if !ok {
+			panic(evalError(fmt.Sprintf("no derivative for function %s", e.fn)))
+		}
+		// Chain rule: f(g₁, ..., gₙ)' = Σ ∂f/∂xᵢ(g₁, ..., gₙ) gᵢ'
+		var sum Expr = literal(0)
+		for i, p := range partials(e.args) {
+			sum = addExpr(sum, mulExpr(p, derive(e.args[i], v)))
+		}
+		return sum
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
+
+// derivatives maps each function name to a function that, given the
+// arguments of a call, returns the partial derivative of the call
+// with respect to each argument.
+var derivatives = map[string]func(args []Expr) []Expr{
+	"abs": func(a []Expr) []Expr { return d1(divExpr(a[0], callExpr("abs", a[0]))) },
+	"acos": func(a []Expr) []Expr {
+		return d1(negExpr(divExpr(literal(1), callExpr("sqrt", subExpr(literal(1), mulExpr(a[0], a[0]))))))
+	},
+	"acosh": func(a []Expr) []Expr {
+		return d1(divExpr(literal(1), callExpr("sqrt", subExpr(mulExpr(a[0], a[0]), literal(1)))))
+	},
+	"asin": func(a []Expr) []Expr {
+		return d1(divExpr(literal(1), callExpr("sqrt", subExpr(literal(1), mulExpr(a[0], a[0])))))
+	},
+	"asinh": func(a []Expr) []Expr {
+		return d1(divExpr(literal(1), callExpr("sqrt", addExpr(mulExpr(a[0], a[0]), literal(1)))))
+	},
+	"atan":  func(a []Expr) []Expr { return d1(divExpr(literal(1), addExpr(literal(1), mulExpr(a[0], a[0])))) },
+	"atanh": func(a []Expr) []Expr { return d1(divExpr(literal(1), subExpr(literal(1), mulExpr(a[0], a[0])))) },
+	"cbrt": func(a []Expr) []Expr {
+		return d1(divExpr(literal(1), mulExpr(literal(3), mulExpr(callExpr("cbrt", a[0]), callExpr("cbrt", a[0])))))
+	},
+	"ceil": zeroPartials,
+	"conj": func(a []Expr) []Expr { return d1(literal(1)) },
+	"cos":  func(a []Expr) []Expr { return d1(negExpr(callExpr("sin", a[0]))) },
+	"cosh": func(a []Expr) []Expr { return d1(callExpr("sinh", a[0])) },
+	"erf": func(a []Expr) []Expr {
+		return d1(mulExpr(literal(2/math.SqrtPi), callExpr("exp", negExpr(mulExpr(a[0], a[0])))))
+	},
+	"erfc": func(a []Expr) []Expr {
+		return d1(mulExpr(literal(-2/math.SqrtPi), callExpr("exp", negExpr(mulExpr(a[0], a[0])))))
+	},
+	"exp":   func(a []Expr) []Expr { return d1(callExpr("exp", a[0])) },
+	"exp2":  func(a []Expr) []Expr { return d1(mulExpr(callExpr("exp2", a[0]), callExpr("log", literal(2)))) },
+	"expm1": func(a []Expr) []Expr { return d1(callExpr("exp", a[0])) },
+	"floor": zeroPartials,
+	"imag":  zeroPartials,
+	"j0":    func(a []Expr) []Expr { return d1(negExpr(callExpr("j1", a[0]))) },
+	"j1":    func(a []Expr) []Expr { return d1(subExpr(callExpr("j0", a[0]), divExpr(callExpr("j1", a[0]), a[0]))) },
+	"log":   func(a []Expr) []Expr { return d1(divExpr(literal(1), a[0])) },
+	"log10": func(a []Expr) []Expr { return d1(divExpr(literal(1), mulExpr(a[0], callExpr("log", literal(10))))) },
+	"log1p": func(a []Expr) []Expr { return d1(divExpr(literal(1), addExpr(literal(1), a[0]))) },
+	"log2":  func(a []Expr) []Expr { return d1(divExpr(literal(1), mulExpr(a[0], callExpr("log", literal(2))))) },
+	"logb":  zeroPartials,
+	"phase": zeroPartials,
+	"real":  func(a []Expr) []Expr { return d1(literal(1)) },
+	"round": zeroPartials,
+	"sin":   func(a []Expr) []Expr { return d1(callExpr("cos", a[0])) },
+	"sinh":  func(a []Expr) []Expr { return d1(callExpr("cosh", a[0])) },
+	"sqrt":  func(a []Expr) []Expr { return d1(divExpr(literal(1), mulExpr(literal(2), callExpr("sqrt", a[0])))) },
+	"tan": func(a []Expr) []Expr {
+		return d1(divExpr(literal(1), mulExpr(callExpr("cos", a[0]), callExpr("cos", a[0]))))
+	},
+	"tanh": func(a []Expr) []Expr {
+		return d1(subExpr(literal(1), mulExpr(callExpr("tanh", a[0]), callExpr("tanh", a[0]))))
+	},
+	"trunc":    zeroPartials,
+	"y0":       func(a []Expr) []Expr { return d1(negExpr(callExpr("y1", a[0]))) },
+	"y1":       func(a []Expr) []Expr { return d1(subExpr(callExpr("y0", a[0]), divExpr(callExpr("y1", a[0]), a[0]))) },
+	"copysign": func(a []Expr) []Expr { return d2(callExpr("copysign", literal(1), mulExpr(a[0], a[1])), literal(0)) },
+	"dim": func(a []Expr) []Expr { // dim(x, y) = max(x-y, 0)
+		gt := binary{op: ">", x: a[0], y: a[1]}
+		return d2(condExpr(gt, literal(1), literal(0)), condExpr(gt, literal(-1), literal(0)))
+	},
+	"mod": func(a []Expr) []Expr { return d2(literal(1), negExpr(callExpr("trunc", divExpr(a[0], a[1])))) },
+	"remainder": func(a []Expr) []Expr {
+		return d2(literal(1), negExpr(divExpr(subExpr(a[0], callExpr("remainder", a[0], a[1])), a[1])))
+	},
+	"atan2": func(a []Expr) []Expr { // atan2(y, x)
+		r2 := addExpr(mulExpr(a[0], a[0]), mulExpr(a[1], a[1]))
+		return d2(divExpr(a[1], r2), negExpr(divExpr(a[0], r2)))
+	},
+	"hypot": func(a []Expr) []Expr {
+		h := callExpr("hypot", a[0], a[1])
+		return d2(divExpr(a[0], h), divExpr(a[1], h))
+	},
+	"pow": func(a []Expr) []Expr { // pow(x, y)' = y pow(x, y-1) x' + pow(x, y) log(x) y'
+		return d2(mulExpr(a[1], callExpr("pow", a[0], subExpr(a[1], literal(1)))),
+			mulExpr(callExpr("pow", a[0], a[1]), callExpr("log", a[0])))
+	},
+}
+
+func d1(x Expr) []Expr    { return []Expr{x} }
+func d2(x, y Expr) []Expr { return []Expr{x, y} }
+
+func zeroPartials(args []Expr) []Expr {
+	d := make([]Expr, len(args))
+	for i := range d {
+		d[i] = literal(0)
+	}
+	return d
+}
+
+// ---- simplifying constructors ----
+
+// The following functions construct expressions, applying
+// just enough algebraic simplification to keep derivatives
+// readable: constant folding and the identities of 0 and 1.
+
+func isConst(e Expr, f float64) bool {
+	l, ok := e.(literal)
+	return ok && float64(l) == f
+}
+
+func callExpr(name string, args ...Expr) Expr { return call{fn: name, args: args} }
+
+func negExpr(x Expr) Expr {
+	switch x := x.(type) {
+	case literal:
+		return -x
+	case unary:
+		if x.op == "-" {
+			return x.x
+		}
+	}
+	return unary{op: "-", x: x}
+}
+
+func addExpr(x, y Expr) Expr {
+	switch {
+	case isConst(x, 0):
+		return y
+	case isConst(y, 0):
+		return x
+	}
+	if lx, ok := x.(literal); ok {
+		if ly, ok := y.(literal); ok {
+			return lx + ly
+		}
+	}
+	if u, ok := y.(unary); ok && u.op == "-" {
+		return binary{op: "-", x: x, y: u.x} // x + -y = x - y
+	}
+	return binary{op: "+", x: x, y: y}
+}
+
+func subExpr(x, y Expr) Expr {
+	switch {
+	case isConst(y, 0):
+		return x
+	case isConst(x, 0):
+		return negExpr(y)
+	}
+	if lx, ok := x.(literal); ok {
+		if ly, ok := y.(literal); ok {
+			return lx - ly
+		}
+	}
+	if u, ok := y.(unary); ok && u.op == "-" {
+		return binary{op: "+", x: x, y: u.x} // x - -y = x + y
+	}
+	return binary{op: "-", x: x, y: y}
+}
+
+func mulExpr(x, y Expr) Expr {
+	switch {
+	case isConst(x, 0), isConst(y, 0):
+		return literal(0)
+	case isConst(x, 1):
+		return y
+	case isConst(y, 1):
+		return x
+	case isConst(x, -1):
+		return negExpr(y)
+	case isConst(y, -1):
+		return negExpr(x)
+	}
+	if lx, ok := x.(literal); ok {
+		if ly, ok := y.(literal); ok {
+			return lx * ly
+		}
+	}
+	if _, ok := y.(literal); ok {
+		x, y = y, x // put constant factors first
+	}
+	return binary{op: "*", x: x, y: y}
+}
+
+func divExpr(x, y Expr) Expr {
+	switch {
+	case isConst(x, 0):
+		return literal(0)
+	case isConst(y, 1):
+		return x
+	}
+	if lx, ok := x.(literal); ok {
+		if ly, ok := y.(literal); ok && ly != 0 {
+			return lx / ly
+		}
+	}
+	return binary{op: "/", x: x, y: y}
+}
+
+func condExpr(c, x, y Expr) Expr {
+	if lx, ok := x.(literal); ok {
+		if ly, ok := y.(literal); ok && lx == ly {
+			return x // both branches are the same constant
+		}
+	}
+	return conditional{cond: c, x: x, y: y}
+}
@@ -0,0 +1,91 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	for _, test := range []struct {
+		expr, want string
+	}{
+		{"3", "0"},
+		{"y", "0"},
+		{"x", "1"},
+		{"2*x + 1", "2"},
+		{"x*x", "(x + x)"},
+		{"-sin(x)", "(-cos(x))"},
+		{"sin(2*x)", "(2 * cos((2 * x)))"},
+		{"x*y + y", "y"},
+		{"pow(x, 3)", "(3 * pow(x, 2))"},
+		{"1 / x", "(-1 / (x * x))"},
+		{"x > 0 ? x : -x", "((x > 0) ? 1 : -1)"},
+		{"max(x, 2)", "((x >= max(2)) ? 1 : 0)"},
+		{"gamma(x)", "no derivative for function gamma"},
+		{"y * gamma(x)", "no derivative for function gamma"},
+	} {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var got string
+		if d, err := Derive(expr, "x"); err != nil {
+			got = err.Error()
+		} else {
+			got = Format(d)
+		}
+		if got != test.want {
+			t.Errorf("Derive(%s, x) = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+// TestDeriveNumeric compares the derivative of every function
+// that has a derivative rule against a finite difference.
+func TestDeriveNumeric(t *testing.T) {
+	const h = 1e-6
+	env := Env{"y": 0.7}
+	for name := range derivatives {
+		input := name + "(x)"
+		switch Funcs.Lookup(name).Arity {
+		case 2:
+			input = name + "(x, y) + " + name + "(y, x)"
+		}
+		if name == "acosh" {
+			input = "acosh(x + 1)"
+		}
+		for _, x := range []float64{0.4, 2.3} {
+			if name == "atanh" && x > 1 || name == "acos" && x > 1 || name == "asin" && x > 1 {
+				continue
+			}
+			expr, err := Parse(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := Derive(expr, "x")
+			if err != nil {
+				t.Errorf("Derive(%s): %v", input, err)
+				continue
+			}
+			if err := d.Check(map[Var]bool{}); err != nil {
+				t.Errorf("Derive(%s): %v", input, err)
+				continue
+			}
+			env["x"] = x
+			got := d.Eval(env)
+			env["x"] = x + h
+			hi := expr.Eval(env)
+			env["x"] = x - h
+			lo := expr.Eval(env)
+			want := (hi - lo) / (2 * h)
+			if math.Abs(got-want) > 1e-4*math.Max(1, math.Abs(want)) {
+				t.Errorf("Derive(%s) at x=%g = %g, want %g (%s)",
+					input, x, got, want, Format(d))
+			}
+		}
+	}
+}
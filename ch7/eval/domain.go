@@ -0,0 +1,589 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// A Domain is a set of number systems over which
+// an expression may be evaluated.
+type Domain uint
+
+const (
+	Real     Domain = 1 << iota // float64, by Eval
+	Complex                     // complex128, by EvalComplex
+	BigFloat                    // *big.Float, by EvalBigFloat
+	Rational                    // *big.Rat, by EvalRat
+)
+
+func (d Domain) String() string {
+	var names []string
+	for _, x := range []struct {
+		d    Domain
+		name string
+	}{{Real, "real"}, {Complex, "complex"}, {BigFloat, "big.Float"}, {Rational, "rational"}} {
+		if d&x.d != 0 {
+			names = append(names, x.name)
+		}
+	}
+	if names == nil {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Domains returns the set of domains over which f is defined.
+func (f *Func) Domains() Domain {
+	d := Real
+	if f.Complex != nil {
+		d |= Complex
+	}
+	if f.BigFloat != nil {
+		d |= BigFloat
+	}
+	if f.Rat != nil {
+		d |= Rational
+	}
+	return d
+}
+
+// CheckDomain reports every call in e to a function that is not
+// defined over domain d, and, for Complex, every ordered comparison.
+// If there are any, it returns an ErrorList.
+// It assumes that e has already passed Check.
+func CheckDomain(e Expr, d Domain) error {
+	var errors ErrorList
+	inspect(e, func(e Expr) bool {
+		switch e := e.(type) {
+		case binary:
+			switch e.op {
+			case "<", "<=", ">", ">=":
+				if d&Complex != 0 {
+					errors = append(errors, &Diagnostic{e.span,
+						fmt.Sprintf("operator %s is not defined on complex numbers", e.op)})
+				}
+			}
+		case call:
+			if f := Funcs.lookup(e.fn); f != nil && f.Domains()&d != d {
+				errors = append(errors, &Diagnostic{e.span,
+					fmt.Sprintf("%s is not defined over %s numbers", e.fn, d&^f.Domains())})
+			}
+		}
+		return true
+	})
+	return errors.sorted()
+}
+
+// An evalError is a panic value that EvalComplex, EvalBigFloat,
+// EvalRat and Derive report as an error.
+type evalError string
+
+// recoverEval turns a panic caused by an undefined operation,
+// such as division of a rational by zero, into an error.
+func recoverEval(err *error) {
+	switch p := recover().(type) {
+	case nil:
+	case evalError:
+		*err = fmt.Errorf("%s", string(p))
+	case big.ErrNaN:
+		*err = p
+	default:
+		panic(p)
+	}
+}
+
+// ---- complex128 ----
+
+// EvalComplex returns the value of e over the complex numbers.
+// Variables absent from env are zero.  There is no imaginary
+// literal; supply a variable such as i = 1i in env instead.
+// Boolean results are 1 and 0, and any nonzero operand is true.
+func EvalComplex(e Expr, env map[Var]complex128) (z complex128, err error) {
+	if err := Check(e, nil); err != nil {
+		return 0, err
+	}
+	if err := CheckDomain(e, Complex); err != nil {
+		return 0, err
+	}
+	if err := checkSize(e, true); err != nil {
+		return 0, err
+	}
+	defer recoverEval(&err)
+	return evalComplex(expand(e, nil), env), nil
+}
+
+func evalComplex(e Expr, env map[Var]complex128) complex128 {
+	switch e := e.(type) {
+	case Var:
+		return env[e]
+	case literal:
+		return complex(float64(e), 0)
+	case unary:
+		x := evalComplex(e.x, env)
+		switch e.op {
+		case "+":
+			return x
+		case "-":
+			// Not -x, which negates a zero imaginary part and
+			// so selects the other branch of sqrt and log.
+			return 0 - x
+		case "!":
+			return complex(truth(x == 0), 0)
+		}
+	case binary:
+		x := evalComplex(e.x, env)
+		switch e.op {
+		case "&&":
+			return complex(truth(x != 0 && evalComplex(e.y, env) != 0), 0)
+		case "||":
+			return complex(truth(x != 0 || evalComplex(e.y, env) != 0), 0)
+		}
+		y := evalComplex(e.y, env)
+		switch e.op {
+		case "+":
+			return x + y
+		case "-":
+			return x - y
+		case "*":
+			return x * y
+		case "/":
+			return x / y
+		case "==":
+			return complex(truth(x == y), 0)
+		case "!=":
+			return complex(truth(x != y), 0)
+		}
+		panic(evalError(fmt.Sprintf("operator %s is not defined on complex numbers", e.op)))
+	case conditional:
+		if evalComplex(e.cond, env) != 0 {
+			return evalComplex(e.x, env)
+		}
+		return evalComplex(e.y, env)
+	case call:
+		args := make([]complex128, len(e.args))
+		for i, arg := range e.args {
+			args[i] = evalComplex(arg, env)
+		}
+		return Funcs.lookup(e.fn).Complex(args)
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
+
+// ---- *big.Float ----
+
+// EvalBigFloat returns the value of e computed with prec bits of
+// mantissa.  Variables absent from env are zero.  Each literal is
+// converted from its shortest decimal form, so 0.1 is the nearest
+// big.Float to one tenth, not to the float64 0.1.
+func EvalBigFloat(e Expr, env map[Var]*big.Float, prec uint) (z *big.Float, err error) {
+	if err := Check(e, nil); err != nil {
+		return nil, err
+	}
+	if err := CheckDomain(e, BigFloat); err != nil {
+		return nil, err
+	}
+	if err := checkSize(e, true); err != nil {
+		return nil, err
+	}
+	defer recoverEval(&err)
+	return evalBigFloat(expand(e, nil), env, prec), nil
+}
+
+func evalBigFloat(e Expr, env map[Var]*big.Float, prec uint) *big.Float {
+	z := new(big.Float).SetPrec(prec)
+	switch e := e.(type) {
+	case Var:
+		if x, ok := env[e]; ok {
+			return z.Set(x)
+		}
+		return z
+	case literal:
+		if _, ok := z.SetString(strconv.FormatFloat(float64(e), 'g', -1, 64)); !ok {
+			panic(evalError(fmt.Sprintf("invalid literal %g", float64(e))))
+		}
+		return z
+	case unary:
+		x := evalBigFloat(e.x, env, prec)
+		switch e.op {
+		case "+":
+			return x
+		case "-":
+			return z.Neg(x)
+		case "!":
+			return z.SetFloat64(truth(x.Sign() == 0))
+		}
+	case binary:
+		x := evalBigFloat(e.x, env, prec)
+		switch e.op {
+		case "&&":
+			return z.SetFloat64(truth(x.Sign() != 0 && evalBigFloat(e.y, env, prec).Sign() != 0))
+		case "||":
+			return z.SetFloat64(truth(x.Sign() != 0 || evalBigFloat(e.y, env, prec).Sign() != 0))
+		}
+		y := evalBigFloat(e.y, env, prec)
+		switch e.op {
+		case "+":
+			return z.Add(x, y)
+		case "-":
+			return z.Sub(x, y)
+		case "*":
+			return z.Mul(x, y)
+		case "/":
+			return z.Quo(x, y)
+		}
+		return z.SetFloat64(truth(compare(e.op, x.Cmp(y))))
+	case conditional:
+		if evalBigFloat(e.cond, env, prec).Sign() != 0 {
+			return evalBigFloat(e.x, env, prec)
+		}
+		return evalBigFloat(e.y, env, prec)
+	case call:
+		args := make([]*big.Float, len(e.args))
+		for i, arg := range e.args {
+			args[i] = evalBigFloat(arg, env, prec)
+		}
+		return Funcs.lookup(e.fn).BigFloat(z, args)
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
+
+// ---- *big.Rat ----
+
+// EvalRat returns the exact value of e over the rational numbers.
+// Variables absent from env are zero.  Each literal is converted
+// from its shortest decimal form, so 0.1 is exactly 1/10.
+// Division by zero is reported as an error.
+func EvalRat(e Expr, env map[Var]*big.Rat) (z *big.Rat, err error) {
+	if err := Check(e, nil); err != nil {
+		return nil, err
+	}
+	if err := CheckDomain(e, Rational); err != nil {
+		return nil, err
+	}
+	if err := checkSize(e, true); err != nil {
+		return nil, err
+	}
+	defer recoverEval(&err)
+	return evalRat(expand(e, nil), env), nil
+}
+
+func evalRat(e Expr, env map[Var]*big.Rat) *big.Rat {
+	z := new(big.Rat)
+	switch e := e.(type) {
+	case Var:
+		if x, ok := env[e]; ok {
+			return z.Set(x)
+		}
+		return z
+	case literal:
+		if _, ok := z.SetString(strconv.FormatFloat(float64(e), 'g', -1, 64)); !ok {
+			panic(evalError(fmt.Sprintf("invalid literal %g", float64(e))))
+		}
+		return z
+	case unary:
+		x := evalRat(e.x, env)
+		switch e.op {
+		case "+":
+			return x
+		case "-":
+			return z.Neg(x)
+		case "!":
+			return ratTruth(x.Sign() == 0)
+		}
+	case binary:
+		x := evalRat(e.x, env)
+		switch e.op {
+		case "&&":
+			return ratTruth(x.Sign() != 0 && evalRat(e.y, env).Sign() != 0)
+		case "||":
+			return ratTruth(x.Sign() != 0 || evalRat(e.y, env).Sign() != 0)
+		}
+		y := evalRat(e.y, env)
+		switch e.op {
+		case "+":
+			return z.Add(x, y)
+		case "-":
+			return z.Sub(x, y)
+		case "*":
+			return z.Mul(x, y)
+		case "/":
+			return ratQuo(z, x, y)
+		}
+		return ratTruth(compare(e.op, x.Cmp(y)))
+	case conditional:
+		if evalRat(e.cond, env).Sign() != 0 {
+			return evalRat(e.x, env)
+		}
+		return evalRat(e.y, env)
+	case call:
+		args := make([]*big.Rat, len(e.args))
+		for i, arg := range e.args {
+			args[i] = evalRat(arg, env)
+		}
+		return Funcs.lookup(e.fn).Rat(z, args)
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
+
+func ratTruth(b bool) *big.Rat { return big.NewRat(int64(truth(b)), 1) }
+
+func ratQuo(z, x, y *big.Rat) *big.Rat {
+	if y.Sign() == 0 {
+		panic(evalError("division by zero"))
+	}
+	return z.Quo(x, y)
+}
+
+// compare reports whether the comparison op holds
+// given the result cmp of comparing its operands.
+func compare(op string, cmp int) bool {
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	}
+	panic(fmt.Sprintf("unsupported binary operator: %q", op))
+}
+
+// ---- function implementations ----
+
+// registerDomains extends the functions registered by init
+// with their implementations over other domains.
+func registerDomains() {
+	for name, f := range map[string]func(complex128) complex128{
+		"abs":   func(x complex128) complex128 { return complex(cmplx.Abs(x), 0) },
+		"acos":  cmplx.Acos,
+		"acosh": cmplx.Acosh,
+		"asin":  cmplx.Asin,
+		"asinh": cmplx.Asinh,
+		"atan":  cmplx.Atan,
+		"atanh": cmplx.Atanh,
+		"conj":  cmplx.Conj,
+		"cos":   cmplx.Cos,
+		"cosh":  cmplx.Cosh,
+		"exp":   cmplx.Exp,
+		"imag":  func(x complex128) complex128 { return complex(imag(x), 0) },
+		"log":   cmplx.Log,
+		"log10": cmplx.Log10,
+		"phase": func(x complex128) complex128 { return complex(cmplx.Phase(x), 0) },
+		"real":  func(x complex128) complex128 { return complex(real(x), 0) },
+		"sin":   cmplx.Sin,
+		"sinh":  cmplx.Sinh,
+		"sqrt":  cmplx.Sqrt,
+		"tan":   cmplx.Tan,
+		"tanh":  cmplx.Tanh,
+	} {
+		f := f
+		extend(name, func(fn *Func) {
+			fn.Complex = func(args []complex128) complex128 { return f(args[0]) }
+		})
+	}
+	extend("pow", func(fn *Func) {
+		fn.Complex = func(args []complex128) complex128 { return cmplx.Pow(args[0], args[1]) }
+	})
+
+	// Functions of the real numbers that are exact over the rationals.
+	for _, name := range []string{"abs", "ceil", "conj", "floor", "real", "round", "trunc"} {
+		name := name
+		extend(name, func(fn *Func) {
+			fn.BigFloat = func(z *big.Float, args []*big.Float) *big.Float {
+				return bigFloatFunc(name, z, args[0])
+			}
+			fn.Rat = func(z *big.Rat, args []*big.Rat) *big.Rat {
+				return ratFunc(name, z, args[0])
+			}
+		})
+	}
+	extend("imag", func(fn *Func) {
+		fn.BigFloat = func(z *big.Float, _ []*big.Float) *big.Float { return z.SetInt64(0) }
+		fn.Rat = func(z *big.Rat, _ []*big.Rat) *big.Rat { return z.SetInt64(0) }
+	})
+	extend("sqrt", func(fn *Func) {
+		fn.BigFloat = func(z *big.Float, args []*big.Float) *big.Float {
+			if args[0].Sign() < 0 {
+				panic(evalError("square root of negative number"))
+			}
+			return z.Sqrt(args[0])
+		}
+	})
+	for _, name := range []string{"min", "max"} {
+		sign := 1
+		if name == "min" {
+			sign = -1
+		}
+		extend(name, func(fn *Func) {
+			fn.BigFloat = func(z *big.Float, args []*big.Float) *big.Float {
+				acc := args[0]
+				for _, arg := range args[1:] {
+					if arg.Cmp(acc) == sign {
+						acc = arg
+					}
+				}
+				return z.Set(acc)
+			}
+			fn.Rat = func(z *big.Rat, args []*big.Rat) *big.Rat {
+				acc := args[0]
+				for _, arg := range args[1:] {
+					if arg.Cmp(acc) == sign {
+						acc = arg
+					}
+				}
+				return z.Set(acc)
+			}
+		})
+	}
+	extend("pow", func(fn *Func) {
+		fn.BigFloat = func(z *big.Float, args []*big.Float) *big.Float {
+			n, acc := args[1].Int64()
+			if acc != big.Exact || args[1].IsInf() {
+				panic(evalError("pow of big.Float requires an integer exponent"))
+			}
+			return bigFloatPow(z, args[0], n)
+		}
+		fn.Rat = func(z *big.Rat, args []*big.Rat) *big.Rat {
+			if !args[1].IsInt() || !args[1].Num().IsInt64() {
+				panic(evalError("pow of rationals requires an integer exponent"))
+			}
+			return ratPow(z, args[0], args[1].Num().Int64())
+		}
+	})
+}
+
+// extend re-registers the named function after applying update to it.
+func extend(name string, update func(f *Func)) {
+	p := Funcs.lookup(name)
+	if p == nil {
+		panic(fmt.Sprintf("eval: extend %s: no such function", name))
+	}
+	f := *p
+	update(&f)
+	Funcs.Register(name, f)
+}
+
+// bigFloatPow sets z to x raised to the integer power n
+// by repeated squaring, and returns z.
+func bigFloatPow(z, x *big.Float, n int64) *big.Float {
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	x = new(big.Float).SetPrec(z.Prec()).Set(x)
+	z.SetInt64(1)
+	for ; n > 0; n >>= 1 {
+		if n&1 != 0 {
+			z.Mul(z, x)
+		}
+		x.Mul(x, x)
+	}
+	if neg {
+		z.Quo(new(big.Float).SetInt64(1), z)
+	}
+	return z
+}
+
+// maxRatBits is the largest size in bits of the numerator or
+// denominator that ratPow will attempt to compute.
+const maxRatBits = 1 << 20
+
+// ratPow sets z to x raised to the integer power n and returns z.
+// It panics with an evalError if the result would be too large.
+func ratPow(z, x *big.Rat, n int64) *big.Rat {
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	// The size of x^n is about n times that of x, unless x is 0 or ±1.
+	bits := x.Num().BitLen()
+	if d := x.Denom().BitLen(); d > bits {
+		bits = d
+	}
+	if bits > 1 && n > maxRatBits/int64(bits) {
+		panic(evalError(fmt.Sprintf("pow of rationals: result exceeds %d bits", maxRatBits)))
+	}
+	x = new(big.Rat).Set(x)
+	z.SetInt64(1)
+	for ; n > 0; n >>= 1 {
+		if n&1 != 0 {
+			z.Mul(z, x)
+		}
+		x.Mul(x, x)
+	}
+	if neg {
+		ratQuo(z, big.NewRat(1, 1), z)
+	}
+	return z
+}
+
+func bigFloatFunc(name string, z, x *big.Float) *big.Float {
+	switch name {
+	case "abs":
+		return z.Abs(x)
+	case "conj", "real":
+		return z.Set(x)
+	}
+	if x.IsInf() || x.IsInt() {
+		return z.Set(x)
+	}
+	i, _ := x.Int(nil) // truncated toward zero
+	t := new(big.Float).SetInt(i)
+	frac := new(big.Float).Sub(x, t)
+	switch name {
+	case "floor":
+		if x.Sign() < 0 {
+			t.Sub(t, big.NewFloat(1))
+		}
+	case "ceil":
+		if x.Sign() > 0 {
+			t.Add(t, big.NewFloat(1))
+		}
+	case "round": // half away from zero, like math.Round
+		if frac.Abs(frac).Cmp(big.NewFloat(0.5)) >= 0 {
+			t.Add(t, big.NewFloat(float64(x.Sign())))
+		}
+	}
+	return z.Set(t)
+}
+
+func ratFunc(name string, z, x *big.Rat) *big.Rat {
+	switch name {
+	case "abs":
+		return z.Abs(x)
+	case "conj", "real":
+		return z.Set(x)
+	}
+	if x.IsInt() {
+		return z.Set(x)
+	}
+	i := new(big.Int).Quo(x.Num(), x.Denom()) // truncated toward zero
+	switch name {
+	case "floor":
+		if x.Sign() < 0 {
+			i.Sub(i, big.NewInt(1))
+		}
+	case "ceil":
+		if x.Sign() > 0 {
+			i.Add(i, big.NewInt(1))
+		}
+	case "round": // half away from zero, like math.Round
+		frac := new(big.Rat).Sub(x, new(big.Rat).SetInt(i))
+		if frac.Abs(frac).Cmp(big.NewRat(1, 2)) >= 0 {
+			i.Add(i, big.NewInt(int64(x.Sign())))
+		}
+	}
+	return z.SetInt(i)
+}
@@ -0,0 +1,140 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestEvalRat(t *testing.T) {
+	env := map[Var]*big.Rat{"x": big.NewRat(1, 3), "n": big.NewRat(12, 1)}
+	for _, test := range []struct {
+		input, want string // want is the result or the error
+	}{
+		{"0.1 + 0.2 == 0.3", "1/1"},
+		{"x * 3", "1/1"},
+		{"pow(1 + 0.06/n, n)", "4348632317396990233762642401/4096000000000000000000000000"},
+		{"pow(2, -3) + max(x, 0.3)", "11/24"},
+		{"round(2.5) + floor(-2.5) + ceil(x) + trunc(-x)", "1/1"},
+		{"f(a) = a*a; f(x) - f(0.5)", "-5/36"},
+		{"x > 0 ? 1/x : 0", "3/1"},
+		{"1 / (x - x)", "division by zero"},
+		{"pow(2, 0.5)", "pow of rationals requires an integer exponent"},
+		{"pow(2, 1e12)", "pow of rationals: result exceeds 1048576 bits"},
+		{"pow(-x, -1e12)", "pow of rationals: result exceeds 1048576 bits"},
+		{"pow(-1, 1e12 + 1) + pow(1, -1e18) + pow(0, 1e12)", "0/1"},
+		{"sqrt(x) + sin(x)", "sqrt is not defined over rational numbers (and 1 more errors)"},
+		{"frob(x)", `unknown function "frob"`},
+	} {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", test.input, err)
+			continue
+		}
+		var got string
+		if z, err := EvalRat(expr, env); err != nil {
+			got = err.Error()
+		} else {
+			got = z.String()
+		}
+		if got != test.want {
+			t.Errorf("EvalRat(%s) = %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestEvalBigFloat(t *testing.T) {
+	env := map[Var]*big.Float{"x": big.NewFloat(2)}
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"sqrt(x)", "1.41421356237309504880168872420969807856967187537694807317668"},
+		{"1 / 3", "0.333333333333333333333333333333333333333333333333333333333333"},
+		{"pow(x, 100)", "1267650600228229401496703205376"},
+		{"min(0.1, x) * 10 - 1", "0"},
+		{"(x - x) / (x - x)", "division of zero by zero or infinity by infinity"},
+		{"frob(x)", `unknown function "frob"`},
+	} {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", test.input, err)
+			continue
+		}
+		var got string
+		if z, err := EvalBigFloat(expr, env, 200); err != nil {
+			got = err.Error()
+		} else {
+			got = z.Text('g', 60)
+		}
+		if got != test.want {
+			t.Errorf("EvalBigFloat(%s) = %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestEvalComplex(t *testing.T) {
+	env := map[Var]complex128{"i": 1i, "pi": math.Pi, "z": 3 + 4i}
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"sqrt(-4)", "(0+2i)"},
+		{"exp(i * pi) + 1", "(0+1.22e-16i)"},
+		{"abs(z) + real(z) + imag(z)", "(12+0i)"},
+		{"conj(z) * z", "(25+0i)"},
+		{"phase(i) * 2 == pi", "(1+0i)"},
+		{"pow(z, 2)", "(-7+24i)"},
+		{"z != 0 ? 1 / z : 0", "(0.12-0.16i)"},
+		{"z < 1", "operator < is not defined on complex numbers"},
+		{"gamma(z)", "gamma is not defined over complex numbers"},
+		{"frob(z)", `unknown function "frob"`},
+		{"sqrt(z, 2)", "call to sqrt has 2 args, want 1"},
+	} {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", test.input, err)
+			continue
+		}
+		var got string
+		if z, err := EvalComplex(expr, env); err != nil {
+			got = err.Error()
+		} else {
+			got = fmt.Sprintf("%.3g", z)
+		}
+		if got != test.want {
+			t.Errorf("EvalComplex(%s) = %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestDomains(t *testing.T) {
+	for _, test := range []struct {
+		fn   string
+		want string
+	}{
+		{"sqrt", "real|complex|big.Float"},
+		{"floor", "real|big.Float|rational"},
+		{"pow", "real|complex|big.Float|rational"},
+		{"gamma", "real"},
+	} {
+		if got := Funcs.Lookup(test.fn).Domains().String(); got != test.want {
+			t.Errorf("%s: Domains() = %s, want %s", test.fn, got, test.want)
+		}
+	}
+
+	d, err := ParseDef("hyp(a, b) = sqrt(a*a + b*b)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := d.Func()
+	if got, want := f.Domains(), Real|Complex|BigFloat; got != want {
+		t.Errorf("%s: Domains() = %s, want %s", d, got, want)
+	}
+	z := f.BigFloat(new(big.Float).SetPrec(100), []*big.Float{big.NewFloat(3), big.NewFloat(4)})
+	if got := z.String(); got != "5" {
+		t.Errorf("%s: BigFloat(3, 4) = %s, want 5", d, got)
+	}
+}
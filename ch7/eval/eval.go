@@ -8,7 +8,7 @@ package eval
 
 import (
 	"fmt"
-	"math"
+	"math/big"
 )
 
 //!+env
@@ -17,6 +17,16 @@ type Env map[Var]float64
 
 //!-env
 
+// truth returns the representation of the boolean b: 1 for true
+// and 0 for false.  Any nonzero operand of !, &&, || or ?: is
+// treated as true.
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 //!+Eval1
 
 func (v Var) Eval(env Env) float64 {
@@ -33,38 +43,140 @@ func (l literal) Eval(_ Env) float64 {
 
 func (u unary) Eval(env Env) float64 {
 	switch u.op {
-	case '+':
+	case "+":
 		return +u.x.Eval(env)
-	case '-':
+	case "-":
 		return -u.x.Eval(env)
+	case "!":
+		return truth(u.x.Eval(env) == 0)
 	}
 	panic(fmt.Sprintf("unsupported unary operator: %q", u.op))
 }
 
 func (b binary) Eval(env Env) float64 {
 	switch b.op {
-	case '+':
+	case "+":
 		return b.x.Eval(env) + b.y.Eval(env)
-	case '-':
+	case "-":
 		return b.x.Eval(env) - b.y.Eval(env)
-	case '*':
+	case "*":
 		return b.x.Eval(env) * b.y.Eval(env)
-	case '/':
+	case "/":
 		return b.x.Eval(env) / b.y.Eval(env)
+	case "<":
+		return truth(b.x.Eval(env) < b.y.Eval(env))
+	case "<=":
+		return truth(b.x.Eval(env) <= b.y.Eval(env))
+	case ">":
+		return truth(b.x.Eval(env) > b.y.Eval(env))
+	case ">=":
+		return truth(b.x.Eval(env) >= b.y.Eval(env))
+	case "==":
+		return truth(b.x.Eval(env) == b.y.Eval(env))
+	case "!=":
+		return truth(b.x.Eval(env) != b.y.Eval(env))
+	case "&&":
+		return truth(b.x.Eval(env) != 0 && b.y.Eval(env) != 0)
+	case "||":
+		return truth(b.x.Eval(env) != 0 || b.y.Eval(env) != 0)
 	}
 	panic(fmt.Sprintf("unsupported binary operator: %q", b.op))
 }
 
+func (c conditional) Eval(env Env) float64 {
+	if c.cond.Eval(env) != 0 {
+		return c.x.Eval(env)
+	}
+	return c.y.Eval(env)
+}
+
 func (c call) Eval(env Env) float64 {
-	switch c.fn {
-	case "pow":
-		return math.Pow(c.args[0].Eval(env), c.args[1].Eval(env))
-	case "sin":
-		return math.Sin(c.args[0].Eval(env))
-	case "sqrt":
-		return math.Sqrt(c.args[0].Eval(env))
+	f := Funcs.lookup(c.fn)
+	if f == nil {
+		panic(fmt.Sprintf("unsupported function call: %s", c.fn))
+	}
+	args := make([]float64, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.Eval(env)
+	}
+	return f.Impl(args)
+}
+
+//!-Eval2
+
+func (l let) Eval(env Env) float64 {
+	inner := Env{l.v: l.x.Eval(env)}
+	for v, x := range env {
+		if v != l.v {
+			inner[v] = x
+		}
 	}
-	panic(fmt.Sprintf("unsupported function call: %s", c.fn))
+	return l.body.Eval(inner)
 }
 
-//!-Eval2
\ No newline at end of file
+func (b block) Eval(env Env) float64 {
+	return b.body.Eval(env)
+}
+
+func (a apply) Eval(env Env) float64 {
+	// The body of a function refers only to its parameters.
+	params := make(Env, len(a.args))
+	for i, arg := range a.args {
+		params[a.def.Params[i]] = arg.Eval(env)
+	}
+	return a.def.Body.Eval(params)
+}
+
+// Func returns a Func that evaluates the definition,
+// suitable for adding to a Registry.  The Func is defined over
+// every domain over which the functions called by the body are
+// defined at the time of the call to Func, provided that the body
+// is not too large once the calls within it are expanded.
+func (d *Def) Func() Func {
+	f := Func{Arity: len(d.Params), Impl: func(args []float64) float64 {
+		env := make(Env, len(args))
+		for i, arg := range args {
+			env[d.Params[i]] = arg
+		}
+		return d.Body.Eval(env)
+	}, def: d}
+	if checkSize(d.Body, true) != nil {
+		return f // too large to expand
+	}
+	body := expand(d.Body, nil)
+	if CheckDomain(body, Complex) == nil {
+		f.Complex = func(args []complex128) complex128 {
+			env := make(map[Var]complex128, len(args))
+			for i, arg := range args {
+				env[d.Params[i]] = arg
+			}
+			return evalComplex(body, env)
+		}
+	}
+	if CheckDomain(body, BigFloat) == nil {
+		f.BigFloat = func(z *big.Float, args []*big.Float) *big.Float {
+			env := make(map[Var]*big.Float, len(args))
+			for i, arg := range args {
+				env[d.Params[i]] = arg
+			}
+			return z.Set(evalBigFloat(body, env, z.Prec()))
+		}
+	}
+	if CheckDomain(body, Rational) == nil {
+		f.Rat = func(z *big.Rat, args []*big.Rat) *big.Rat {
+			env := make(map[Var]*big.Rat, len(args))
+			for i, arg := range args {
+				env[d.Params[i]] = arg
+			}
+			return z.Set(evalRat(body, env))
+		}
+	}
+	f.Bounds = func(args []Interval) Interval {
+		env := make(map[Var]Interval, len(args))
+		for i, arg := range args {
+			env[d.Params[i]] = arg
+		}
+		return evalInterval(d.Body, env)
+	}
+	return f
+}
\ No newline at end of file
@@ -6,6 +6,7 @@ package eval
 import (
 	"fmt"
 	"math"
+	"os"
 	"testing"
 )
 
@@ -26,6 +27,15 @@ func TestEval(t *testing.T) {
 		// additional tests that don't appear in the book
 		{"-1 + -x", Env{"x": 1}, "-2"},
 		{"-1 - x", Env{"x": 1}, "-2"},
+		{"x > 3 && y <= 2 ? a : b", Env{"x": 4, "y": 2, "a": 1, "b": 2}, "1"},
+		{"x > 3 && y <= 2 ? a : b", Env{"x": 3, "y": 2, "a": 1, "b": 2}, "2"},
+		{"x < 0 ? -1 : x == 0 ? 0 : 1", Env{"x": -5}, "-1"},
+		{"x < 0 ? -1 : x == 0 ? 0 : 1", Env{"x": 0}, "0"},
+		{"x < 0 ? -1 : x == 0 ? 0 : 1", Env{"x": 5}, "1"},
+		{"!x || y != 2", Env{"x": 1, "y": 2}, "0"},
+		{"1 + 2 >= 3 == 1", nil, "1"},
+		{"hypot(x, y) + abs(floor(-z))", Env{"x": 3, "y": 4, "z": 1.5}, "7"},
+		{"min(x, y, -1) * cos(0)", Env{"x": 3, "y": -4}, "-4"},
 		//!+Eval
 	}
 	var prevExpr string
@@ -79,9 +89,10 @@ func TestErrors(t *testing.T) {
 	for _, test := range []struct{ expr, wantErr string }{
 		{"x % 2", "unexpected '%'"},
 		{"math.Pi", "unexpected '.'"},
-		{"!true", "unexpected '!'"},
-		{`"hello"`, "unexpected '\"'"},
-		{"log(10)", `unknown function "log"`},
+		{"x & y", "unexpected '&'"},
+		{"x ? y", "got end of file, want ':'"},
+		{`"hello"`, "unexpected '\"' (and 1 more errors)"},
+		{"frob(10)", `unknown function "frob"`},
 		{"sqrt(1, 2)", "call to sqrt has 2 args, want 1"},
 	} {
 		expr, err := Parse(test.expr)
@@ -104,10 +115,89 @@ func TestErrors(t *testing.T) {
 //!+errors
 x % 2               unexpected '%'
 math.Pi             unexpected '.'
-!true               unexpected '!'
-"hello"             unexpected '"'
-
-log(10)             unknown function "log"
+x & y               unexpected '&'
+x ? y               got end of file, want ':'
+"hello"             unexpected '"' (and 1 more errors)
+frob(10)            unknown function "frob"
 sqrt(1, 2)          call to sqrt has 2 args, want 1
 //!-errors
-*/
\ No newline at end of file
+*/
+
+func TestRegister(t *testing.T) {
+	localFuncs(t).Register("clamp", Func{Arity: 3, Impl: func(args []float64) float64 {
+		return math.Max(args[1], math.Min(args[0], args[2]))
+	}})
+
+	expr, err := Parse("clamp(x, 0, 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expr.Check(map[Var]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range map[float64]float64{-2: 0, 0.5: 0.5, 3: 1} {
+		if got := expr.Eval(Env{"x": x}); got != want {
+			t.Errorf("clamp(%g, 0, 1) = %g, want %g", x, got, want)
+		}
+	}
+
+	expr, _ = Parse("clamp(x, 0)")
+	if err := expr.Check(map[Var]bool{}); err == nil ||
+		err.Error() != "call to clamp has 2 args, want 3" {
+		t.Errorf("Check(clamp(x, 0)) = %v", err)
+	}
+}
+
+func ExampleDump() {
+	expr, _ := Parse("let r = sqrt(x) in r > 1 ? r : -r")
+	Dump(os.Stdout, expr)
+	// Output:
+	// let r (1:1-1:34)
+	//   call sqrt (1:9-1:16)
+	//     Var x (1:14-1:15)
+	//   conditional (1:20-1:34)
+	//     binary > (1:20-1:25)
+	//       Var r (1:20-1:21)
+	//       literal 1
+	//     Var r (1:28-1:29)
+	//     unary - (1:32-1:34)
+	//       Var r (1:33-1:34)
+}
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry()
+	r.Register("twice", Func{Arity: 1, Impl: func(args []float64) float64 { return 2 * args[0] }})
+	r.Register("sum", Func{Arity: 1, Variadic: true, Impl: func(args []float64) float64 {
+		var sum float64
+		for _, arg := range args {
+			sum += arg
+		}
+		return sum
+	}})
+	if got := fmt.Sprint(r.Names()); got != "[sum twice]" {
+		t.Errorf("Names() = %s", got)
+	}
+	if r.Lookup("thrice") != nil {
+		t.Errorf("Lookup(thrice) != nil")
+	}
+
+	// Lookup returns a copy, which does not affect the registry.
+	f := r.Lookup("twice")
+	f.Arity = 2
+	f.Impl = nil
+	if g := r.Lookup("twice"); g.Arity != 1 || g.Impl([]float64{3}) != 6 {
+		t.Errorf("modifying the result of Lookup changed the registry")
+	}
+}
+
+// localFuncs replaces Funcs by a copy for the duration of the test,
+// so that the test may register functions without affecting others.
+func localFuncs(t *testing.T) *Registry {
+	saved := Funcs
+	Funcs = newRegistry()
+	for _, name := range saved.Names() {
+		Funcs.Register(name, *saved.Lookup(name))
+	}
+	t.Cleanup(func() { Funcs = saved })
+	return Funcs
+}
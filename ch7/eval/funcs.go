@@ -0,0 +1,190 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// A Func describes a function that may be called from an expression.
+type Func struct {
+	Arity    int  // number of parameters, or the minimum number if Variadic
+	Variadic bool // whether calls may supply more than Arity arguments
+	Impl     func(args []float64) float64
+
+	// Optional implementations over other domains; see Domains.
+	// BigFloat and Rat set z to the result and return it.
+	Complex  func(args []complex128) complex128
+	BigFloat func(z *big.Float, args []*big.Float) *big.Float
+	Rat      func(z *big.Rat, args []*big.Rat) *big.Rat
+
+	// Bounds, if non-nil, returns an interval containing every
+	// value of the function over the argument intervals; see EvalInterval.
+	Bounds func(args []Interval) Interval
+
+	def *Def // the definition from which Def.Func made the function, if any
+}
+
+// checkArgs reports an error if a call to the function named fn
+// with n arguments does not match its arity.
+func (f *Func) checkArgs(fn string, n int) error {
+	switch {
+	case f.Variadic && n < f.Arity:
+		return fmt.Errorf("call to %s has %d args, want at least %d",
+			fn, n, f.Arity)
+	case !f.Variadic && n != f.Arity:
+		return fmt.Errorf("call to %s has %d args, want %d",
+			fn, n, f.Arity)
+	}
+	return nil
+}
+
+// A Registry is a set of named functions, such as Funcs.
+// It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	funcs map[string]*Func
+}
+
+// newRegistry returns an empty Registry.
+func newRegistry() *Registry {
+	return &Registry{funcs: make(map[string]*Func)}
+}
+
+// Register adds the function f to the registry under the given name,
+// replacing any previous function of that name.
+func (r *Registry) Register(name string, f Func) {
+	if f.Impl == nil {
+		panic(fmt.Sprintf("eval: Register %s: nil Impl", name))
+	}
+	if f.Arity < 0 {
+		panic(fmt.Sprintf("eval: Register %s: negative arity", name))
+	}
+	r.mu.Lock()
+	r.funcs[name] = &f
+	r.mu.Unlock()
+}
+
+// Lookup returns a copy of the function registered under name, or nil.
+func (r *Registry) Lookup(name string) *Func {
+	f := r.lookup(name)
+	if f == nil {
+		return nil
+	}
+	g := *f
+	return &g
+}
+
+// lookup returns the function registered under name, or nil.
+// The result must not be modified.
+func (r *Registry) lookup(name string) *Func {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.funcs[name]
+}
+
+// Names returns the names of all registered functions in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.funcs))
+	for name := range r.funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Funcs is the registry consulted by Check and Eval.
+// It initially contains the functions of the math package;
+// callers may register their own.
+var Funcs = newRegistry()
+
+func unaryFunc(f func(float64) float64) Func {
+	return Func{Arity: 1, Impl: func(args []float64) float64 {
+		return f(args[0])
+	}}
+}
+
+func binaryFunc(f func(float64, float64) float64) Func {
+	return Func{Arity: 2, Impl: func(args []float64) float64 {
+		return f(args[0], args[1])
+	}}
+}
+
+// foldFunc returns a variadic function that combines its
+// arguments from left to right using f.
+func foldFunc(f func(float64, float64) float64) Func {
+	return Func{Arity: 1, Variadic: true, Impl: func(args []float64) float64 {
+		acc := args[0]
+		for _, arg := range args[1:] {
+			acc = f(acc, arg)
+		}
+		return acc
+	}}
+}
+
+func init() {
+	for name, f := range map[string]func(float64) float64{
+		"abs":   math.Abs,
+		"acos":  math.Acos,
+		"acosh": math.Acosh,
+		"asin":  math.Asin,
+		"asinh": math.Asinh,
+		"atan":  math.Atan,
+		"atanh": math.Atanh,
+		"cbrt":  math.Cbrt,
+		"ceil":  math.Ceil,
+		"conj":  func(x float64) float64 { return x },
+		"cos":   math.Cos,
+		"cosh":  math.Cosh,
+		"erf":   math.Erf,
+		"erfc":  math.Erfc,
+		"exp":   math.Exp,
+		"exp2":  math.Exp2,
+		"expm1": math.Expm1,
+		"floor": math.Floor,
+		"gamma": math.Gamma,
+		"imag":  func(x float64) float64 { return 0 },
+		"j0":    math.J0,
+		"j1":    math.J1,
+		"log":   math.Log,
+		"log10": math.Log10,
+		"log1p": math.Log1p,
+		"log2":  math.Log2,
+		"logb":  math.Logb,
+		"phase": func(x float64) float64 { return math.Atan2(0, x) },
+		"real":  func(x float64) float64 { return x },
+		"round": math.Round,
+		"sin":   math.Sin,
+		"sinh":  math.Sinh,
+		"sqrt":  math.Sqrt,
+		"tan":   math.Tan,
+		"tanh":  math.Tanh,
+		"trunc": math.Trunc,
+		"y0":    math.Y0,
+		"y1":    math.Y1,
+	} {
+		Funcs.Register(name, unaryFunc(f))
+	}
+	for name, f := range map[string]func(float64, float64) float64{
+		"atan2":     math.Atan2,
+		"copysign":  math.Copysign,
+		"dim":       math.Dim,
+		"hypot":     math.Hypot,
+		"mod":       math.Mod,
+		"pow":       math.Pow,
+		"remainder": math.Remainder,
+	} {
+		Funcs.Register(name, binaryFunc(f))
+	}
+	Funcs.Register("min", foldFunc(math.Min))
+	Funcs.Register("max", foldFunc(math.Max))
+	registerDomains()
+	registerBounds()
+}
@@ -0,0 +1,514 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"math"
+)
+
+// An Interval is a closed range of real numbers [Lo, Hi] that
+// contains every value an expression may take.  If NaN is set, the
+// expression may also be undefined (NaN) somewhere in the range of
+// its inputs.  An infinite bound means the expression may be
+// unbounded or infinite, as when dividing by a range containing zero.
+//
+// An interval whose bounds are NaN is empty: the expression is
+// undefined everywhere, as for sqrt of a negative range.
+type Interval struct {
+	Lo, Hi float64
+	NaN    bool
+}
+
+// Point returns the interval [x, x].
+func Point(x float64) Interval { return Interval{x, x, math.IsNaN(x)} }
+
+func (i Interval) String() string {
+	s := fmt.Sprintf("[%g, %g]", i.Lo, i.Hi)
+	if i.NaN && !math.IsNaN(i.Lo) {
+		s += " or NaN"
+	}
+	return s
+}
+
+// Contains reports whether x is a possible value of the interval.
+func (i Interval) Contains(x float64) bool {
+	if math.IsNaN(x) {
+		return i.NaN
+	}
+	return i.Lo <= x && x <= i.Hi
+}
+
+// Finite reports whether every value of the interval
+// is a finite number.
+func (i Interval) Finite() bool {
+	return !i.NaN && !math.IsInf(i.Lo, 0) && !math.IsInf(i.Hi, 0)
+}
+
+var (
+	empty = Interval{math.NaN(), math.NaN(), true}
+	whole = Interval{math.Inf(-1), math.Inf(+1), true} // any value at all
+)
+
+// widen rounds the bounds outward by n units in the last place,
+// to allow for rounding error in the computation of each bound.
+func (i Interval) widen(n int) Interval {
+	for ; n > 0; n-- {
+		i.Lo = math.Nextafter(i.Lo, math.Inf(-1))
+		i.Hi = math.Nextafter(i.Hi, math.Inf(+1))
+	}
+	return i
+}
+
+func (i Interval) isEmpty() bool { return math.IsNaN(i.Lo) }
+
+// canBe reports whether x is a possible value of the interval.
+func (i Interval) canBe(x float64) bool { return i.Lo <= x && x <= i.Hi }
+
+// hull returns the smallest interval containing x and y.
+func hull(x, y Interval) Interval {
+	switch {
+	case x.isEmpty():
+		return Interval{y.Lo, y.Hi, true}
+	case y.isEmpty():
+		return Interval{x.Lo, x.Hi, true}
+	}
+	return Interval{math.Min(x.Lo, y.Lo), math.Max(x.Hi, y.Hi), x.NaN || y.NaN}
+}
+
+// EvalInterval returns bounds on the value of e when each variable
+// may take any value within its interval in env.  Variables absent
+// from env are zero.
+//
+// The bounds are guaranteed but not always tight: each occurrence
+// of a variable is treated independently, so x - x over [0, 1] is
+// [-1, 1], and calls to functions without a Bounds method are
+// assumed to take any value, or NaN.
+func EvalInterval(e Expr, env map[Var]Interval) (Interval, error) {
+	if err := e.Check(map[Var]bool{}); err != nil {
+		return Interval{}, err
+	}
+	return evalInterval(e, env), nil
+}
+
+func evalInterval(e Expr, env map[Var]Interval) Interval {
+	switch e := e.(type) {
+	case Var:
+		if x, ok := env[e]; ok {
+			return x
+		}
+		return Point(0)
+
+	case varRef:
+		return evalInterval(e.Var, env)
+
+	case literal:
+		return Point(float64(e))
+
+	case unary:
+		x := evalInterval(e.x, env)
+		switch e.op {
+		case "+":
+			return x
+		case "-":
+			return negInterval(x)
+		case "!":
+			return notInterval(x)
+		}
+		panic(fmt.Sprintf("unsupported unary operator: %q", e.op))
+
+	case binary:
+		x, y := evalInterval(e.x, env), evalInterval(e.y, env)
+		switch e.op {
+		case "+":
+			return addInterval(x, y)
+		case "-":
+			return addInterval(x, negInterval(y))
+		case "*":
+			return mulInterval(x, y)
+		case "/":
+			return divInterval(x, y)
+		case "&&":
+			return andInterval(x, y)
+		case "||":
+			return notInterval(andInterval(notInterval(x), notInterval(y)))
+		}
+		if precedence(e.op) > 0 {
+			return compareInterval(e.op, x, y)
+		}
+		panic(fmt.Sprintf("unsupported binary operator: %q", e.op))
+
+	case conditional:
+		c := evalInterval(e.cond, env)
+		switch {
+		case !c.canBe(0): // always true, since NaN is true
+			return evalInterval(e.x, env)
+		case c.Lo == 0 && c.Hi == 0 && !c.NaN: // always false
+			return evalInterval(e.y, env)
+		}
+		x, y := evalInterval(e.x, env), evalInterval(e.y, env)
+		return hull(x, y)
+
+	case call:
+		args := make([]Interval, len(e.args))
+		for i, arg := range e.args {
+			args[i] = evalInterval(arg, env)
+		}
+		f := Funcs.lookup(e.fn)
+		if f == nil {
+			panic(fmt.Sprintf("unsupported function call: %s", e.fn))
+		}
+		if f.Bounds == nil {
+			return whole
+		}
+		return f.Bounds(args)
+
+	case let:
+		inner := map[Var]Interval{e.v: evalInterval(e.x, env)}
+		for v, x := range env {
+			if v != e.v {
+				inner[v] = x
+			}
+		}
+		return evalInterval(e.body, inner)
+
+	case block:
+		return evalInterval(e.body, env)
+
+	case apply:
+		// The body of a function refers only to its parameters.
+		params := make(map[Var]Interval, len(e.args))
+		for i, arg := range e.args {
+			params[e.def.Params[i]] = evalInterval(arg, env)
+		}
+		return evalInterval(e.def.Body, params)
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
+
+// ---- operators ----
+
+func negInterval(x Interval) Interval { return Interval{-x.Hi, -x.Lo, x.NaN} }
+
+func addInterval(x, y Interval) Interval {
+	if x.isEmpty() || y.isEmpty() {
+		return empty
+	}
+	// ∞ + -∞ is NaN; it does not contribute to the bounds,
+	// but a bound that is NaN could be any value.
+	nan := x.NaN || y.NaN ||
+		x.canBe(math.Inf(+1)) && y.canBe(math.Inf(-1)) ||
+		x.canBe(math.Inf(-1)) && y.canBe(math.Inf(+1))
+	r := Interval{x.Lo + y.Lo, x.Hi + y.Hi, nan}
+	if math.IsNaN(r.Lo) {
+		r.Lo = math.Inf(-1)
+	}
+	if math.IsNaN(r.Hi) {
+		r.Hi = math.Inf(+1)
+	}
+	return r.widen(1)
+}
+
+func mulInterval(x, y Interval) Interval {
+	if x.isEmpty() || y.isEmpty() {
+		return empty
+	}
+	// 0 × ∞ is NaN; it does not contribute to the bounds.
+	nan := x.NaN || y.NaN ||
+		x.canBe(0) && (math.IsInf(y.Lo, 0) || math.IsInf(y.Hi, 0)) ||
+		y.canBe(0) && (math.IsInf(x.Lo, 0) || math.IsInf(x.Hi, 0))
+	r := Interval{math.Inf(+1), math.Inf(-1), nan}
+	for _, p := range []float64{x.Lo * y.Lo, x.Lo * y.Hi, x.Hi * y.Lo, x.Hi * y.Hi} {
+		if math.IsNaN(p) {
+			p = 0
+		}
+		r.Lo, r.Hi = math.Min(r.Lo, p), math.Max(r.Hi, p)
+	}
+	if x.canBe(0) || y.canBe(0) {
+		r.Lo, r.Hi = math.Min(r.Lo, 0), math.Max(r.Hi, 0)
+	}
+	return r.widen(1)
+}
+
+func divInterval(x, y Interval) Interval {
+	if x.isEmpty() || y.isEmpty() {
+		return empty
+	}
+	if y.canBe(0) {
+		// Division by zero yields ±∞, or NaN for 0/0.
+		return Interval{math.Inf(-1), math.Inf(+1), x.NaN || y.NaN || x.canBe(0)}
+	}
+	// 1/y is monotone decreasing on an interval excluding zero.
+	inv := Interval{1 / y.Hi, 1 / y.Lo, y.NaN}.widen(1)
+	return mulInterval(x, inv)
+}
+
+// truthInterval returns the interval of possible truth values.
+func truthInterval(canBeFalse, canBeTrue bool) Interval {
+	return Interval{truth(!canBeFalse), truth(canBeTrue), false}
+}
+
+// notInterval returns the interval of !x.  Any nonzero value,
+// including NaN, is true.
+func notInterval(x Interval) Interval {
+	return truthInterval(x.NaN || x.Lo != 0 || x.Hi != 0, x.canBe(0))
+}
+
+func andInterval(x, y Interval) Interval {
+	xfalse, yfalse := x.canBe(0), y.canBe(0)
+	xtrue := x.NaN || x.Lo != 0 || x.Hi != 0
+	ytrue := y.NaN || y.Lo != 0 || y.Hi != 0
+	return truthInterval(xfalse || yfalse, xtrue && ytrue)
+}
+
+func compareInterval(op string, x, y Interval) Interval {
+	if x.isEmpty() || y.isEmpty() {
+		// Every comparison with NaN is false, except !=.
+		return truthInterval(op != "!=", op == "!=")
+	}
+	var lt, eq, gt bool // possible outcomes of comparing x and y
+	lt = x.Lo < y.Hi
+	gt = x.Hi > y.Lo
+	eq = x.Lo <= y.Hi && y.Lo <= x.Hi
+	var canBeTrue, canBeFalse bool
+	switch op {
+	case "<":
+		canBeTrue, canBeFalse = lt, eq || gt
+	case "<=":
+		canBeTrue, canBeFalse = lt || eq, gt
+	case ">":
+		canBeTrue, canBeFalse = gt, lt || eq
+	case ">=":
+		canBeTrue, canBeFalse = gt || eq, lt
+	case "==":
+		canBeTrue, canBeFalse = eq, lt || gt
+	case "!=":
+		canBeTrue, canBeFalse = lt || gt, eq
+	}
+	if x.NaN || y.NaN {
+		if op == "!=" {
+			canBeTrue = true
+		} else {
+			canBeFalse = true
+		}
+	}
+	return truthInterval(canBeFalse, canBeTrue)
+}
+
+// ---- functions ----
+
+// registerBounds extends the functions registered by init
+// with their Bounds methods.
+func registerBounds() {
+	inf := math.Inf(+1)
+	for name, f := range map[string]func([]Interval) Interval{
+		"acos":  monotone(math.Acos, -1, 1, true, 2),
+		"acosh": monotone(math.Acosh, 1, inf, false, 2),
+		"asin":  monotone(math.Asin, -1, 1, false, 2),
+		"asinh": monotone(math.Asinh, -inf, inf, false, 2),
+		"atan":  monotone(math.Atan, -inf, inf, false, 2),
+		"atanh": monotone(math.Atanh, -1, 1, false, 2),
+		"cbrt":  monotone(math.Cbrt, -inf, inf, false, 2),
+		"ceil":  monotone(math.Ceil, -inf, inf, false, 0),
+		"conj":  monotone(func(x float64) float64 { return x }, -inf, inf, false, 0),
+		"erf":   monotone(math.Erf, -inf, inf, false, 2),
+		"erfc":  monotone(math.Erfc, -inf, inf, true, 2),
+		"exp":   monotone(math.Exp, -inf, inf, false, 2),
+		"exp2":  monotone(math.Exp2, -inf, inf, false, 2),
+		"expm1": monotone(math.Expm1, -inf, inf, false, 2),
+		"floor": monotone(math.Floor, -inf, inf, false, 0),
+		"log":   monotone(math.Log, 0, inf, false, 2),
+		"log10": monotone(math.Log10, 0, inf, false, 2),
+		"log1p": monotone(math.Log1p, -1, inf, false, 2),
+		"log2":  monotone(math.Log2, 0, inf, false, 2),
+		"real":  monotone(func(x float64) float64 { return x }, -inf, inf, false, 0),
+		"round": monotone(math.Round, -inf, inf, false, 0),
+		"sinh":  monotone(math.Sinh, -inf, inf, false, 2),
+		"sqrt":  monotone(math.Sqrt, 0, inf, false, 1),
+		"tanh":  monotone(math.Tanh, -inf, inf, false, 2),
+		"trunc": monotone(math.Trunc, -inf, inf, false, 0),
+
+		"abs":  even(math.Abs, 0),
+		"cosh": even(math.Cosh, 2),
+
+		"sin": sinusoid(math.Sin, math.Pi/2),
+		"cos": sinusoid(math.Cos, 0),
+		"tan": tanBounds,
+
+		"imag":  func(args []Interval) Interval { return Interval{0, 0, args[0].NaN} },
+		"phase": phaseBounds,
+		"atan2": atan2Bounds,
+		"dim":   dimBounds,
+		"hypot": hypotBounds,
+		"pow":   powBounds,
+		"max":   extremeBounds(math.Max),
+		"min":   extremeBounds(math.Min),
+	} {
+		f := f
+		extend(name, func(fn *Func) { fn.Bounds = f })
+	}
+}
+
+// monotone returns the Bounds method of a function f that is
+// defined over [dlo, dhi] and is monotone increasing, or decreasing
+// if decr is set, with an error of at most ulps units in the last place.
+func monotone(f func(float64) float64, dlo, dhi float64, decr bool, ulps int) func([]Interval) Interval {
+	return func(args []Interval) Interval {
+		x := args[0]
+		lo, hi := math.Max(x.Lo, dlo), math.Min(x.Hi, dhi)
+		if !(lo <= hi) {
+			return empty // undefined everywhere
+		}
+		r := Interval{f(lo), f(hi), x.NaN || x.Lo < dlo || x.Hi > dhi}
+		if decr {
+			r.Lo, r.Hi = r.Hi, r.Lo
+		}
+		return r.widen(ulps)
+	}
+}
+
+// even returns the Bounds method of a function f that is symmetric
+// about zero and increasing over [0, ∞].
+func even(f func(float64) float64, ulps int) func([]Interval) Interval {
+	return func(args []Interval) Interval {
+		x := args[0]
+		if x.isEmpty() {
+			return empty
+		}
+		lo, hi := f(x.Lo), f(x.Hi)
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if x.canBe(0) {
+			lo = f(0)
+		}
+		return Interval{lo, hi, x.NaN}.widen(ulps)
+	}
+}
+
+// sinusoid returns the Bounds method of a function f of period 2π
+// with its maxima of 1 at peak + 2kπ and its minima of -1 at peak + π + 2kπ.
+func sinusoid(f func(float64) float64, peak float64) func([]Interval) Interval {
+	return func(args []Interval) Interval {
+		x := args[0]
+		if x.isEmpty() {
+			return empty
+		}
+		// sin(±∞) is NaN.
+		nan := x.NaN || math.IsInf(x.Lo, 0) || math.IsInf(x.Hi, 0)
+		if x.Hi-x.Lo >= 2*math.Pi || nan {
+			return Interval{-1, 1, nan}
+		}
+		r := Interval{math.Min(f(x.Lo), f(x.Hi)), math.Max(f(x.Lo), f(x.Hi)), false}.widen(2)
+		// Is there an extremum within the interval?
+		if k := math.Ceil((x.Lo - peak) / (2 * math.Pi)); peak+2*math.Pi*k <= x.Hi+1e-9 {
+			r.Hi = 1
+		}
+		if k := math.Ceil((x.Lo - peak - math.Pi) / (2 * math.Pi)); peak+math.Pi+2*math.Pi*k <= x.Hi+1e-9 {
+			r.Lo = -1
+		}
+		r.Lo, r.Hi = math.Max(r.Lo, -1), math.Min(r.Hi, 1)
+		return r
+	}
+}
+
+func tanBounds(args []Interval) Interval {
+	x := args[0]
+	if x.isEmpty() {
+		return empty
+	}
+	nan := x.NaN || math.IsInf(x.Lo, 0) || math.IsInf(x.Hi, 0)
+	// tan has a pole at π/2 + kπ.
+	if k := math.Ceil((x.Lo - math.Pi/2) / math.Pi); nan || math.Pi/2+math.Pi*k <= x.Hi+1e-9 {
+		return Interval{math.Inf(-1), math.Inf(+1), nan}
+	}
+	return Interval{math.Tan(x.Lo), math.Tan(x.Hi), false}.widen(2)
+}
+
+func phaseBounds(args []Interval) Interval {
+	x := args[0]
+	if x.isEmpty() {
+		return empty
+	}
+	// phase(-0) is π, and the bounds do not record the sign of zero.
+	r := Interval{0, 0, x.NaN}
+	if x.Lo <= 0 {
+		r.Hi = math.Pi
+		if x.Hi < 0 {
+			r.Lo = math.Pi
+		}
+	}
+	return r
+}
+
+func atan2Bounds(args []Interval) Interval {
+	if args[0].isEmpty() || args[1].isEmpty() {
+		return empty
+	}
+	return Interval{-math.Pi, math.Pi, args[0].NaN || args[1].NaN}.widen(1)
+}
+
+// dimBounds returns the bounds of dim(x, y) = max(x - y, 0).
+func dimBounds(args []Interval) Interval {
+	d := addInterval(args[0], negInterval(args[1]))
+	return Interval{math.Max(d.Lo, 0), math.Max(d.Hi, 0), d.NaN}
+}
+
+func hypotBounds(args []Interval) Interval {
+	abs := even(math.Abs, 0)
+	x, y := abs(args[:1]), abs(args[1:])
+	if x.isEmpty() || y.isEmpty() {
+		return empty
+	}
+	return Interval{math.Hypot(x.Lo, y.Lo), math.Hypot(x.Hi, y.Hi), x.NaN || y.NaN}.widen(1)
+}
+
+func powBounds(args []Interval) Interval {
+	x, y := args[0], args[1]
+	// pow(x, ±0) and pow(1, y) are 1, even if the other argument is NaN.
+	one := y.canBe(0) || x.canBe(1)
+	if x.isEmpty() || y.isEmpty() {
+		if x.isEmpty() && y.canBe(0) || y.isEmpty() && x.canBe(1) {
+			return Interval{1, 1, true}
+		}
+		return empty
+	}
+	if n := y.Lo; n == y.Hi && n == math.Trunc(n) && math.Abs(n) < 1<<53 && !y.NaN {
+		// Integer power: even or odd.
+		var r Interval
+		if math.Mod(n, 2) == 0 {
+			r = even(func(x float64) float64 { return math.Pow(x, math.Abs(n)) }, 2)(args[:1])
+		} else {
+			r = Interval{math.Pow(x.Lo, math.Abs(n)), math.Pow(x.Hi, math.Abs(n)), x.NaN}.widen(2)
+		}
+		if n < 0 {
+			r = divInterval(Point(1), r)
+		}
+		return r
+	}
+	if x.Lo >= 0 {
+		// pow(x, y) = exp(y log x)
+		log, exp := monotone(math.Log, 0, math.Inf(+1), false, 2), monotone(math.Exp, math.Inf(-1), math.Inf(+1), false, 2)
+		r := exp([]Interval{mulInterval(y, log(args[:1]))})
+		// pow(0, 0) and pow(1, ∞) are 1, not the NaN of 0 × ∞.
+		r.NaN = x.NaN || y.NaN
+		if one {
+			r = hull(r, Interval{1, 1, r.NaN})
+		}
+		return r
+	}
+	return whole // a negative base to a non-integer power is NaN
+}
+
+// extremeBounds returns the Bounds method of min or max.
+func extremeBounds(f func(x, y float64) float64) func([]Interval) Interval {
+	return func(args []Interval) Interval {
+		r := args[0]
+		for _, x := range args[1:] {
+			// math.Min and math.Max return NaN if either argument is NaN.
+			r = Interval{f(r.Lo, x.Lo), f(r.Hi, x.Hi), r.NaN || x.NaN}
+		}
+		return r
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+// TestIntervalContains checks that the bounds of each expression
+// contain its value at every point of a grid over the input ranges.
+func TestIntervalContains(t *testing.T) {
+	env := map[Var]Interval{
+		"x": {-2, 3, false},
+		"y": {0.5, 4, false},
+	}
+	for _, input := range []string{
+		benchExpr,
+		"x*x - 2*x*y + y/x",
+		"-x / (y - 1)",
+		"!x || x >= y && x != 1",
+		"x < y ? x : y == 2",
+		"sin(x) + cos(y) * tan(x / 2)",
+		"exp(x) - log(y) + sqrt(x) + acos(x / 3) + asin(y)",
+		"pow(x, 3) + pow(x, -2) + pow(y, x) + pow(y, 0.5) + pow(x, y)",
+		"abs(x) * cosh(y) + atan2(x, y) + hypot(x, y) + dim(x, y)",
+		"floor(x * 1.5) + round(y) + trunc(-y) + ceil(x)",
+		"max(x, y, 1) - min(x, y) + phase(x) + atan(y) + tanh(x)",
+		"gamma(y) + j0(x)",
+		"let r = sqrt(x*x + y*y) in sin(r) / r",
+		"f(a) = a * a; f(x) - f(y)",
+		"phase(-(hypot(y, x) < 0)) + phase(x * 0)",
+		"pow(1, log(x - 5)) + pow(sqrt(x - 5), 0)",
+		"pow(y - x + 1, 1 / (x - x)) + pow(x / x, y)",
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", input, err)
+			continue
+		}
+		bounds, err := EvalInterval(expr, env)
+		if err != nil {
+			t.Errorf("EvalInterval(%s): %v", input, err)
+			continue
+		}
+		const n = 40
+		for i := 0; i <= n; i++ {
+			for j := 0; j <= n; j++ {
+				x := env["x"].Lo + (env["x"].Hi-env["x"].Lo)*float64(i)/n
+				y := env["y"].Lo + (env["y"].Hi-env["y"].Lo)*float64(j)/n
+				if v := expr.Eval(Env{"x": x, "y": y}); !bounds.Contains(v) {
+					t.Errorf("%s at x=%g, y=%g = %g, not in %s", input, x, y, v, bounds)
+					goto next
+				}
+			}
+		}
+	next:
+	}
+}
+
+func TestInterval(t *testing.T) {
+	env := map[Var]Interval{
+		"x": {-1, 1, false},
+		"y": {2, 4, false},
+		"z": {math.Inf(+1), math.Inf(+1), false},
+	}
+	for _, test := range []struct {
+		input      string
+		lo, hi     float64 // bounds, approximately
+		nan, exact bool    // NaN flag; whether bounds are exact
+	}{
+		{"1 / x", math.Inf(-1), math.Inf(+1), false, true},
+		{"x / x", math.Inf(-1), math.Inf(+1), true, true},
+		{"1 / y", 0.25, 0.5, false, false},
+		{"1 / (pow(x, 2) + 1)", 0.5, 1, false, false},
+		{"pow(x, 2) + y", 2, 5, false, false},
+		{"sqrt(x)", 0, 1, true, false},
+		{"log(x - 2)", math.NaN(), math.NaN(), true, true},
+		{"x > -2 ? y : 1 / x", 2, 4, false, true},
+		{"x - x", -2, 2, false, false}, // not 0: each x varies independently
+		{"x < 0", 0, 1, false, true},
+		{"y >= 2 && !(x > 1)", 1, 1, false, true},
+		{"sin(x * 3)", -1, 1, false, true},
+		{"sin(y)", -0.7568, 0.9093, false, false},
+		{"tan(y)", -2.185, 1.1578, false, false},
+		{"tan(x * 2)", math.Inf(-1), math.Inf(+1), false, true},
+		{"gamma(x)", math.Inf(-1), math.Inf(+1), true, true},
+		{"-1 / (x + 1) + z", math.Inf(-1), math.Inf(+1), true, true}, // -∞ + ∞
+		{"z - z", math.Inf(-1), math.Inf(+1), true, true},
+	} {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", test.input, err)
+			continue
+		}
+		got, err := EvalInterval(expr, env)
+		if err != nil {
+			t.Errorf("EvalInterval(%s): %v", test.input, err)
+			continue
+		}
+		near := func(x, y float64) bool {
+			if test.exact || math.IsInf(y, 0) || math.IsNaN(y) {
+				return x == y || math.IsNaN(x) && math.IsNaN(y)
+			}
+			return math.Abs(x-y) < 1e-4
+		}
+		if !near(got.Lo, test.lo) || !near(got.Hi, test.hi) || got.NaN != test.nan {
+			t.Errorf("EvalInterval(%s) = %s (NaN=%t), want [%g, %g] (NaN=%t)",
+				test.input, got, got.NaN, test.lo, test.hi, test.nan)
+		}
+	}
+}
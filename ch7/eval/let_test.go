@@ -0,0 +1,189 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLet(t *testing.T) {
+	env := Env{"x": 3, "y": 4, "r": 100}
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"let r = sqrt(x*x + y*y) in sin(r)/r", "-0.191785"},
+		{"let r = 2 in let r = r * r in r + 1", "5"},
+		{"(let r = x in r) + r", "103"},
+		{"f(a, b) = a*a + b; f(x, y)", "13"},
+		{"f(a, b) = a*a + b; g(x) = f(x, 1) * 2; g(y) - f(1, 1)", "32"},
+		{"sq(x) = x * x; let x = 2 in sq(x + 1)", "9"},
+	} {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", test.input, err)
+			continue
+		}
+		if err := expr.Check(map[Var]bool{}); err != nil {
+			t.Errorf("Check(%s): %v", test.input, err)
+			continue
+		}
+		got := fmt.Sprintf("%.6g", expr.Eval(env))
+		if got != test.want {
+			t.Errorf("%s => %s, want %s", test.input, got, test.want)
+		}
+
+		// The printed form must parse to an equivalent expression.
+		expr2, err := Parse(Format(expr))
+		if err != nil {
+			t.Errorf("Parse(Format(%s)): %v", test.input, err)
+		} else if got := fmt.Sprintf("%.6g", expr2.Eval(env)); got != test.want {
+			t.Errorf("Format(%s) = %s => %s, want %s", test.input, Format(expr), got, test.want)
+		}
+
+		// Compile, Simplify and Derive must agree with Eval.
+		prog, err := Compile(expr)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", test.input, err)
+		} else if got := fmt.Sprintf("%.6g", prog.Eval(prog.Slots(env))); got != test.want {
+			t.Errorf("compiled %s => %s, want %s", test.input, got, test.want)
+		}
+		if got := fmt.Sprintf("%.6g", Simplify(expr).Eval(env)); got != test.want {
+			t.Errorf("Simplify(%s) => %s, want %s", test.input, got, test.want)
+		}
+		const h = 1e-6
+		deriv, err := Derive(expr, "x")
+		if err != nil {
+			t.Errorf("Derive(%s, x): %v", test.input, err)
+			continue
+		}
+		d := deriv.Eval(env)
+		env["x"] += h
+		hi := expr.Eval(env)
+		env["x"] -= 2 * h
+		lo := expr.Eval(env)
+		env["x"] += h
+		if want := (hi - lo) / (2 * h); math.Abs(d-want) > 1e-4 {
+			t.Errorf("Derive(%s, x) = %g, want %g", test.input, d, want)
+		}
+	}
+}
+
+func TestLetErrors(t *testing.T) {
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"let = 1 in x", "1:5: got '=', want identifier"},
+		{"let r = 1 r", "1:11: got identifier r, want in"},
+		{"f(a, 1) = a; f(x)", "1:9: parameter of f is not a name"},
+		{"x = 3", "1:3: left side of = is not a function name and parameters"},
+		{"f(a) = a + b; f(1)", "1:12: undefined variable b in definition of f"},
+		{"f(a) = a; f(1, 2)", "1:11: call to f has 2 args, want 1"},
+		{"f(n) = n < 1 ? 1 : n * f(n - 1); f(3)", "1:24: recursive call to f"},
+		{"f(a, a) = a; f(1, 2)", "1:1: duplicate parameter a in definition of f"},
+	} {
+		expr, err := Parse(test.input)
+		if err == nil {
+			err = Check(expr, nil)
+		}
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("%s: got error %v, want ErrorList", test.input, err)
+			continue
+		}
+		if got := fmt.Sprintf("%s: %s", list[0].Span.Start, list[0]); got != test.want {
+			t.Errorf("%s: got error %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseDef(t *testing.T) {
+	d, err := ParseDef("hyp(a, b) = sqrt(a*a + b*b)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.String(), "hyp(a, b) = sqrt(((a * a) + (b * b)))"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+	localFuncs(t).Register(d.Name, d.Func())
+	expr, err := Parse("hyp(x, 4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := expr.Eval(Env{"x": 3}); got != 5 {
+		t.Errorf("hyp(3, 4) = %g, want 5", got)
+	}
+
+	d, err = ParseDef("fact(n) = n < 1 ? 1 : n * fact(n - 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Check(); err == nil || err.Error() != "recursive call to fact" {
+		t.Errorf("Check(fact) = %v, want recursive call error", err)
+	}
+
+	// Redefining a registered function must not close a cycle.
+	funcs := localFuncs(t)
+	for _, def := range []string{"f(a) = a", "g(a) = f(a) + 1"} {
+		d, err := ParseDef(def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		funcs.Register(d.Name, d.Func())
+	}
+	d, err = ParseDef("f(a) = g(a)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Check(); err == nil || err.Error() != "recursive call to g" {
+		t.Errorf("Check(f) = %v, want recursive call error", err)
+	}
+}
+
+func TestExpansionLimit(t *testing.T) {
+	// Each function calls the previous one twice, so inlining f40
+	// would yield 2**40 copies of the body of f0.
+	var calls, lets strings.Builder
+	calls.WriteString("f0(a) = a + 1; ")
+	for i := 1; i <= 40; i++ {
+		fmt.Fprintf(&calls, "f%d(a) = f%d(a) * f%d(a); ", i, i-1, i-1)
+		fmt.Fprintf(&lets, "let x = x * x in ")
+	}
+	calls.WriteString("f40(x)")
+	lets.WriteString("x")
+
+	expr, err := Parse(calls.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Compile(expr); err == nil {
+		t.Error("Compile of doubling calls succeeded")
+	}
+	if _, err := EvalBatch(expr, nil, 1); err == nil {
+		t.Error("EvalBatch of doubling calls succeeded")
+	}
+	if _, err := EvalComplex(expr, nil); err == nil {
+		t.Error("EvalComplex of doubling calls succeeded")
+	}
+
+	// Compile holds each let-bound value in a temporary,
+	// but EvalComplex substitutes it for each occurrence.
+	expr, err = Parse(lets.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog, err := Compile(expr); err != nil {
+		t.Errorf("Compile of nested lets: %v", err)
+	} else if got := prog.Eval(prog.Slots(Env{"x": 1})); got != 1 {
+		t.Errorf("nested lets => %g, want 1", got)
+	}
+	if _, err := EvalComplex(expr, nil); err == nil {
+		t.Error("EvalComplex of nested lets succeeded")
+	}
+}
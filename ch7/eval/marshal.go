@@ -0,0 +1,683 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"unicode"
+)
+
+// A Tree holds an Expr so that it may be encoded and decoded, for
+// example as a field of a struct stored in a database.  Source
+// positions are not recorded.  Decoding does not check the result;
+// call Check before evaluating it.
+//
+// In JSON form, a variable is a string, a number is a number, and
+// every other expression is an object:
+//
+//	-x                     {"op":"-","args":["x"]}
+//	x + 1                  {"op":"+","args":["x",1]}
+//	c ? x : y              {"op":"?:","args":["c","x","y"]}
+//	sin(x)                 {"call":"sin","args":["x"]}
+//	let r = x in r*r       {"let":"r","value":"x","body":{"op":"*","args":["r","r"]}}
+//	f(a) = a*a; f(x)       {"defs":[{"name":"f","params":["a"],"body":...}],"body":{"call":"f","args":["x"]}}
+//
+// In S-expression form, which package ch12/sexpr can read, a variable
+// is a symbol, a number is a number, and every other expression is a
+// list whose head is either a string, for an operator or keyword, or
+// a symbol, for a function call.  A variable named t or nil, which
+// package sexpr would read as true or as nil, or whose name is not an
+// identifier, is written as a string.
+//
+//	-x                     ("-" x)
+//	x + 1                  ("+" x 1)
+//	c ? x : y              ("?:" c x y)
+//	sin(x)                 (sin x)
+//	let r = x in r*r       ("let" r x ("*" r r))
+//	f(a) = a*a; f(x)       ("block" ((f (a) ("*" a a))) (f x))
+//	let t = 1 in t         ("let" "t" 1 "t")
+//
+// As in Parse, a call refers to the innermost definition of that
+// name in an enclosing block, if any, or else to a function in Funcs.
+type Tree struct {
+	Expr Expr
+}
+
+func (t Tree) MarshalJSON() ([]byte, error) {
+	if t.Expr == nil {
+		return []byte("null"), nil
+	}
+	return marshalJSON(t.Expr)
+}
+
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v == nil {
+		t.Expr = nil
+		return nil
+	}
+	e, err := fromJSON(v, nil)
+	if err != nil {
+		return fmt.Errorf("eval: %v", err)
+	}
+	t.Expr = e
+	return nil
+}
+
+func (t Tree) MarshalSexpr() ([]byte, error) {
+	if t.Expr == nil {
+		return []byte("nil"), nil
+	}
+	return marshalSexpr(t.Expr)
+}
+
+func (t *Tree) UnmarshalSexpr(data []byte) error {
+	r := &sexprReader{}
+	r.scan.Init(bytes.NewReader(data))
+	r.scan.Mode = scanner.GoTokens
+	r.scan.Error = func(s *scanner.Scanner, msg string) { r.errorf("%s", msg) }
+	e, err := r.readTop()
+	if err != nil {
+		return fmt.Errorf("eval: %v", err)
+	}
+	t.Expr = e
+	return nil
+}
+
+// The MarshalJSON and MarshalSexpr methods of each node
+// allow an Expr to be encoded directly.
+
+func (v Var) MarshalJSON() ([]byte, error)         { return marshalJSON(v) }
+func (l literal) MarshalJSON() ([]byte, error)     { return marshalJSON(l) }
+func (u unary) MarshalJSON() ([]byte, error)       { return marshalJSON(u) }
+func (b binary) MarshalJSON() ([]byte, error)      { return marshalJSON(b) }
+func (c conditional) MarshalJSON() ([]byte, error) { return marshalJSON(c) }
+func (c call) MarshalJSON() ([]byte, error)        { return marshalJSON(c) }
+func (l let) MarshalJSON() ([]byte, error)         { return marshalJSON(l) }
+func (b block) MarshalJSON() ([]byte, error)       { return marshalJSON(b) }
+func (a apply) MarshalJSON() ([]byte, error)       { return marshalJSON(a) }
+
+func (v Var) MarshalSexpr() ([]byte, error)         { return marshalSexpr(v) }
+func (l literal) MarshalSexpr() ([]byte, error)     { return marshalSexpr(l) }
+func (u unary) MarshalSexpr() ([]byte, error)       { return marshalSexpr(u) }
+func (b binary) MarshalSexpr() ([]byte, error)      { return marshalSexpr(b) }
+func (c conditional) MarshalSexpr() ([]byte, error) { return marshalSexpr(c) }
+func (c call) MarshalSexpr() ([]byte, error)        { return marshalSexpr(c) }
+func (l let) MarshalSexpr() ([]byte, error)         { return marshalSexpr(l) }
+func (b block) MarshalSexpr() ([]byte, error)       { return marshalSexpr(b) }
+func (a apply) MarshalSexpr() ([]byte, error)       { return marshalSexpr(a) }
+
+// ---- JSON ----
+
+func marshalJSON(e Expr) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, e Expr) error {
+	switch e := e.(type) {
+	case Var:
+		writeJSONString(buf, string(e))
+	case varRef:
+		return writeJSON(buf, e.Var)
+	case literal:
+		return writeNumber(buf, e)
+	case unary:
+		return writeJSONOp(buf, "op", e.op, e.x)
+	case binary:
+		return writeJSONOp(buf, "op", e.op, e.x, e.y)
+	case conditional:
+		return writeJSONOp(buf, "op", "?:", e.cond, e.x, e.y)
+	case call:
+		return writeJSONOp(buf, "call", e.fn, e.args...)
+	case apply:
+		return writeJSONOp(buf, "call", e.def.Name, e.args...)
+	case let:
+		buf.WriteString(`{"let":`)
+		writeJSONString(buf, string(e.v))
+		buf.WriteString(`,"value":`)
+		if err := writeJSON(buf, e.x); err != nil {
+			return err
+		}
+		buf.WriteString(`,"body":`)
+		if err := writeJSON(buf, e.body); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	case block:
+		buf.WriteString(`{"defs":[`)
+		for i, d := range e.defs {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"name":`)
+			writeJSONString(buf, d.Name)
+			buf.WriteString(`,"params":[`)
+			for j, p := range d.Params {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				writeJSONString(buf, string(p))
+			}
+			buf.WriteString(`],"body":`)
+			if err := writeJSON(buf, d.Body); err != nil {
+				return err
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteString(`],"body":`)
+		if err := writeJSON(buf, e.body); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("eval: unknown Expr: %T", e)
+	}
+	return nil
+}
+
+// writeJSONOp writes {"key":name,"args":[args...]}.
+func writeJSONOp(buf *bytes.Buffer, key, name string, args ...Expr) error {
+	fmt.Fprintf(buf, `{"%s":`, key)
+	writeJSONString(buf, name)
+	buf.WriteString(`,"args":[`)
+	for i, arg := range args {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSON(buf, arg); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("]}")
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s) // cannot fail
+	buf.Write(b)
+}
+
+// writeNumber writes the shortest decimal form of l,
+// which is valid in both JSON and S-expressions.
+func writeNumber(buf *bytes.Buffer, l literal) error {
+	f := float64(l)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("eval: unsupported number %g", f)
+	}
+	buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	return nil
+}
+
+// fromJSON converts a value decoded by encoding/json to an Expr.
+// defs holds the functions defined by the enclosing blocks.
+func fromJSON(v interface{}, defs map[string]*Def) (Expr, error) {
+	switch v := v.(type) {
+	case string:
+		return Var(v), nil
+	case float64:
+		return literal(v), nil
+	case map[string]interface{}:
+		return objectFromJSON(v, defs)
+	}
+	return nil, fmt.Errorf("unexpected JSON value %v", v)
+}
+
+func objectFromJSON(obj map[string]interface{}, defs map[string]*Def) (Expr, error) {
+	var keys []string
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	shape := strings.Join(keys, ",")
+
+	str := func(key string) (string, error) {
+		s, ok := obj[key].(string)
+		if !ok {
+			return "", fmt.Errorf("%s: got %v, want string", key, obj[key])
+		}
+		return s, nil
+	}
+	list := func(key string) ([]Expr, error) {
+		elems, ok := obj[key].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: got %v, want array", key, obj[key])
+		}
+		var exprs []Expr
+		for _, elem := range elems {
+			e, err := fromJSON(elem, defs)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e)
+		}
+		return exprs, nil
+	}
+
+	switch shape {
+	case "args,op":
+		op, err := str("op")
+		if err != nil {
+			return nil, err
+		}
+		args, err := list("args")
+		if err != nil {
+			return nil, err
+		}
+		return operator(op, args)
+
+	case "args,call":
+		fn, err := str("call")
+		if err != nil {
+			return nil, err
+		}
+		args, err := list("args")
+		if err != nil {
+			return nil, err
+		}
+		return callOrApply(fn, args, defs), nil
+
+	case "body,let,value":
+		v, err := str("let")
+		if err != nil {
+			return nil, err
+		}
+		x, err := fromJSON(obj["value"], defs)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fromJSON(obj["body"], defs)
+		if err != nil {
+			return nil, err
+		}
+		return let{v: Var(v), x: x, body: body}, nil
+
+	case "body,defs":
+		elems, ok := obj["defs"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("defs: got %v, want array", obj["defs"])
+		}
+		var b block
+		for _, elem := range elems {
+			d, err := defFromJSON(elem, &defs)
+			if err != nil {
+				return nil, err
+			}
+			b.defs = append(b.defs, d)
+		}
+		body, err := fromJSON(obj["body"], defs)
+		if err != nil {
+			return nil, err
+		}
+		b.body = body
+		return b, nil
+	}
+	return nil, fmt.Errorf("unexpected object with keys %s", shape)
+}
+
+// defFromJSON converts a function definition and adds it to *defs.
+func defFromJSON(v interface{}, defs *map[string]*Def) (*Def, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok || len(obj) != 3 {
+		return nil, fmt.Errorf("got %v, want definition", v)
+	}
+	name, ok := obj["name"].(string)
+	if !ok {
+		return nil, fmt.Errorf("name: got %v, want string", obj["name"])
+	}
+	params, ok := obj["params"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("params: got %v, want array", obj["params"])
+	}
+	d := &Def{Name: name}
+	for _, p := range params {
+		s, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("parameter of %s: got %v, want string", name, p)
+		}
+		d.Params = append(d.Params, Var(s))
+	}
+	// Recursive calls refer to d, so that Check can report them.
+	*defs = bindDef(*defs, d)
+	body, err := fromJSON(obj["body"], *defs)
+	if err != nil {
+		return nil, err
+	}
+	d.Body = body
+	return d, nil
+}
+
+// ---- S-expressions ----
+
+func marshalSexpr(e Expr) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeSexpr(&buf, e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeSexpr(buf *bytes.Buffer, e Expr) error {
+	switch e := e.(type) {
+	case Var:
+		writeVar(buf, e)
+	case varRef:
+		return writeSexpr(buf, e.Var)
+	case literal:
+		return writeNumber(buf, e)
+	case unary:
+		return writeSexprList(buf, strconv.Quote(e.op), e.x)
+	case binary:
+		return writeSexprList(buf, strconv.Quote(e.op), e.x, e.y)
+	case conditional:
+		return writeSexprList(buf, `"?:"`, e.cond, e.x, e.y)
+	case call:
+		if !isSymbol(e.fn) {
+			return fmt.Errorf("eval: function name %q is not a symbol", e.fn)
+		}
+		return writeSexprList(buf, e.fn, e.args...)
+	case apply:
+		return writeSexpr(buf, call{fn: e.def.Name, args: e.args})
+	case let:
+		var head bytes.Buffer
+		head.WriteString(`"let" `)
+		writeVar(&head, e.v)
+		return writeSexprList(buf, head.String(), e.x, e.body)
+	case block:
+		buf.WriteString(`("block" (`)
+		for i, d := range e.defs {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteByte('(')
+			if err := writeSymbol(buf, d.Name); err != nil {
+				return err
+			}
+			buf.WriteString(" (")
+			for j, p := range d.Params {
+				if j > 0 {
+					buf.WriteByte(' ')
+				}
+				writeVar(buf, p)
+			}
+			buf.WriteString(") ")
+			if err := writeSexpr(buf, d.Body); err != nil {
+				return err
+			}
+			buf.WriteByte(')')
+		}
+		buf.WriteString(") ")
+		if err := writeSexpr(buf, e.body); err != nil {
+			return err
+		}
+		buf.WriteByte(')')
+	default:
+		return fmt.Errorf("eval: unknown Expr: %T", e)
+	}
+	return nil
+}
+
+// writeSexprList writes (head args...).
+func writeSexprList(buf *bytes.Buffer, head string, args ...Expr) error {
+	buf.WriteByte('(')
+	buf.WriteString(head)
+	for _, arg := range args {
+		buf.WriteByte(' ')
+		if err := writeSexpr(buf, arg); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(')')
+	return nil
+}
+
+func writeSymbol(buf *bytes.Buffer, name string) error {
+	if !isSymbol(name) {
+		return fmt.Errorf("eval: name %q is not a symbol", name)
+	}
+	buf.WriteString(name)
+	return nil
+}
+
+// writeVar writes v as a symbol, or as a string if its name is not
+// a symbol or is t, which package sexpr would read as true.
+func writeVar(buf *bytes.Buffer, v Var) {
+	if isSymbol(string(v)) && v != "t" {
+		buf.WriteString(string(v))
+	} else {
+		buf.WriteString(strconv.Quote(string(v)))
+	}
+}
+
+// isSymbol reports whether name is an identifier, and so
+// may be written as a symbol, but not the reserved symbol nil.
+func isSymbol(name string) bool {
+	if name == "" || name == "nil" {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || unicode.IsLetter(r) || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// A sexprReader converts an S-expression to an Expr.
+type sexprReader struct {
+	scan  scanner.Scanner
+	token rune
+	defs  map[string]*Def // functions defined by the enclosing blocks
+}
+
+// A sexprError is a panic value used to abandon reading.
+type sexprError struct{ err error }
+
+func (r *sexprReader) errorf(format string, args ...interface{}) {
+	panic(sexprError{fmt.Errorf("%d:%d: %s", r.scan.Position.Line, r.scan.Position.Column,
+		fmt.Sprintf(format, args...))})
+}
+
+func (r *sexprReader) next()        { r.token = r.scan.Scan() }
+func (r *sexprReader) text() string { return r.scan.TokenText() }
+
+func (r *sexprReader) expect(want rune) {
+	if r.token != want {
+		r.errorf("got %s, want %q", r.describe(), want)
+	}
+	r.next()
+}
+
+func (r *sexprReader) describe() string {
+	if r.token == scanner.EOF {
+		return "end of file"
+	}
+	return strconv.Quote(r.text())
+}
+
+// readTop reads a single expression, or nil, followed by the end of input.
+func (r *sexprReader) readTop() (e Expr, err error) {
+	defer func() {
+		switch x := recover().(type) {
+		case nil:
+		case sexprError:
+			err = x.err
+		default:
+			panic(x)
+		}
+	}()
+	r.next() // get the first token
+	if r.token == scanner.Ident && r.text() == "nil" {
+		r.next()
+	} else {
+		e = r.read()
+	}
+	if r.token != scanner.EOF {
+		r.errorf("got %s, want end of file", r.describe())
+	}
+	return e, nil
+}
+
+func (r *sexprReader) read() Expr {
+	switch r.token {
+	case scanner.Ident, scanner.String:
+		return r.variable()
+	case scanner.Int, scanner.Float, '-':
+		return r.number()
+	case '(':
+		r.next()
+		var e Expr
+		switch r.token {
+		case scanner.String:
+			e = r.special()
+		case scanner.Ident:
+			fn := r.text()
+			r.next()
+			e = callOrApply(fn, r.list(), r.defs)
+		default:
+			r.errorf("got %s, want operator or function name", r.describe())
+		}
+		r.expect(')')
+		return e
+	}
+	r.errorf("got %s, want expression", r.describe())
+	panic("unreachable")
+}
+
+func (r *sexprReader) number() literal {
+	sign := 1.0
+	if r.token == '-' {
+		sign = -1
+		r.next()
+	}
+	if r.token != scanner.Int && r.token != scanner.Float {
+		r.errorf("got %s, want number", r.describe())
+	}
+	f, err := strconv.ParseFloat(r.text(), 64)
+	if err != nil {
+		r.errorf("%v", err)
+	}
+	r.next()
+	return literal(sign * f)
+}
+
+// list reads expressions up to, but not including, a ')'.
+func (r *sexprReader) list() []Expr {
+	var exprs []Expr
+	for r.token != ')' && r.token != scanner.EOF {
+		exprs = append(exprs, r.read())
+	}
+	return exprs
+}
+
+// special reads the rest of a list whose head is a string.
+func (r *sexprReader) special() Expr {
+	head, _ := strconv.Unquote(r.text())
+	r.next()
+	switch head {
+	case "let":
+		v := r.variable()
+		x := r.read()
+		body := r.read()
+		return let{v: Var(v), x: x, body: body}
+
+	case "block":
+		outer := r.defs
+		defer func() { r.defs = outer }()
+		var b block
+		r.expect('(')
+		for r.token == '(' {
+			r.next()
+			d := &Def{Name: r.symbol()}
+			r.expect('(')
+			for r.token != ')' {
+				d.Params = append(d.Params, r.variable())
+			}
+			r.next()
+			// Recursive calls refer to d, so that Check can report them.
+			r.defs = bindDef(r.defs, d)
+			d.Body = r.read()
+			r.expect(')')
+			b.defs = append(b.defs, d)
+		}
+		r.expect(')')
+		b.body = r.read()
+		return b
+	}
+	e, err := operator(head, r.list())
+	if err != nil {
+		r.errorf("%v", err)
+	}
+	return e
+}
+
+func (r *sexprReader) symbol() string {
+	if r.token != scanner.Ident {
+		r.errorf("got %s, want symbol", r.describe())
+	}
+	s := r.text()
+	r.next()
+	return s
+}
+
+// variable reads the name of a variable, which is a symbol or a string.
+func (r *sexprReader) variable() Var {
+	if r.token == scanner.String {
+		s, err := strconv.Unquote(r.text())
+		if err != nil {
+			r.errorf("%v", err)
+		}
+		r.next()
+		return Var(s)
+	}
+	return Var(r.symbol())
+}
+
+// ---- common to both forms ----
+
+// operator returns the expression that applies op to args.
+func operator(op string, args []Expr) (Expr, error) {
+	switch {
+	case op == "?:" && len(args) == 3:
+		return conditional{cond: args[0], x: args[1], y: args[2]}, nil
+	case strings.Contains("+-!", op) && len(op) == 1 && len(args) == 1:
+		return unary{op: op, x: args[0]}, nil
+	case precedence(op) > 0 && len(args) == 2:
+		return binary{op: op, x: args[0], y: args[1]}, nil
+	}
+	return nil, fmt.Errorf("operator %q with %d operands", op, len(args))
+}
+
+// callOrApply returns a call to the function named fn, which refers
+// to the definition in defs of that name, if any.
+func callOrApply(fn string, args []Expr, defs map[string]*Def) Expr {
+	if d := defs[fn]; d != nil {
+		return apply{def: d, args: args}
+	}
+	return call{fn: fn, args: args}
+}
+
+// bindDef returns a copy of defs to which d has been added.
+func bindDef(defs map[string]*Def, d *Def) map[string]*Def {
+	result := map[string]*Def{d.Name: d}
+	for name, x := range defs {
+		if name != d.Name {
+			result[name] = x
+		}
+	}
+	return result
+}
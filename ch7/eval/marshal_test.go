@@ -0,0 +1,171 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go_example/ch12/sexpr"
+)
+
+var marshalTests = []struct {
+	input       string
+	json, sexpr string
+}{
+	{"x", `"x"`, `x`},
+	{"-1.5e-7", `{"op":"-","args":[1.5e-07]}`, `("-" 1.5e-07)`},
+	{"x + 1", `{"op":"+","args":["x",1]}`, `("+" x 1)`},
+	{"!(a <= b) || c", `{"op":"||","args":[{"op":"!","args":[{"op":"\u003c=","args":["a","b"]}]},"c"]}`,
+		`("||" ("!" ("<=" a b)) c)`},
+	{"c ? x : 1e+100", `{"op":"?:","args":["c","x",1e+100]}`, `("?:" c x 1e+100)`},
+	{"pow(x, 3) + max(1, 2, y)", `{"op":"+","args":[{"call":"pow","args":["x",3]},{"call":"max","args":[1,2,"y"]}]}`,
+		`("+" (pow x 3) (max 1 2 y))`},
+	{"let r = sqrt(x) in r * r", `{"let":"r","value":{"call":"sqrt","args":["x"]},"body":{"op":"*","args":["r","r"]}}`,
+		`("let" r (sqrt x) ("*" r r))`},
+	{"f(a, b) = a * b; g(c) = f(c, c); g(x)",
+		`{"defs":[{"name":"f","params":["a","b"],"body":{"op":"*","args":["a","b"]}},` +
+			`{"name":"g","params":["c"],"body":{"call":"f","args":["c","c"]}}],"body":{"call":"g","args":["x"]}}`,
+		`("block" ((f (a b) ("*" a b)) (g (c) (f c c))) (g x))`},
+	{"let t = nil in t * x", `{"let":"t","value":"nil","body":{"op":"*","args":["t","x"]}}`,
+		`("let" "t" "nil" ("*" "t" x))`}, // t and nil are not symbols
+	{"f(t) = t; f(1)", "", `("block" ((f ("t") "t")) (f 1))`},
+	{"sin(x) = -x; sin(y) + cos(y)", "", ""}, // a definition shadows a function
+	{"f(a) = a; f(a) = f(a) * 2; f(x)", "", ""},
+	{"h() = 1; h()", "", ""},
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, test := range marshalTests {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", test.input, err)
+			continue
+		}
+		want := Format(expr)
+
+		// JSON
+		data, err := json.Marshal(expr)
+		if err != nil {
+			t.Errorf("json.Marshal(%s): %v", test.input, err)
+			continue
+		}
+		if test.json != "" && string(data) != test.json {
+			t.Errorf("json.Marshal(%s) = %s, want %s", test.input, data, test.json)
+		}
+		var tree Tree
+		if err := json.Unmarshal(data, &tree); err != nil {
+			t.Errorf("json.Unmarshal(%s): %v", data, err)
+		} else if got := Format(tree.Expr); got != want {
+			t.Errorf("JSON round trip of %s = %s, want %s", test.input, got, want)
+		} else if !sameCheck(expr, tree.Expr) {
+			t.Errorf("JSON round trip of %s changed the result of Check", test.input)
+		}
+
+		// S-expression
+		data, err = Tree{expr}.MarshalSexpr()
+		if err != nil {
+			t.Errorf("MarshalSexpr(%s): %v", test.input, err)
+			continue
+		}
+		if test.sexpr != "" && string(data) != test.sexpr {
+			t.Errorf("MarshalSexpr(%s) = %s, want %s", test.input, data, test.sexpr)
+		}
+		tree = Tree{}
+		if err := sexpr.Unmarshal(data, &tree); err != nil {
+			t.Errorf("UnmarshalSexpr(%s): %v", data, err)
+		} else if got := Format(tree.Expr); got != want {
+			t.Errorf("S-expression round trip of %s = %s, want %s", test.input, got, want)
+		} else if !sameCheck(expr, tree.Expr) {
+			t.Errorf("S-expression round trip of %s changed the result of Check", test.input)
+		}
+	}
+}
+
+// sameCheck reports whether x and y are both valid or both invalid,
+// which distinguishes calls to defined functions from calls to Funcs.
+func sameCheck(x, y Expr) bool {
+	errx := x.Check(map[Var]bool{})
+	erry := y.Check(map[Var]bool{})
+	return (errx == nil) == (erry == nil) &&
+		(errx != nil || Format(Simplify(x)) == Format(Simplify(y)))
+}
+
+func TestMarshalStruct(t *testing.T) {
+	type record struct {
+		Name    string
+		Formula Tree
+		Missing Tree
+	}
+	expr, err := Parse("x * (y + 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(record{"r", Tree{expr}, Tree{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"Name":"r","Formula":{"op":"*","args":["x",{"op":"+","args":["y",1]}]},"Missing":null}`
+	if string(data) != want {
+		t.Errorf("json.Marshal = %s, want %s", data, want)
+	}
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if got := Format(r.Formula.Expr); got != "(x * (y + 1))" || r.Missing.Expr != nil {
+		t.Errorf("json.Unmarshal = %s, %v", got, r.Missing.Expr)
+	}
+
+	// The sexpr package calls the MarshalSexpr and UnmarshalSexpr methods.
+	data, err = sexpr.Marshal(record{"r", Tree{expr}, Tree{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantSexpr = `((Name "r") (Formula ("*" x ("+" y 1))) (Missing nil))`
+	if string(data) != wantSexpr {
+		t.Errorf("sexpr.Marshal = %s, want %s", data, wantSexpr)
+	}
+	r = record{}
+	if err := sexpr.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if got := Format(r.Formula.Expr); got != "(x * (y + 1))" || r.Missing.Expr != nil {
+		t.Errorf("sexpr.Unmarshal = %s, %v", got, r.Missing.Expr)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, test := range []struct {
+		json, sexpr string
+		want        string
+	}{
+		{json: `true`, want: "unexpected JSON value true"},
+		{json: `{"op":"%","args":[1,2]}`, want: `operator "%" with 2 operands`},
+		{json: `{"op":"-","args":[1,2,3]}`, want: `operator "-" with 3 operands`},
+		{json: `{"call":"f"}`, want: "unexpected object with keys call"},
+		{json: `{"call":1,"args":[]}`, want: "call: got 1, want string"},
+		{json: `{"defs":[{"name":"f"}],"body":1}`, want: "want definition"},
+		{sexpr: `("+" x`, want: "1:7: got end of file, want ')'"},
+		{sexpr: `(1 2)`, want: "1:2: got \"1\", want operator or function name"},
+		{sexpr: `("let" 1 2 3)`, want: "1:8: got \"1\", want symbol"},
+		{sexpr: `("?:" a b)`, want: `operator "?:" with 2 operands`},
+		{sexpr: `x y`, want: "1:3: got \"y\", want end of file"},
+		{sexpr: `- x`, want: "1:3: got \"x\", want number"},
+	} {
+		var tree Tree
+		var err error
+		input := test.json
+		if input != "" {
+			err = json.Unmarshal([]byte(input), &tree)
+		} else {
+			input = test.sexpr
+			err = tree.UnmarshalSexpr([]byte(input))
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Unmarshal(%s) = %v, want error containing %q", input, err, test.want)
+		}
+	}
+}
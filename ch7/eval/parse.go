@@ -14,14 +14,61 @@ import (
 
 // This lexer is similar to the one described in Chapter 13.
 type lexer struct {
-	scan  scanner.Scanner
-	token rune // current lookahead token
+	scan   scanner.Scanner
+	token  rune            // current lookahead token
+	op     string          // text of the current token if it is an operator
+	pos    Pos             // start of the current token
+	end    Pos             // end of the previous token
+	errors ErrorList       // errors reported so far
+	defs   map[string]*Def // functions defined so far
+}
+
+func (lex *lexer) next() {
+	lex.end = position(lex.scan.Pos())
+	lex.token = lex.scan.Scan()
+	lex.pos = position(lex.scan.Position)
+	lex.op = ""
+	if lex.token < 0 {
+		return // EOF, identifier, number, etc.
+	}
+	lex.op = string(lex.token)
+	// Combine two-character operators such as <= and &&.
+	switch lex.token {
+	case '<', '>', '=', '!':
+		if lex.scan.Peek() == '=' {
+			lex.op += string(lex.scan.Next())
+		}
+	case '&', '|':
+		if lex.scan.Peek() == lex.token {
+			lex.op += string(lex.scan.Next())
+		}
+	}
 }
 
-func (lex *lexer) next()        { lex.token = lex.scan.Scan() }
 func (lex *lexer) text() string { return lex.scan.TokenText() }
 
-type lexPanic string
+func position(p scanner.Position) Pos {
+	return Pos{Offset: p.Offset, Line: p.Line, Column: p.Column}
+}
+
+// errorf records an error spanning the current token.
+// It reports at most one error per position, so that a
+// single mistake does not produce a cascade of errors.
+func (lex *lexer) errorf(format string, args ...interface{}) {
+	if n := len(lex.errors); n > 0 && lex.errors[n-1].Span.Start == lex.pos {
+		return
+	}
+	span := Span{lex.pos, position(lex.scan.Pos())}
+	if lex.token == scanner.EOF {
+		span.End = lex.pos
+	}
+	lex.errors = append(lex.errors, &Diagnostic{span, fmt.Sprintf(format, args...)})
+}
+
+// span returns the span from start to the end of the previous token.
+func (lex *lexer) span(start Pos) Span {
+	return Span{start, lex.end}
+}
 
 // describe returns a string describing the current token, for use in errors.
 func (lex *lexer) describe() string {
@@ -33,14 +80,23 @@ func (lex *lexer) describe() string {
 	case scanner.Int, scanner.Float:
 		return fmt.Sprintf("number %s", lex.text())
 	}
+	if len(lex.op) > 1 {
+		return fmt.Sprintf("%q", lex.op)
+	}
 	return fmt.Sprintf("%q", rune(lex.token)) // any other rune
 }
 
-func precedence(op rune) int {
+func precedence(op string) int {
 	switch op {
-	case '*', '/':
+	case "*", "/":
+		return 5
+	case "+", "-":
+		return 4
+	case "<", "<=", ">", ">=", "==", "!=":
+		return 3
+	case "&&":
 		return 2
-	case '+', '-':
+	case "||":
 		return 1
 	}
 	return 0
@@ -53,45 +109,161 @@ func precedence(op rune) int {
 //   expr = num                         a literal number, e.g., 3.14159
 //        | id                          a variable name, e.g., x
 //        | id '(' expr ',' ... ')'     a function call
-//        | '-' expr                    a unary operator (+-)
-//        | expr '+' expr               a binary operator (+-*/)
+//        | '-' expr                    a unary operator (+-!)
+//        | expr '+' expr               a binary operator (+-*/ < <= > >= == != && ||)
+//        | expr '?' expr ':' expr      a conditional
+//        | 'let' id '=' expr 'in' expr a let binding
 //
-func Parse(input string) (_ Expr, err error) {
-	defer func() {
-		switch x := recover().(type) {
-		case nil:
-			// no panic
-		case lexPanic:
-			err = fmt.Errorf("%s", x)
-		default:
-			// unexpected panic: resume state of panic.
-			panic(x)
-		}
-	}()
-	lex := new(lexer)
+// Binary operators have the same precedence and associativity as in Go.
+// Comparisons and logical operators yield 1 for true and 0 for false.
+//
+// The expression may be preceded by function definitions,
+// each terminated by a semicolon:
+//
+//   program = (def ';')* expr
+//   def     = id '(' id ',' ... ')' '=' expr
+//
+// A function may be called by later definitions and by the expression.
+//
+// If the input is not well formed, Parse returns an ErrorList
+// describing every syntax error it found.
+func Parse(input string) (Expr, error) {
+	lex := newLexer(input)
+	e := parseProgram(lex)
+	if err := lex.finish(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ParseDef parses the input string as a single function definition,
+// e.g., f(a, b) = a*a + b.
+func ParseDef(input string) (*Def, error) {
+	lex := newLexer(input)
+	head := parseExpr(lex)
+	if lex.op != "=" {
+		lex.errorf("got %s, want '='", lex.describe())
+	}
+	d := parseDef(lex, head)
+	if err := lex.finish(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func newLexer(input string) *lexer {
+	lex := &lexer{defs: make(map[string]*Def)}
 	lex.scan.Init(strings.NewReader(input))
 	lex.scan.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats
+	lex.scan.Error = func(s *scanner.Scanner, msg string) {
+		lex.pos = position(s.Pos())
+		lex.errorf("%s", msg)
+	}
 	lex.next() // initial lookahead
-	e := parseExpr(lex)
-	if lex.token != scanner.EOF {
-		return nil, fmt.Errorf("unexpected %s", lex.describe())
+	return lex
+}
+
+// finish reports an error for any input remaining after a
+// complete parse, and returns the errors found, if any.
+func (lex *lexer) finish() error {
+	// After an error, discard the unexpected token and
+	// keep parsing so that later errors are also reported.
+	for lex.token != scanner.EOF {
+		lex.errorf("unexpected %s", lex.describe())
+		lex.next()
+		if !lex.atSync() {
+			parseExpr(lex)
+		}
 	}
-	return e, nil
+	if lex.errors != nil {
+		return lex.errors
+	}
+	return nil
+}
+
+// program = (def ';')* expr
+func parseProgram(lex *lexer) Expr {
+	start := lex.pos
+	var defs []*Def
+	for {
+		e := parseExpr(lex)
+		if lex.op != "=" {
+			if defs == nil {
+				return e
+			}
+			return block{defs, e, lex.span(start)}
+		}
+		d := parseDef(lex, e)
+		if d.Name == "" {
+			return e // not a definition; the error is already reported
+		}
+		defs = append(defs, d)
+		lex.expect(';')
+	}
+}
+
+// def = id '(' id ',' ... ')' '=' expr
+// parseDef is called with the head of the definition,
+// already parsed as an expression, and '=' as the current token.
+func parseDef(lex *lexer, head Expr) *Def {
+	d := new(Def)
+	var args []Expr
+	switch head := head.(type) {
+	case call:
+		d.Name, args = head.fn, head.args
+	case apply: // redefinition of an earlier function
+		d.Name, args = head.def.Name, head.args
+	default:
+		lex.errorf("left side of = is not a function name and parameters")
+	}
+	for _, arg := range args {
+		p, ok := arg.(varRef)
+		if !ok {
+			lex.errorf("parameter of %s is not a name", d.Name)
+		}
+		d.Params = append(d.Params, p.Var)
+	}
+	if lex.op == "=" {
+		lex.next() // consume '='
+	}
+	if d.Name != "" {
+		// Recursive calls refer to d, so that Check can report them.
+		lex.defs[d.Name] = d
+	}
+	d.Body = parseExpr(lex)
+	return d
 }
 
-func parseExpr(lex *lexer) Expr { return parseBinary(lex, 1) }
+// expr = binary ('?' expr ':' expr)?
+func parseExpr(lex *lexer) Expr {
+	start := lex.pos
+	cond := parseBinary(lex, 1)
+	if lex.token != '?' {
+		return cond
+	}
+	lex.next() // consume '?'
+	x := parseExpr(lex)
+	if lex.token != ':' {
+		lex.errorf("got %s, want ':'", lex.describe())
+	} else {
+		lex.next() // consume ':'
+	}
+	y := parseExpr(lex)
+	return conditional{cond, x, y, lex.span(start)}
+}
 
 // binary = unary ('+' binary)*
 // parseBinary stops when it encounters an
 // operator of lower precedence than prec1.
 func parseBinary(lex *lexer, prec1 int) Expr {
+	start := lex.pos
 	lhs := parseUnary(lex)
-	for prec := precedence(lex.token); prec >= prec1; prec-- {
-		for precedence(lex.token) == prec {
-			op := lex.token
+	for prec := precedence(lex.op); prec >= prec1; prec-- {
+		for precedence(lex.op) == prec {
+			op := lex.op
 			lex.next() // consume operator
 			rhs := parseBinary(lex, prec+1)
-			lhs = binary{op, lhs, rhs}
+			lhs = binary{op, lhs, rhs, lex.span(start)}
 		}
 	}
 	return lhs
@@ -99,10 +271,11 @@ func parseBinary(lex *lexer, prec1 int) Expr {
 
 // unary = '+' expr | primary
 func parseUnary(lex *lexer) Expr {
-	if lex.token == '+' || lex.token == '-' {
-		op := lex.token
-		lex.next() // consume '+' or '-'
-		return unary{op, parseUnary(lex)}
+	if lex.op == "+" || lex.op == "-" || lex.op == "!" {
+		start, op := lex.pos, lex.op
+		lex.next() // consume '+', '-' or '!'
+		x := parseUnary(lex)
+		return unary{op, x, lex.span(start)}
 	}
 	return parsePrimary(lex)
 }
@@ -111,13 +284,18 @@ func parseUnary(lex *lexer) Expr {
 //         | id '(' expr ',' ... ',' expr ')'
 //         | num
 //         | '(' expr ')'
+//         | 'let' id '=' expr 'in' expr
 func parsePrimary(lex *lexer) Expr {
+	start := lex.pos
 	switch lex.token {
 	case scanner.Ident:
 		id := lex.text()
 		lex.next() // consume Ident
+		if id == "let" {
+			return parseLet(lex, start)
+		}
 		if lex.token != '(' {
-			return Var(id)
+			return varRef{Var(id), lex.span(start)}
 		}
 		lex.next() // consume '('
 		var args []Expr
@@ -129,18 +307,17 @@ func parsePrimary(lex *lexer) Expr {
 				}
 				lex.next() // consume ','
 			}
-			if lex.token != ')' {
-				msg := fmt.Sprintf("got %s, want ')'", lex.describe())
-				panic(lexPanic(msg))
-			}
 		}
-		lex.next() // consume ')'
-		return call{id, args}
+		lex.expect(')')
+		if d := lex.defs[id]; d != nil {
+			return apply{d, args, lex.span(start)}
+		}
+		return call{id, args, lex.span(start)}
 
 	case scanner.Int, scanner.Float:
 		f, err := strconv.ParseFloat(lex.text(), 64)
 		if err != nil {
-			panic(lexPanic(err.Error()))
+			lex.errorf("%s", err)
 		}
 		lex.next() // consume number
 		return literal(f)
@@ -148,13 +325,61 @@ func parsePrimary(lex *lexer) Expr {
 	case '(':
 		lex.next() // consume '('
 		e := parseExpr(lex)
-		if lex.token != ')' {
-			msg := fmt.Sprintf("got %s, want ')'", lex.describe())
-			panic(lexPanic(msg))
-		}
-		lex.next() // consume ')'
+		lex.expect(')')
 		return e
 	}
-	msg := fmt.Sprintf("unexpected %s", lex.describe())
-	panic(lexPanic(msg))
-}
\ No newline at end of file
+	lex.errorf("unexpected %s", lex.describe())
+	if !lex.atSync() {
+		// Skip the unexpected token and try again.
+		lex.next()
+		if !lex.atSync() {
+			return parseUnary(lex)
+		}
+	}
+	return literal(0) // placeholder; Parse fails
+}
+
+// atSync reports whether the current token may end an expression,
+// and so should be left for an enclosing production after an error.
+func (lex *lexer) atSync() bool {
+	switch lex.token {
+	case ')', ',', ':', '?', scanner.EOF:
+		return true
+	}
+	return precedence(lex.op) > 0
+}
+
+// let = 'let' id '=' expr 'in' expr
+// parseLet is called after 'let' has been consumed.
+func parseLet(lex *lexer, start Pos) Expr {
+	var v Var
+	if lex.token != scanner.Ident {
+		lex.errorf("got %s, want identifier", lex.describe())
+	} else {
+		v = Var(lex.text())
+		lex.next() // consume Ident
+	}
+	if lex.op != "=" {
+		lex.errorf("got %s, want '='", lex.describe())
+	} else {
+		lex.next() // consume '='
+	}
+	x := parseExpr(lex)
+	if lex.token != scanner.Ident || lex.text() != "in" {
+		lex.errorf("got %s, want in", lex.describe())
+	} else {
+		lex.next() // consume 'in'
+	}
+	body := parseExpr(lex)
+	return let{v, x, body, lex.span(start)}
+}
+
+// expect consumes the current token if it is want,
+// and otherwise reports an error.
+func (lex *lexer) expect(want rune) {
+	if lex.token != want {
+		lex.errorf("got %s, want %q", lex.describe(), want)
+		return
+	}
+	lex.next()
+}
@@ -6,6 +6,8 @@ package eval
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"strings"
 )
 
 // Format formats an expression as a string.
@@ -24,15 +26,27 @@ func write(buf *bytes.Buffer, e Expr) {
 	case Var:
 		fmt.Fprintf(buf, "%s", e)
 
+	case varRef:
+		write(buf, e.Var)
+
 	case unary:
-		fmt.Fprintf(buf, "(%c", e.op)
+		fmt.Fprintf(buf, "(%s", e.op)
 		write(buf, e.x)
 		buf.WriteByte(')')
 
 	case binary:
 		buf.WriteByte('(')
 		write(buf, e.x)
-		fmt.Fprintf(buf, " %c ", e.op)
+		fmt.Fprintf(buf, " %s ", e.op)
+		write(buf, e.y)
+		buf.WriteByte(')')
+
+	case conditional:
+		buf.WriteByte('(')
+		write(buf, e.cond)
+		buf.WriteString(" ? ")
+		write(buf, e.x)
+		buf.WriteString(" : ")
 		write(buf, e.y)
 		buf.WriteByte(')')
 
@@ -46,7 +60,112 @@ func write(buf *bytes.Buffer, e Expr) {
 		}
 		buf.WriteByte(')')
 
+	case let:
+		fmt.Fprintf(buf, "(let %s = ", e.v)
+		write(buf, e.x)
+		buf.WriteString(" in ")
+		write(buf, e.body)
+		buf.WriteByte(')')
+
+	case block:
+		for _, d := range e.defs {
+			writeDef(buf, d)
+			buf.WriteString("; ")
+		}
+		write(buf, e.body)
+
+	case apply:
+		write(buf, call{fn: e.def.Name, args: e.args})
+
 	default:
 		panic(fmt.Sprintf("unknown Expr: %T", e))
 	}
-}
\ No newline at end of file
+}
+
+// String formats a function definition, e.g., f(a, b) = ((a * a) + b).
+func (d *Def) String() string {
+	var buf bytes.Buffer
+	writeDef(&buf, d)
+	return buf.String()
+}
+
+func writeDef(buf *bytes.Buffer, d *Def) {
+	fmt.Fprintf(buf, "%s(%s) = ", d.Name, joinVars(d.Params))
+	write(buf, d.Body)
+}
+
+// Dump writes to w an outline of the syntax tree of e, one node per
+// line, indented by depth.  Nodes produced by Parse, other than
+// variables and literals, are annotated with their spans.
+func Dump(w io.Writer, e Expr) {
+	dump(w, e, 0)
+}
+
+func dump(w io.Writer, e Expr, depth int) {
+	indent := strings.Repeat("  ", depth)
+	var span string
+	if s := spanOf(e); s != (Span{}) {
+		span = fmt.Sprintf(" (%s-%s)", s.Start, s.End)
+	}
+	switch e := e.(type) {
+	case literal:
+		fmt.Fprintf(w, "%sliteral %g%s\n", indent, e, span)
+
+	case Var:
+		fmt.Fprintf(w, "%sVar %s%s\n", indent, e, span)
+
+	case varRef:
+		fmt.Fprintf(w, "%sVar %s%s\n", indent, e.Var, span)
+
+	case unary:
+		fmt.Fprintf(w, "%sunary %s%s\n", indent, e.op, span)
+		dump(w, e.x, depth+1)
+
+	case binary:
+		fmt.Fprintf(w, "%sbinary %s%s\n", indent, e.op, span)
+		dump(w, e.x, depth+1)
+		dump(w, e.y, depth+1)
+
+	case conditional:
+		fmt.Fprintf(w, "%sconditional%s\n", indent, span)
+		dump(w, e.cond, depth+1)
+		dump(w, e.x, depth+1)
+		dump(w, e.y, depth+1)
+
+	case call:
+		fmt.Fprintf(w, "%scall %s%s\n", indent, e.fn, span)
+		for _, arg := range e.args {
+			dump(w, arg, depth+1)
+		}
+
+	case let:
+		fmt.Fprintf(w, "%slet %s%s\n", indent, e.v, span)
+		dump(w, e.x, depth+1)
+		dump(w, e.body, depth+1)
+
+	case block:
+		fmt.Fprintf(w, "%sblock%s\n", indent, span)
+		for _, d := range e.defs {
+			fmt.Fprintf(w, "%s  def %s(%s)\n", indent, d.Name, joinVars(d.Params))
+			dump(w, d.Body, depth+2)
+		}
+		dump(w, e.body, depth+1)
+
+	case apply:
+		fmt.Fprintf(w, "%sapply %s%s\n", indent, e.def.Name, span)
+		for _, arg := range e.args {
+			dump(w, arg, depth+1)
+		}
+
+	default:
+		panic(fmt.Sprintf("unknown Expr: %T", e))
+	}
+}
+
+func joinVars(vars []Var) string {
+	names := make([]string, len(vars))
+	for i, v := range vars {
+		names[i] = string(v)
+	}
+	return strings.Join(names, ", ")
+}
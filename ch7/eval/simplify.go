@@ -0,0 +1,410 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Simplify returns an expression equivalent to e in which constant
+// subexpressions have been folded, identities such as x*1 and 0+y
+// and annihilators such as x*0 have been applied, like terms have
+// been collected (x + 2*x = 3*x, x*x = pow(x, 2)), and the operands
+// of commutative operators appear in a canonical order.
+//
+// Let-bound variables and calls to defined functions are expanded.
+// Simplify assumes that every variable holds a finite number, as do
+// sums and products of finite numbers; for example, it rewrites x*0
+// and x-x as 0.  It does not assume that a variable is nonzero, so
+// x/x and x*pow(x, -1) are not rewritten as 1, nor that a function
+// is finite unless its bounds show it, so sin(x)*0 is rewritten as 0
+// but log(x)*0 is not.
+func Simplify(e Expr) Expr {
+	return simplify(expand(e, nil))
+}
+
+func simplify(e Expr) Expr {
+	switch e := e.(type) {
+	case literal, Var:
+		return e
+
+	case unary:
+		x := simplify(e.x)
+		switch e.op {
+		case "+":
+			return x
+		case "-":
+			return sum([]term{{-1, x}})
+		case "!":
+			if l, ok := x.(literal); ok {
+				return literal(truth(l == 0))
+			}
+			if u, ok := x.(unary); ok && u.op == "!" && isBool(u.x) {
+				return u.x // !!(x < y) = x < y
+			}
+		}
+		return unary{op: e.op, x: x}
+
+	case binary:
+		x, y := simplify(e.x), simplify(e.y)
+		switch e.op {
+		case "+":
+			return sum([]term{{1, x}, {1, y}})
+		case "-":
+			return sum([]term{{1, x}, {-1, y}})
+		case "*":
+			return product([]Expr{x, y})
+		case "/":
+			// Neither x/x nor 0/x is simplified, as x may be 0.
+			if lx, ok := x.(literal); ok {
+				if ly, ok := y.(literal); ok && ly != 0 {
+					return lx / ly
+				}
+			}
+			if isConst(y, 1) {
+				return x
+			}
+			return binary{op: "/", x: x, y: y}
+		}
+		_, xconst := x.(literal)
+		_, yconst := y.(literal)
+		if xconst && yconst {
+			return literal(binary{op: e.op, x: x, y: y}.Eval(nil))
+		}
+		switch e.op {
+		case "&&":
+			if xconst || yconst {
+				if isConst(x, 0) || isConst(y, 0) {
+					return literal(0)
+				}
+				// One operand is a nonzero constant.
+				if xconst {
+					x = y
+				}
+				return truthOf(x)
+			}
+		case "||":
+			if xconst || yconst {
+				if xconst && !isConst(x, 0) || yconst && !isConst(y, 0) {
+					return literal(1)
+				}
+				if xconst {
+					x = y
+				}
+				return truthOf(x)
+			}
+		case "==", "<=", ">=":
+			if equal(x, y) && finite(x) {
+				return literal(1)
+			}
+		case "!=", "<", ">":
+			if equal(x, y) && finite(x) {
+				return literal(0)
+			}
+		}
+		switch e.op {
+		case "==", "!=", "&&", "||":
+			if less(y, x) {
+				x, y = y, x
+			}
+		case ">", ">=": // canonicalize x > y as y < x
+			if less(y, x) {
+				x, y = y, x
+				e.op = map[string]string{">": "<", ">=": "<="}[e.op]
+			}
+		case "<", "<=":
+			if less(y, x) {
+				x, y = y, x
+				e.op = map[string]string{"<": ">", "<=": ">="}[e.op]
+			}
+		}
+		return binary{op: e.op, x: x, y: y}
+
+	case conditional:
+		c, x, y := simplify(e.cond), simplify(e.x), simplify(e.y)
+		if l, ok := c.(literal); ok {
+			if l != 0 {
+				return x
+			}
+			return y
+		}
+		if equal(x, y) {
+			return x
+		}
+		return conditional{cond: c, x: x, y: y}
+
+	case call:
+		args := make([]Expr, len(e.args))
+		allConst := true
+		for i, arg := range e.args {
+			args[i] = simplify(arg)
+			if _, ok := args[i].(literal); !ok {
+				allConst = false
+			}
+		}
+		s := call{fn: e.fn, args: args}
+		if allConst && Funcs.lookup(e.fn) != nil {
+			// Fold calls to registered functions, which are pure,
+			// unless the result is not a valid number literal.
+			if f := s.Eval(nil); !math.IsNaN(f) && !math.IsInf(f, 0) {
+				return literal(f)
+			}
+		}
+		return s
+	}
+	panic(fmt.Sprintf("unknown Expr: %T", e))
+}
+
+// isBool reports whether e always evaluates to 0 or 1.
+func isBool(e Expr) bool {
+	switch e := e.(type) {
+	case unary:
+		return e.op == "!"
+	case binary:
+		switch e.op {
+		case "+", "-", "*", "/":
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// truthOf returns an expression equal to 1 if e is nonzero and 0 otherwise.
+func truthOf(e Expr) Expr {
+	if isBool(e) {
+		return e
+	}
+	return binary{op: "!=", x: e, y: literal(0)}
+}
+
+// A term is a summand of the form coef*x.
+type term struct {
+	coef float64
+	x    Expr
+}
+
+// sum returns the simplified sum of terms, collecting like terms.
+// Terms that cancel are dropped only if they are finite.
+func sum(terms []term) Expr {
+	constant := math.Copysign(0, -1) // the identity, so that -0 is preserved
+	coefs := make(map[string]*term)
+	var keys []string
+	var flatten func(c float64, x Expr)
+	flatten = func(c float64, x Expr) {
+		switch e := x.(type) {
+		case literal:
+			constant += c * float64(e)
+			return
+		case unary:
+			if e.op == "-" {
+				flatten(-c, e.x)
+				return
+			}
+		case binary:
+			switch e.op {
+			case "+":
+				flatten(c, e.x)
+				flatten(c, e.y)
+				return
+			case "-":
+				flatten(c, e.x)
+				flatten(-c, e.y)
+				return
+			case "*":
+				if l, ok := e.x.(literal); ok {
+					flatten(c*float64(l), e.y)
+					return
+				}
+			}
+		}
+		key := Format(x)
+		if t, ok := coefs[key]; ok {
+			t.coef += c
+			return
+		}
+		coefs[key] = &term{c, x}
+		keys = append(keys, key)
+	}
+	for _, t := range terms {
+		flatten(t.coef, t.x)
+	}
+
+	// Emit positive terms, then subtract negative ones.
+	sort.Strings(keys)
+	var result Expr
+	for _, positive := range []bool{true, false} {
+		for _, key := range keys {
+			t := coefs[key]
+			switch {
+			case (t.coef >= 0) != positive:
+				continue
+			case t.coef == 0 && finite(t.x):
+				constant += 0 // x - x is +0
+				continue
+			case result == nil:
+				result = product([]Expr{literal(t.coef), t.x})
+			case t.coef < 0:
+				result = binary{op: "-", x: result, y: product([]Expr{literal(-t.coef), t.x})}
+			default:
+				result = binary{op: "+", x: result, y: product([]Expr{literal(t.coef), t.x})}
+			}
+		}
+	}
+	switch {
+	case result == nil:
+		return literal(constant)
+	case constant < 0:
+		return binary{op: "-", x: result, y: literal(-constant)}
+	case constant > 0:
+		return binary{op: "+", x: result, y: literal(constant)}
+	}
+	return result
+}
+
+// product returns the simplified product of factors,
+// combining repeated factors into powers.
+// A zero coefficient annihilates only finite factors.
+func product(factors []Expr) Expr {
+	coef := 1.0
+	exps := make(map[string]*term) // base x with exponent coef
+	var keys []string
+	var flatten func(x Expr, exp float64)
+	flatten = func(x Expr, exp float64) {
+		switch e := x.(type) {
+		case literal:
+			coef *= math.Pow(float64(e), exp)
+			return
+		case unary:
+			if e.op == "-" {
+				coef *= math.Pow(-1, exp)
+				flatten(e.x, exp)
+				return
+			}
+		case binary:
+			if e.op == "*" {
+				flatten(e.x, exp)
+				flatten(e.y, exp)
+				return
+			}
+		case call:
+			// Only nonnegative integer powers distribute over
+			// products and combine with other powers of the same
+			// base; negative ones would cancel, as in x*pow(x, -1).
+			if e.fn != "pow" {
+				break
+			}
+			_, constBase := e.args[0].(literal)
+			if l, ok := e.args[1].(literal); ok && !constBase &&
+				l >= 0 && l == literal(math.Trunc(float64(l))) {
+				flatten(e.args[0], exp*float64(l))
+				return
+			}
+		}
+		key := Format(x)
+		if t, ok := exps[key]; ok {
+			t.coef += exp
+			return
+		}
+		exps[key] = &term{exp, x}
+		keys = append(keys, key)
+	}
+	for _, f := range factors {
+		flatten(f, 1)
+	}
+	if coef == 0 {
+		annihilated := true
+		for _, t := range exps {
+			annihilated = annihilated && finite(t.x)
+		}
+		if annihilated {
+			return literal(coef)
+		}
+	}
+
+	sort.Strings(keys)
+	var result Expr
+	for _, key := range keys {
+		t := exps[key]
+		var f Expr
+		switch t.coef {
+		case 0:
+			continue
+		case 1:
+			f = t.x
+		default:
+			f = call{fn: "pow", args: []Expr{t.x, literal(t.coef)}}
+		}
+		if result == nil {
+			result = f
+		} else {
+			result = binary{op: "*", x: result, y: f}
+		}
+	}
+	switch {
+	case result == nil:
+		return literal(coef)
+	case coef == -1:
+		return unary{op: "-", x: result}
+	case coef != 1:
+		return binary{op: "*", x: literal(coef), y: result}
+	}
+	return result
+}
+
+// finite reports whether e is a finite number whenever its variables
+// are, assuming as Simplify does that sums and products of finite
+// numbers are finite.  A call is finite if its arguments are and the
+// Bounds of the function are finite over all finite arguments.
+func finite(e Expr) bool {
+	switch e := e.(type) {
+	case literal:
+		return !math.IsInf(float64(e), 0) && !math.IsNaN(float64(e))
+	case Var:
+		return true
+	case unary:
+		return e.op == "!" || finite(e.x)
+	case binary:
+		switch e.op {
+		case "+", "-", "*":
+			return finite(e.x) && finite(e.y)
+		case "/":
+			l, ok := e.y.(literal)
+			return ok && l != 0 && finite(l) && finite(e.x)
+		}
+		return true // comparison or logical operator
+	case conditional:
+		return finite(e.x) && finite(e.y)
+	case call:
+		f := Funcs.lookup(e.fn)
+		if f == nil || f.Bounds == nil {
+			return false
+		}
+		args := make([]Interval, len(e.args))
+		for i, arg := range e.args {
+			if !finite(arg) {
+				return false
+			}
+			args[i] = Interval{-math.MaxFloat64, math.MaxFloat64, false}
+		}
+		return f.Bounds(args).Finite()
+	}
+	return false
+}
+
+// equal reports whether x and y are structurally identical.
+func equal(x, y Expr) bool { return Format(x) == Format(y) }
+
+// less defines the canonical order of the operands of commutative
+// operators: constants last, otherwise by printed form.
+func less(x, y Expr) bool {
+	_, xconst := x.(literal)
+	_, yconst := y.(literal)
+	if xconst != yconst {
+		return yconst
+	}
+	return Format(x) < Format(y)
+}
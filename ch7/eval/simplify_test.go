@@ -0,0 +1,82 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	envs := []Env{
+		{"x": 0.5, "y": -2, "z": 3},
+		{"x": -1.25, "y": 0, "z": 7},
+		{"x": 4, "y": 1, "z": -0.5},
+		{"x": 0, "y": 0, "z": 0},
+	}
+	for _, test := range []struct {
+		expr, want string
+	}{
+		{"2 * 3", "6"},
+		{"x * 1", "x"},
+		{"0 + y", "y"},
+		{"x * 0 + y * (2 - 1)", "y"},
+		{"x - x", "0"},
+		{"x + 2*x - y", "((3 * x) - y)"},
+		{"1 + x + 2 + y + 3", "((x + y) + 6)"},
+		{"y + x", "(x + y)"},
+		{"y * 2 * x", "(2 * (x * y))"},
+		{"x * x * x", "pow(x, 3)"},
+		{"pow(-x, 2) * pow(y * x, 2)", "(pow(x, 4) * pow(y, 2))"},
+		{"pow(x, 0.5) * pow(x, 0.5)", "pow(pow(x, 0.5), 2)"},
+		{"x * y / (y * x)", "((x * y) / (x * y))"},
+		{"x / x", "(x / x)"},
+		{"0 / x", "(0 / x)"},
+		{"x / 1", "x"},
+		{"x * pow(x, -1)", "(pow(x, -1) * x)"},
+		{"pow(x, 2) * pow(x, 3)", "pow(x, 5)"},
+		{"-(-x)", "x"},
+		{"-(x - y)", "(y - x)"},
+		{"+x * -1", "(-x)"},
+		{"sqrt(16) + pow(2, 10)", "1028"},
+		{"sin(x) + sin(x)", "(2 * sin(x))"},
+		{"1 > 2 ? x : y", "y"},
+		{"z > 0 ? x + 0 : 1 * x", "x"},
+		{"y == x", "(x == y)"},
+		{"1 && x", "(x != 0)"},
+		{"0 && x", "0"},
+		{"x < y || 1", "1"},
+		{"!!(x < y)", "(x < y)"},
+		{"3 < x", "(x > 3)"},
+		{"x <= x", "1"},
+		// Factors and terms that may be infinite or NaN remain.
+		{"sin(x) * 0", "0"},
+		{"0 * sqrt(x)", "(0 * sqrt(x))"},
+		{"log(x) * 0", "(0 * log(x))"},
+		{"acos(x) * x * 0", "(0 * (acos(x) * x))"},
+		{"log(x) - log(x)", "(0 * log(x))"},
+		{"log(x) <= log(x)", "(log(x) <= log(x))"},
+		{"y / -0", "(y / -0)"},
+		{"-0 + -0", "-0"},
+	} {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		s := Simplify(expr)
+		if got := Format(s); got != test.want {
+			t.Errorf("Simplify(%s) = %s, want %s", test.expr, got, test.want)
+		}
+		// Simplification must preserve the value.
+		for _, env := range envs {
+			want, got := expr.Eval(env), s.Eval(env)
+			if math.IsNaN(got) != math.IsNaN(want) ||
+				math.Abs(got-want) > 1e-12*math.Max(1, math.Abs(want)) {
+				t.Errorf("%s in %v = %g, but simplified %s = %g",
+					test.expr, env, want, Format(s), got)
+			}
+		}
+	}
+}
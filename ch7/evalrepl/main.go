@@ -0,0 +1,306 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+// Evalrepl is an interactive evaluator for the expressions of package eval.
+//
+// Each line of input is one of:
+//
+//	expr              print the value of expr
+//	x = expr          assign the value of expr to the variable x
+//	f(a, b) = expr    define the function f
+//	:vars             list the variables and their values
+//	:check expr       report every problem in expr
+//	:tree expr        print the syntax tree of expr
+//	:diff x [expr]    print the derivative of expr (default: the
+//	                  last expression evaluated) with respect to x
+//	:help             print this message
+//
+// Errors are reported and the session continues until end of input.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"go_example/ch7/eval"
+)
+
+// go run ./ch7/evalrepl
+func main() {
+	newREPL(os.Stdout).run(os.Stdin)
+}
+
+type repl struct {
+	env      eval.Env
+	last     eval.Expr       // the last expression evaluated
+	builtins map[string]bool // functions that may not be redefined
+	out      io.Writer
+}
+
+func newREPL(out io.Writer) *repl {
+	r := &repl{env: make(eval.Env), builtins: make(map[string]bool), out: out}
+	for _, name := range eval.Funcs.Names() {
+		r.builtins[name] = true
+	}
+	return r
+}
+
+func (r *repl) run(in io.Reader) {
+	input := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(r.out, "> ")
+		if !input.Scan() {
+			break
+		}
+		line := strings.TrimSpace(input.Text())
+		if line == "" {
+			continue
+		}
+		if err := r.exec(line); err != nil {
+			r.report(err)
+		}
+	}
+	fmt.Fprintln(r.out)
+}
+
+// report prints err.  Each diagnostic of an eval.ErrorList
+// is printed on its own line, preceded by its position.
+func (r *repl) report(err error) {
+	list, ok := err.(eval.ErrorList)
+	if !ok {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	for _, d := range list {
+		if d.Span.Start.Line == 0 {
+			fmt.Fprintln(r.out, d) // no position
+		} else {
+			fmt.Fprintf(r.out, "%s: %s\n", d.Span.Start, d)
+		}
+	}
+}
+
+// exec executes one line of input.
+func (r *repl) exec(line string) error {
+	if strings.HasPrefix(line, ":") {
+		cmd, arg := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i:])
+		}
+		switch cmd {
+		case ":vars":
+			return r.vars()
+		case ":check":
+			return r.check(arg)
+		case ":tree":
+			return r.tree(arg)
+		case ":diff":
+			return r.diff(arg)
+		case ":help":
+			fmt.Fprint(r.out, help)
+			return nil
+		}
+		return fmt.Errorf("unknown command %s (try :help)", cmd)
+	}
+
+	// Anything that is not an expression may be an assignment or definition.
+	if _, err := eval.Parse(line); err != nil {
+		lhs, rhs, ok := splitAssign(line)
+		if !ok {
+			return err
+		}
+		if isIdent(lhs) {
+			v, err := r.eval(rhs)
+			if err != nil {
+				return err
+			}
+			r.env[eval.Var(lhs)] = v
+			return nil
+		}
+		return r.define(line)
+	}
+
+	v, err := r.eval(line)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "%g\n", v)
+	return nil
+}
+
+const help = `expr              print the value of expr
+x = expr          assign the value of expr to the variable x
+f(a, b) = expr    define the function f
+:vars             list the variables and their values
+:check expr       report every problem in expr
+:tree expr        print the syntax tree of expr
+:diff x [expr]    print the derivative of expr with respect to x
+:help             print this message
+`
+
+// eval parses, checks and evaluates the expression in input.
+func (r *repl) eval(input string) (float64, error) {
+	expr, err := r.parse(input)
+	if err != nil {
+		return 0, err
+	}
+	r.last = expr
+	return expr.Eval(r.env), nil
+}
+
+// parse parses input and checks it against the current variables.
+func (r *repl) parse(input string) (eval.Expr, error) {
+	expr, err := eval.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	if errs := eval.Check(expr, r.schema()); errs != nil {
+		return nil, errs
+	}
+	return expr, nil
+}
+
+func (r *repl) schema() map[eval.Var]bool {
+	schema := make(map[eval.Var]bool)
+	for v := range r.env {
+		schema[v] = true
+	}
+	return schema
+}
+
+// define adds a function definition to eval.Funcs.
+// Functions defined earlier in the session may be redefined,
+// but built-in functions such as sin may not, and no function
+// may call itself, even through other functions.
+func (r *repl) define(input string) error {
+	d, err := eval.ParseDef(input)
+	if err != nil {
+		return err
+	}
+	if r.builtins[d.Name] {
+		return fmt.Errorf("cannot redefine built-in function %s", d.Name)
+	}
+	if err := d.Check(); err != nil {
+		return err
+	}
+	eval.Funcs.Register(d.Name, d.Func())
+	return nil
+}
+
+func (r *repl) vars() error {
+	var names []string
+	for v := range r.env {
+		names = append(names, string(v))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(r.out, "%s = %g\n", name, r.env[eval.Var(name)])
+	}
+	return nil
+}
+
+func (r *repl) check(input string) error {
+	expr, err := eval.Parse(input)
+	if err != nil {
+		return err
+	}
+	if err := eval.Check(expr, r.schema()); err != nil {
+		r.report(err)
+	} else {
+		fmt.Fprintln(r.out, "ok")
+	}
+	return nil
+}
+
+func (r *repl) tree(input string) error {
+	expr, err := eval.Parse(input)
+	if err != nil {
+		return err
+	}
+	eval.Dump(r.out, expr)
+	return nil
+}
+
+func (r *repl) diff(arg string) error {
+	x, input := arg, ""
+	if i := strings.IndexAny(arg, " \t"); i >= 0 {
+		x, input = arg[:i], strings.TrimSpace(arg[i:])
+	}
+	if !isIdent(x) {
+		return fmt.Errorf("usage: :diff x [expr]")
+	}
+	expr := r.last
+	if input != "" {
+		var err error
+		if expr, err = eval.Parse(input); err != nil {
+			return err
+		}
+		if err := expr.Check(map[eval.Var]bool{}); err != nil {
+			return err
+		}
+	}
+	if expr == nil {
+		return fmt.Errorf("no expression to differentiate")
+	}
+	d, err := eval.Derive(expr, eval.Var(x))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, eval.Format(eval.Simplify(d)))
+	return nil
+}
+
+// splitAssign splits an assignment or definition "lhs = rhs" at its
+// first '=' sign that is not part of an operator such as "==" or "<=".
+func splitAssign(line string) (lhs, rhs string, ok bool) {
+	for i := 0; i < len(line); i++ {
+		if line[i] != '=' {
+			continue
+		}
+		if i+1 < len(line) && line[i+1] == '=' {
+			i++ // skip "=="
+			continue
+		}
+		if i > 0 && strings.IndexByte("<>!=", line[i-1]) >= 0 {
+			continue
+		}
+		return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+	}
+	return "", "", false
+}
+
+// isIdent reports whether s is a single identifier.
+func isIdent(s string) bool {
+	var sc scanner.Scanner
+	sc.Init(strings.NewReader(s))
+	sc.Error = func(*scanner.Scanner, string) {}
+	return sc.Scan() == scanner.Ident && sc.Scan() == scanner.EOF
+}
+
+/*
+//!+output
+$ go run ./ch7/evalrepl
+> x = 3
+> y = x * 2
+> sqrt(x*x + y*y) > 6 ? 1 : 0
+1
+> hyp(a, b) = sqrt(a*a + b*b)
+> hyp(x, 4)
+5
+> x*x + sin(y)
+8.720584501801074
+> :diff x
+(2 * x)
+> :check frob(z)
+1:1: unknown function "frob"
+1:6: undefined variable z
+> :vars
+x = 3
+y = 6
+//!-output
+*/
@@ -0,0 +1,74 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	const input = `x = 3
+y = x * 2
+sqrt(x*x + y*y) > 6 ? 1 : 0
+hyp(a, b) = sqrt(a*a + b*b)
+hyp(x, 4)
+hyp(a, b) = a + b
+hyp(x, 4)
+sin(a) = a
+sin(0) + 1
+x*x + sin(y)
+:diff x
+:check frob(z)
+z + 1
+:vars
+:bogus
+`
+	const want = `> > > 1
+> > 5
+> > 7
+> cannot redefine built-in function sin
+> 1
+> 8.720584501801074
+> (2 * x)
+> 1:1: unknown function "frob"
+1:6: undefined variable z
+> 1:1: undefined variable z
+> x = 3
+y = 6
+> unknown command :bogus (try :help)
+>` + " \n" // the final prompt, then a newline at end of input
+	out := new(bytes.Buffer)
+	newREPL(out).run(strings.NewReader(input))
+	if got := out.String(); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	const input = `f(a) = a
+g(a) = f(a)
+f(a) = g(a)
+f(1)
+`
+	const want = `> > > recursive call to g
+> 1
+>` + " \n"
+	out := new(bytes.Buffer)
+	newREPL(out).run(strings.NewReader(input))
+	if got := out.String(); got != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHelp(t *testing.T) {
+	out := new(bytes.Buffer)
+	newREPL(out).run(strings.NewReader(":help\n"))
+	for _, cmd := range []string{":vars", ":check", ":tree", ":diff", ":help"} {
+		if !strings.Contains(out.String(), "\n"+cmd+" ") {
+			t.Errorf(":help does not describe %s", cmd)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+// Surface is a web server that renders an SVG image of a 3-D surface
+// z = f(x, y), where f is an expression of package eval in the
+// variables x, y and r (the distance from the origin).
+//
+// Query parameters:
+//
+//	expr                     the expression to plot (required)
+//	cells                    number of grid cells along each axis (default 100)
+//	xmin, xmax, ymin, ymax   the ranges of x and y (default -30 to 30)
+//	width, height            canvas size in pixels (default 600 by 320)
+//	zscale                   pixels per z unit (default: fit the canvas)
+//
+// Cells are colored from blue (lowest) to red (highest) by height.
+// A malformed expression yields 400 Bad Request with the error.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"go_example/ch7/eval"
+)
+
+// go run ./ch7/surface
+// 网页访问：http://localhost:8000/plot?expr=sin(r)/r
+// http://localhost:8000/plot?expr=pow(2,sin(y))*pow(2,sin(x))/12&xmin=-10&xmax=10&ymin=-10&ymax=10
+func main() {
+	http.HandleFunc("/plot", plot)
+	log.Fatal(http.ListenAndServe("localhost:8000", nil))
+}
+
+const angle = math.Pi / 6 // angle of x, y axes (=30°)
+
+var sin30, cos30 = math.Sin(angle), math.Cos(angle) // sin(30°), cos(30°)
+
+// params holds the parameters of a plot.
+type params struct {
+	cells                  int
+	xmin, xmax, ymin, ymax float64
+	width, height          int
+	zscale                 float64 // 0 means fit the canvas
+}
+
+func plot(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f, err := parseFunc(req.Form.Get("expr"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := parseParams(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	surface(w, f, p)
+}
+
+// parseFunc parses and checks the expression and
+// returns a function that evaluates it.
+func parseFunc(input string) (func(x, y float64) float64, error) {
+	if input == "" {
+		return nil, fmt.Errorf("missing expr parameter")
+	}
+	expr, err := eval.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	if errs := eval.Check(expr, map[eval.Var]bool{"x": true, "y": true, "r": true}); errs != nil {
+		return nil, errs
+	}
+	prog, err := eval.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	// Find the slot of each variable the expression uses.
+	xslot, yslot, rslot := -1, -1, -1
+	for i, v := range prog.Vars {
+		switch v {
+		case "x":
+			xslot = i
+		case "y":
+			yslot = i
+		case "r":
+			rslot = i
+		}
+	}
+	slots := make([]float64, len(prog.Vars))
+	return func(x, y float64) float64 {
+		if xslot >= 0 {
+			slots[xslot] = x
+		}
+		if yslot >= 0 {
+			slots[yslot] = y
+		}
+		if rslot >= 0 {
+			slots[rslot] = math.Hypot(x, y)
+		}
+		return prog.Eval(slots)
+	}, nil
+}
+
+func parseParams(req *http.Request) (params, error) {
+	p := params{
+		cells: 100,
+		xmin:  -30, xmax: 30, ymin: -30, ymax: 30,
+		width: 600, height: 320,
+	}
+	for _, f := range []struct {
+		name     string
+		ptr      interface{}
+		min, max float64
+	}{
+		{"cells", &p.cells, 1, 1000},
+		{"xmin", &p.xmin, -1e6, 1e6},
+		{"xmax", &p.xmax, -1e6, 1e6},
+		{"ymin", &p.ymin, -1e6, 1e6},
+		{"ymax", &p.ymax, -1e6, 1e6},
+		{"width", &p.width, 1, 10000},
+		{"height", &p.height, 1, 10000},
+		{"zscale", &p.zscale, 0, 1e6},
+	} {
+		s := req.Form.Get(f.name)
+		if s == "" {
+			continue
+		}
+		var v float64
+		var err error
+		switch f.ptr.(type) {
+		case *int:
+			var i int
+			i, err = strconv.Atoi(s)
+			v = float64(i)
+		case *float64:
+			v, err = strconv.ParseFloat(s, 64)
+		}
+		if err != nil {
+			return p, fmt.Errorf("%s: %v", f.name, err)
+		}
+		if !(f.min <= v && v <= f.max) {
+			return p, fmt.Errorf("%s: %s out of range [%g, %g]", f.name, s, f.min, f.max)
+		}
+		switch ptr := f.ptr.(type) {
+		case *int:
+			*ptr = int(v)
+		case *float64:
+			*ptr = v
+		}
+	}
+	if p.xmin >= p.xmax || p.ymin >= p.ymax {
+		return p, fmt.Errorf("empty range")
+	}
+	return p, nil
+}
+
+// A polygon is one cell of the surface, projected onto the canvas.
+type polygon struct {
+	x, y [4]float64 // SVG coordinates of the corners
+	z    float64    // mean height of the corners
+}
+
+// surface writes to out an SVG rendering of z = f(x, y).
+func surface(out io.Writer, f func(x, y float64) float64, p params) {
+	// Sample the function once at each grid point.
+	n := p.cells + 1
+	z := make([]float64, n*n)
+	zmin, zmax := math.Inf(+1), math.Inf(-1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			x := p.xmin + (p.xmax-p.xmin)*float64(i)/float64(p.cells)
+			y := p.ymin + (p.ymax-p.ymin)*float64(j)/float64(p.cells)
+			v := f(x, y)
+			z[i*n+j] = v
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				zmin, zmax = math.Min(zmin, v), math.Max(zmax, v)
+			}
+		}
+	}
+	if zmin > zmax { // no finite values
+		zmin, zmax = 0, 0
+	}
+	zscale := p.zscale
+	if zscale == 0 {
+		zscale = float64(p.height) * 0.4
+		if zmax > zmin {
+			zscale /= math.Max(math.Abs(zmin), math.Abs(zmax))
+		}
+	}
+
+	// project maps grid point (i, j) to canvas coordinates.
+	xyscale := float64(p.width) / 2 / float64(p.cells)
+	project := func(i, j int) (float64, float64) {
+		// Project (i, j, z) isometrically onto 2-D SVG canvas (sx, sy).
+		cx, cy := float64(i)-float64(p.cells)/2, float64(j)-float64(p.cells)/2
+		sx := float64(p.width)/2 + (cx-cy)*cos30*xyscale
+		sy := float64(p.height)/2 + (cx+cy)*sin30*xyscale - z[i*n+j]*zscale
+		return sx, sy
+	}
+
+	fmt.Fprintf(out, "<svg xmlns='http://www.w3.org/2000/svg' "+
+		"style='stroke: grey; stroke-width: 0.7' "+
+		"width='%d' height='%d'>\n", p.width, p.height)
+	for i := 0; i < p.cells; i++ {
+		for j := 0; j < p.cells; j++ {
+			var poly polygon
+			ok := true
+			for k, c := range [4][2]int{{i + 1, j}, {i, j}, {i, j + 1}, {i + 1, j + 1}} {
+				v := z[c[0]*n+c[1]]
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					ok = false // skip cells with singularities
+					break
+				}
+				poly.x[k], poly.y[k] = project(c[0], c[1])
+				poly.z += v / 4
+			}
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(out, "<polygon points='%g,%g %g,%g %g,%g %g,%g' fill='%s'/>\n",
+				poly.x[0], poly.y[0], poly.x[1], poly.y[1],
+				poly.x[2], poly.y[2], poly.x[3], poly.y[3],
+				color(poly.z, zmin, zmax))
+		}
+	}
+	fmt.Fprintln(out, "</svg>")
+}
+
+// color returns an SVG color for height z, shading
+// from blue at zmin to red at zmax.
+func color(z, zmin, zmax float64) string {
+	t := 0.5
+	if zmax > zmin {
+		t = (z - zmin) / (zmax - zmin)
+	}
+	return fmt.Sprintf("#%02x00%02x", int(255*t), int(255*(1-t)))
+}
@@ -0,0 +1,37 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlot(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		code  int
+		want  string // substring of the body
+	}{
+		{"expr=sin(r)/r&cells=3", http.StatusOK, "<polygon points="},
+		{"expr=x*y&cells=3&width=300&height=200", http.StatusOK, "width='300' height='200'"},
+		{"cells=10", http.StatusBadRequest, "missing expr parameter"},
+		{"expr=x%2B&cells=10", http.StatusBadRequest, "unexpected"},
+		{"expr=z", http.StatusBadRequest, "undefined variable z"},
+		{"expr=x&cells=2.5", http.StatusBadRequest, `cells: strconv.Atoi: parsing "2.5": invalid syntax`},
+		{"expr=x&width=1e3", http.StatusBadRequest, "width: strconv.Atoi"},
+		{"expr=x&cells=0", http.StatusBadRequest, "cells: 0 out of range [1, 1000]"},
+		{"expr=x&xmin=1&xmax=1", http.StatusBadRequest, "empty range"},
+	} {
+		req := httptest.NewRequest("GET", "/plot?"+test.query, nil)
+		rec := httptest.NewRecorder()
+		plot(rec, req)
+		if rec.Code != test.code || !strings.Contains(rec.Body.String(), test.want) {
+			t.Errorf("%s: got %d %q, want %d with %q",
+				test.query, rec.Code, rec.Body.String(), test.code, test.want)
+		}
+	}
+}